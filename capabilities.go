@@ -0,0 +1,131 @@
+package stomp
+
+import (
+	"strings"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+)
+
+// Broker families recognised from the "server" header entry
+// of the CONNECTED frame.
+const (
+	BrokerUnknown         = ""
+	BrokerActiveMQ        = "activemq"
+	BrokerActiveMQArtemis = "artemis"
+	BrokerRabbitMQ        = "rabbitmq"
+	BrokerApollo          = "apollo"
+	BrokerStompd          = "stompd"
+)
+
+// Capabilities describes the features supported by the STOMP server,
+// as detected during the connect sequence. The detection is based on
+// the negotiated protocol version, the heart-beat agreement and the
+// well-known values of the "server" header entry. Features of brokers
+// that are not recognised are reported conservatively, and can be
+// specified using the ConnOpt.Capabilities connect option.
+//
+// The heart-beat intervals are the values in the CONNECTED frame, which
+// the connection uses after allowing for ConnOpt.HeartBeatError.
+type Capabilities struct {
+	Family               string        // Broker family, one of the Broker constants
+	Version              string        // Broker version, if reported by the server
+	Protocol             Version       // Negotiated STOMP protocol version
+	AckModes             []AckMode     // Supported acknowledgement modes
+	Nack                 bool          // NACK frames are supported
+	TempQueues           bool          // Temporary queues ("/temp-queue/...") are supported
+	Selectors            bool          // Message selectors ("selector" header) are supported
+	DurableSubscriptions bool          // Durable topic subscriptions are supported
+	PrefetchHeader       string        // SUBSCRIBE header that limits unacknowledged messages, if any
+	HeartBeatSend        time.Duration // Agreed interval for sending heart-beats, zero if none
+	HeartBeatReceive     time.Duration // Agreed interval for receiving heart-beats, zero if none
+	Compression          string        // Agreed compression method, empty if none
+}
+
+// SupportsAck returns true if the acknowledgement mode is supported.
+func (caps *Capabilities) SupportsAck(ack AckMode) bool {
+	for _, a := range caps.AckModes {
+		if a == ack {
+			return true
+		}
+	}
+	return false
+}
+
+// Capabilities returns the features supported by the STOMP server.
+func (c *Conn) Capabilities() Capabilities {
+	caps := c.capabilities
+	caps.AckModes = append([]AckMode(nil), caps.AckModes...)
+	return caps
+}
+
+// detectCapabilities determines the capabilities of the server from the
+// CONNECTED frame header. The heart-beat intervals are the values that
+// the connection agreed with the server, in the client's send, receive
+// order.
+func detectCapabilities(header *frame.Header, version Version, send, receive time.Duration) Capabilities {
+	caps := Capabilities{Protocol: version}
+	caps.Family, caps.Version = parseServer(header.Get(frame.Server))
+
+	caps.AckModes = []AckMode{AckAuto, AckClient}
+	if version.SupportsNack() {
+		// client-individual was introduced with NACK in STOMP 1.1
+		caps.AckModes = append(caps.AckModes, AckClientIndividual)
+		caps.Nack = true
+	}
+
+	switch caps.Family {
+	case BrokerActiveMQ:
+		caps.TempQueues = true
+		caps.Selectors = true
+		caps.DurableSubscriptions = true
+		caps.PrefetchHeader = "activemq.prefetchSize"
+	case BrokerActiveMQArtemis:
+		caps.Selectors = true
+		caps.DurableSubscriptions = true
+		caps.PrefetchHeader = "consumer-window-size"
+	case BrokerRabbitMQ:
+		caps.TempQueues = true
+		caps.DurableSubscriptions = true
+		caps.PrefetchHeader = "prefetch-count"
+	case BrokerApollo:
+		caps.TempQueues = true
+		caps.Selectors = true
+		caps.DurableSubscriptions = true
+		caps.PrefetchHeader = "credit"
+	}
+
+	caps.Compression = header.Get(frame.Compression)
+	caps.HeartBeatSend = send
+	caps.HeartBeatReceive = receive
+
+	return caps
+}
+
+// parseServer splits a server header value of the form
+// "name/version comments" into the broker family and version.
+func parseServer(server string) (family, version string) {
+	name := server
+	if i := strings.IndexByte(server, ' '); i >= 0 {
+		name = server[:i]
+	}
+	if i := strings.IndexByte(name, '/'); i >= 0 {
+		name, version = name[:i], name[i+1:]
+	}
+
+	switch strings.ToLower(name) {
+	case "activemq":
+		family = BrokerActiveMQ
+	case "activemq-artemis", "artemis":
+		family = BrokerActiveMQArtemis
+	case "rabbitmq":
+		family = BrokerRabbitMQ
+	case "apache-apollo", "apollo":
+		family = BrokerApollo
+	case "stompd":
+		family = BrokerStompd
+	default:
+		family = BrokerUnknown
+	}
+	return family, version
+}
@@ -0,0 +1,112 @@
+package stomp
+
+import (
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/go-stomp/stomp/v3/testutil"
+	. "gopkg.in/check.v1"
+)
+
+// connectWithResponse connects to a fake server that replies to
+// the CONNECT frame with response, then disconnects.
+func connectWithResponse(c *C, response *frame.Frame, opts ...func(*Conn) error) Capabilities {
+	fc1, fc2 := testutil.NewFakeConn(c)
+	stop := make(chan struct{})
+
+	go func() {
+		defer func() {
+			fc2.Close()
+			close(stop)
+		}()
+		reader := frame.NewReader(fc2)
+		writer := frame.NewWriter(fc2)
+
+		f1, err := reader.Read()
+		c.Assert(err, IsNil)
+		c.Assert(f1.Command, Equals, frame.CONNECT)
+		err = writer.Write(response)
+		c.Assert(err, IsNil)
+	}()
+
+	conn, err := Connect(fc1, opts...)
+	c.Assert(err, IsNil)
+	<-stop
+	conn.MustDisconnect()
+	return conn.Capabilities()
+}
+
+func (s *StompSuite) Test_capabilities_detect(c *C) {
+	testcases := []struct {
+		Server       string
+		Version      Version
+		Family       string
+		BrokerVer    string
+		TempQueues   bool
+		Selectors    bool
+		Prefetch     string
+		AckModeCount int
+	}{
+		{"ActiveMQ/5.15.9", V12, BrokerActiveMQ, "5.15.9", true, true, "activemq.prefetchSize", 3},
+		{"ActiveMQ-Artemis/2.17.0 ActiveMQ Artemis Messaging Engine", V12, BrokerActiveMQArtemis, "2.17.0", false, true, "consumer-window-size", 3},
+		{"RabbitMQ/3.8.9", V11, BrokerRabbitMQ, "3.8.9", true, false, "prefetch-count", 3},
+		{"apache-apollo/1.7.1", V12, BrokerApollo, "1.7.1", true, true, "credit", 3},
+		{"stompd", V12, BrokerStompd, "", false, false, "", 3},
+		{"some-server", V10, BrokerUnknown, "", false, false, "", 2},
+		{"", V10, BrokerUnknown, "", false, false, "", 2},
+	}
+
+	for _, tc := range testcases {
+		response := frame.New(frame.CONNECTED, frame.Version, tc.Version.String())
+		if tc.Server != "" {
+			response.Header.Add(frame.Server, tc.Server)
+		}
+		caps := connectWithResponse(c, response)
+		c.Check(caps.Family, Equals, tc.Family, Commentf("server %q", tc.Server))
+		c.Check(caps.Version, Equals, tc.BrokerVer)
+		c.Check(caps.Protocol, Equals, tc.Version)
+		c.Check(caps.TempQueues, Equals, tc.TempQueues)
+		c.Check(caps.Selectors, Equals, tc.Selectors)
+		c.Check(caps.PrefetchHeader, Equals, tc.Prefetch)
+		c.Check(caps.AckModes, HasLen, tc.AckModeCount)
+		c.Check(caps.Nack, Equals, tc.Version != V10)
+		c.Check(caps.SupportsAck(AckClientIndividual), Equals, tc.Version != V10)
+	}
+}
+
+func (s *StompSuite) Test_capabilities_heart_beat(c *C) {
+	response := frame.New(frame.CONNECTED,
+		frame.Version, V12.String(),
+		frame.HeartBeat, "5000,20000")
+	caps := connectWithResponse(c, response,
+		ConnOpt.HeartBeat(10*time.Second, 30*time.Second))
+	c.Check(caps.HeartBeatSend, Equals, 20*time.Second)
+	c.Check(caps.HeartBeatReceive, Equals, 5*time.Second)
+
+	// the server does not send heart-beats
+	response = frame.New(frame.CONNECTED,
+		frame.Version, V12.String(),
+		frame.HeartBeat, "0,20000")
+	caps = connectWithResponse(c, response,
+		ConnOpt.HeartBeat(10*time.Second, 30*time.Second))
+	c.Check(caps.HeartBeatSend, Equals, 20*time.Second)
+	c.Check(caps.HeartBeatReceive, Equals, time.Duration(0))
+}
+
+func (s *StompSuite) Test_capabilities_override(c *C) {
+	response := frame.New(frame.CONNECTED,
+		frame.Version, V12.String(),
+		frame.Server, "custom-broker/1.0")
+	caps := connectWithResponse(c, response,
+		ConnOpt.Capabilities(func(caps *Capabilities) {
+			c.Check(caps.Family, Equals, BrokerUnknown)
+			caps.Family = "custom"
+			caps.Selectors = true
+			caps.PrefetchHeader = "prefetch"
+		}))
+	c.Check(caps.Family, Equals, "custom")
+	c.Check(caps.Version, Equals, "1.0")
+	c.Check(caps.Selectors, Equals, true)
+	c.Check(caps.TempQueues, Equals, false)
+	c.Check(caps.PrefetchHeader, Equals, "prefetch")
+}
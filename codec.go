@@ -0,0 +1,28 @@
+package stomp
+
+import (
+	"encoding/json"
+)
+
+// A Codec encodes values into message bodies. Codecs are used by
+// a Producer to send values that are not already a []byte or string.
+type Codec interface {
+	// ContentType returns the MIME content type of encoded values.
+	ContentType() string
+
+	// Marshal returns the encoding of v.
+	Marshal(v interface{}) ([]byte, error)
+}
+
+// JSONCodec encodes values as JSON using the encoding/json package.
+var JSONCodec Codec = jsonCodec{}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string {
+	return "application/json"
+}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
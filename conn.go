@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/go-stomp/stomp/v3/selector"
 )
 
 // Default time span to add to read/write heart-beat timeouts
@@ -36,6 +37,7 @@ type Conn struct {
 	version                  Version
 	session                  string
 	server                   string
+	capabilities             Capabilities
 	readTimeout              time.Duration
 	writeTimeout             time.Duration
 	msgSendTimeout           time.Duration
@@ -45,6 +47,7 @@ type Conn struct {
 	closed                   bool
 	closeMutex               *sync.Mutex
 	options                  *connOptions
+	resume                   *resumeState // nil unless the session is resumable
 	log                      Logger
 }
 
@@ -69,9 +72,18 @@ func Dial(network, addr string, opts ...func(*Conn) error) (*Conn, error) {
 		return nil, err
 	}
 
+	// Connect to the same address again to resume a resumable session,
+	// unless a redial function has been explicitly specified.
+	redial := func(c *Conn) error {
+		c.options.Redial = func() (io.ReadWriteCloser, error) {
+			return net.Dial(network, addr)
+		}
+		return nil
+	}
+
 	// Add option to set host and make it the first option in list,
 	// so that if host has been explicitly specified it will override.
-	opts = append([]func(*Conn) error{ConnOpt.Host(host)}, opts...)
+	opts = append([]func(*Conn) error{ConnOpt.Host(host), redial}, opts...)
 
 	return Connect(c, opts...)
 }
@@ -81,9 +93,6 @@ func Dial(network, addr string, opts ...func(*Conn) error) (*Conn, error) {
 // been created by the program. The opts parameter provides the
 // opportunity to specify STOMP protocol options.
 func Connect(conn io.ReadWriteCloser, opts ...func(*Conn) error) (*Conn, error) {
-	reader := frame.NewReader(conn)
-	writer := frame.NewWriter(conn)
-
 	c := &Conn{
 		conn:       conn,
 		closeMutex: &sync.Mutex{},
@@ -96,12 +105,8 @@ func Connect(conn io.ReadWriteCloser, opts ...func(*Conn) error) (*Conn, error)
 
 	c.log = options.Logger
 
-	if options.ReadBufferSize > 0 {
-		reader = frame.NewReaderSize(conn, options.ReadBufferSize)
-	}
-
-	if options.WriteBufferSize > 0 {
-		writer = frame.NewWriterSize(conn, options.ReadBufferSize)
+	if options.Resumable && options.Redial == nil {
+		return nil, ErrNoRedial
 	}
 
 	readChannelCapacity := 20
@@ -139,22 +144,10 @@ func Connect(conn io.ReadWriteCloser, opts ...func(*Conn) error) (*Conn, error)
 		return nil, err
 	}
 
-	err = writer.Write(connectFrame)
-	if err != nil {
-		return nil, err
-	}
-
-	response, err := reader.Read()
+	reader, writer, response, err := handshake(conn, connectFrame, options)
 	if err != nil {
 		return nil, err
 	}
-	if response == nil {
-		return nil, errors.New("unexpected empty frame")
-	}
-
-	if response.Command != frame.CONNECTED {
-		return nil, newError(response)
-	}
 
 	c.server = response.Header.Get(frame.Server)
 	c.session = response.Header.Get(frame.Session)
@@ -173,8 +166,11 @@ func Connect(conn io.ReadWriteCloser, opts ...func(*Conn) error) (*Conn, error)
 		c.version = V10
 	}
 
+	// heart-beat intervals agreed with the server, which are
+	// reported in the capabilities of the connection
+	var heartBeatSend, heartBeatReceive time.Duration
 	if heartBeat, ok := response.Header.Contains(frame.HeartBeat); ok {
-		readTimeout, writeTimeout, err := frame.ParseHeartBeat(heartBeat)
+		heartBeatReceive, heartBeatSend, err = frame.ParseHeartBeat(heartBeat)
 		if err != nil {
 			return nil, Error{
 				Message: err.Error(),
@@ -182,8 +178,8 @@ func Connect(conn io.ReadWriteCloser, opts ...func(*Conn) error) (*Conn, error)
 			}
 		}
 
-		c.readTimeout = readTimeout
-		c.writeTimeout = writeTimeout
+		c.readTimeout = heartBeatReceive
+		c.writeTimeout = heartBeatSend
 
 		if c.readTimeout > 0 {
 			// Add time to the read timeout to account for time
@@ -201,16 +197,78 @@ func Connect(conn io.ReadWriteCloser, opts ...func(*Conn) error) (*Conn, error)
 	c.rcvReceiptTimeout = options.RcvReceiptTimeout
 	c.disconnectReceiptTimeout = options.DisconnectReceiptTimeout
 
+	c.capabilities = detectCapabilities(response.Header, c.version,
+		heartBeatSend, heartBeatReceive)
+	if options.CapabilitiesCallback != nil {
+		options.CapabilitiesCallback(&c.capabilities)
+	}
+
 	if options.ResponseHeadersCallback != nil {
 		options.ResponseHeadersCallback(response.Header)
 	}
 
-	go readLoop(c, reader)
+	if token := response.Header.Get(frame.ResumeToken); options.Resumable && token != "" {
+		c.resume = newResumeState(conn, options, token)
+		c.conn = c.resume.conn
+	}
+
+	go readLoop(c, reader, c.readCh)
 	go processLoop(c, writer)
 
 	return c, nil
 }
 
+// Writes the connect frame to conn, and reads the response, which must
+// be a CONNECTED frame. Returns the reader and writer for the frames that
+// follow, which are compressed if the STOMP server has accepted the offer
+// to compress the connection.
+func handshake(conn io.ReadWriteCloser, connectFrame *frame.Frame, options *connOptions) (*frame.Reader, *frame.Writer, *frame.Frame, error) {
+	reader := frame.NewReader(conn)
+	writer := frame.NewWriter(conn)
+
+	if options.ReadBufferSize > 0 {
+		reader = frame.NewReaderSize(conn, options.ReadBufferSize)
+	}
+
+	if options.WriteBufferSize > 0 {
+		writer = frame.NewWriterSize(conn, options.ReadBufferSize)
+	}
+
+	err := writer.Write(connectFrame)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	response, err := reader.Read()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if response == nil {
+		return nil, nil, nil, errors.New("unexpected empty frame")
+	}
+
+	if response.Command != frame.CONNECTED {
+		return nil, nil, nil, newError(response)
+	}
+
+	switch compression := response.Header.Get(frame.Compression); {
+	case compression == "":
+		// not compressed
+	case compression == frame.CompressionDeflate && options.Compression:
+		reader.Deflate()
+		if err = writer.Deflate(); err != nil {
+			return nil, nil, nil, err
+		}
+	default:
+		return nil, nil, nil, Error{
+			Message: "unexpected compression: " + compression,
+			Frame:   response,
+		}
+	}
+
+	return reader, writer, response, nil
+}
+
 // Version returns the version of the STOMP protocol that
 // is being used to communicate with the STOMP server. This
 // version is negotiated with the server during the connect sequence.
@@ -237,14 +295,14 @@ func (c *Conn) Server() string {
 // readLoop is a goroutine that reads frames from the
 // reader and places them onto a channel for processing
 // by the processLoop goroutine
-func readLoop(c *Conn, reader *frame.Reader) {
+func readLoop(c *Conn, reader *frame.Reader, ch chan *frame.Frame) {
 	for {
 		f, err := reader.Read()
 		if err != nil {
-			close(c.readCh)
+			close(ch)
 			return
 		}
-		c.readCh <- f
+		ch <- f
 	}
 }
 
@@ -253,19 +311,100 @@ func readLoop(c *Conn, reader *frame.Reader) {
 func processLoop(c *Conn, writer *frame.Writer) {
 	channels := make(map[string]chan *frame.Frame)
 
+	// The read channel is replaced when a resumable session is resumed on
+	// a new connection. While the connection is lost, the session is resumed
+	// on another go-routine, and the write requests received in the meantime
+	// are kept until they can be written to the new connection. The SUBSCRIBE
+	// and UNSUBSCRIBE frames are written again to the new connection.
+	readCh := c.readCh
+	writeCh := c.writeCh
+	subscriptions := make(map[string]*frame.Frame)   // SUBSCRIBE frames, by subscription id
+	unsubscriptions := make(map[string]*frame.Frame) // UNSUBSCRIBE frames waiting for receipts
+	disconnecting := false
+	connected := true             // false while the connection is lost
+	var resumed chan resumeResult // result of resuming the session, nil if not resuming
+	var pending []writeRequest    // write requests received while the connection is lost
+
 	var readTimeoutChannel <-chan time.Time
 	var readTimer *time.Timer
 	var writeTimeoutChannel <-chan time.Time
 	var writeTimer *time.Timer
 
+	// Called when a frame cannot be written. Returns true if the session
+	// is resumed once the read loop has stopped, or false if processLoop
+	// should return.
+	lost := func(err error) bool {
+		if !c.resumable(disconnecting) {
+			sendError(channels, err)
+			return false
+		}
+		c.resume.conn.closeCurrent()
+		connected = false
+		if writeTimer != nil {
+			writeTimer.Stop()
+			writeTimer = nil
+			writeTimeoutChannel = nil
+		}
+		return true
+	}
+
+	// Writes the frame of a write request to the connection. Returns
+	// false if processLoop should return.
+	write := func(req writeRequest) bool {
+		if req.C != nil {
+			if receipt, ok := req.Frame.Header.Contains(frame.Receipt); ok {
+				// remember the channel for this receipt
+				channels[receipt] = req.C
+			}
+		}
+
+		// default is to always send a frame.
+		var sendFrame = true
+
+		switch req.Frame.Command {
+		case frame.SUBSCRIBE:
+			id, _ := req.Frame.Header.Contains(frame.Id)
+			channels[id] = req.C
+			subscriptions[id] = req.Frame
+
+			// if using a temp queue, map that destination as a known channel
+			// however, don't send the frame, it's most likely an invalid destination
+			// on the broker.
+			if replyTo, ok := req.Frame.Header.Contains(ReplyToHeader); ok {
+				channels[replyTo] = req.C
+				sendFrame = false
+			}
+
+		case frame.UNSUBSCRIBE:
+			id, _ := req.Frame.Header.Contains(frame.Id)
+			// is this trying to be too clever -- add a receipt
+			// header so that when the server responds with a
+			// RECEIPT frame, the corresponding channel will be closed
+			req.Frame.Header.Set(frame.Receipt, id)
+			delete(subscriptions, id)
+			unsubscriptions[id] = req.Frame
+
+		case frame.DISCONNECT:
+			disconnecting = true
+		}
+
+		// frame to send, if enabled
+		if sendFrame {
+			if err := writer.Write(req.Frame); err != nil {
+				return lost(err)
+			}
+		}
+		return true
+	}
+
 	defer c.MustDisconnect()
 
 	for {
-		if c.readTimeout > 0 && readTimer == nil {
+		if c.readTimeout > 0 && readTimer == nil && readCh != nil {
 			readTimer = time.NewTimer(time.Duration(float64(c.readTimeout) * c.hbGracePeriodMultiplier))
 			readTimeoutChannel = readTimer.C
 		}
-		if c.writeTimeout > 0 && writeTimer == nil {
+		if c.writeTimeout > 0 && writeTimer == nil && connected {
 			writeTimer = time.NewTimer(c.writeTimeout)
 			writeTimeoutChannel = writeTimer.C
 		}
@@ -273,19 +412,26 @@ func processLoop(c *Conn, writer *frame.Writer) {
 		select {
 		case <-readTimeoutChannel:
 			// Read timeout
+			if c.resumable(disconnecting) {
+				// the connection is lost, so close it, and resume
+				// the session once the read loop has stopped
+				c.resume.conn.closeCurrent()
+			}
 			continue
 
 		case <-writeTimeoutChannel:
 			// write timeout, send a heart-beat frame
 			err := writer.Write(nil)
 			if err != nil {
-				sendError(channels, err)
+				if lost(err) {
+					continue
+				}
 				return
 			}
 			writeTimer = nil
 			writeTimeoutChannel = nil
 
-		case f, ok := <-c.readCh:
+		case f, ok := <-readCh:
 			// stop the read timer
 			if readTimer != nil {
 				readTimer.Stop()
@@ -294,8 +440,24 @@ func processLoop(c *Conn, writer *frame.Writer) {
 			}
 
 			if !ok {
-				err := newErrorMessage("connection closed")
-				sendError(channels, err)
+				if c.resumable(disconnecting) {
+					// resume the session on another go-routine,
+					// and keep serving the write requests
+					readCh, connected = nil, false
+					if writeTimer != nil {
+						writeTimer.Stop()
+						writeTimer = nil
+						writeTimeoutChannel = nil
+					}
+					resumed = make(chan resumeResult, 1)
+					go func() {
+						w, ch, err := c.reconnect()
+						resumed <- resumeResult{writer: w, readCh: ch, err: err}
+					}()
+					continue
+				}
+				c.failPending(pending, ErrConnectionLost)
+				sendError(channels, newErrorMessage("connection closed"))
 				return
 			}
 
@@ -310,6 +472,7 @@ func processLoop(c *Conn, writer *frame.Writer) {
 					if ch, ok := channels[id]; ok {
 						ch <- f
 						delete(channels, id)
+						delete(unsubscriptions, id)
 						close(ch)
 					}
 				} else {
@@ -342,7 +505,35 @@ func processLoop(c *Conn, writer *frame.Writer) {
 				}
 			}
 
-		case req, ok := <-c.writeCh:
+		case res := <-resumed:
+			resumed = nil
+			if res.err != nil {
+				c.failPending(pending, res.err)
+				sendError(channels, res.err)
+				return
+			}
+			writer, readCh, connected = res.writer, res.readCh, true
+			c.failReceipts(channels, subscriptions, unsubscriptions)
+			if err := replay(writer, subscriptions, unsubscriptions); err != nil {
+				lost(err)
+				continue
+			}
+
+			// write the requests received while the connection was lost
+			requests := pending
+			pending = nil
+			for i, req := range requests {
+				if !connected {
+					pending = append(pending, requests[i:]...)
+					break
+				}
+				if !write(req) {
+					c.failPending(requests[i+1:], ErrConnectionLost)
+					return
+				}
+			}
+
+		case req, ok := <-writeCh:
 			// stop the write timeout
 			if writeTimer != nil {
 				writeTimer.Stop()
@@ -350,48 +541,33 @@ func processLoop(c *Conn, writer *frame.Writer) {
 				writeTimeoutChannel = nil
 			}
 			if !ok {
+				c.failPending(pending, ErrAlreadyClosed)
 				sendError(channels, errors.New("write channel closed"))
 				return
 			}
-			if req.C != nil {
-				if receipt, ok := req.Frame.Header.Contains(frame.Receipt); ok {
-					// remember the channel for this receipt
-					channels[receipt] = req.C
-				}
-			}
 
-			// default is to always send a frame.
-			var sendFrame = true
-
-			switch req.Frame.Command {
-			case frame.SUBSCRIBE:
-				id, _ := req.Frame.Header.Contains(frame.Id)
-				channels[id] = req.C
-
-				// if using a temp queue, map that destination as a known channel
-				// however, don't send the frame, it's most likely an invalid destination
-				// on the broker.
-				if replyTo, ok := req.Frame.Header.Contains(ReplyToHeader); ok {
-					channels[replyTo] = req.C
-					sendFrame = false
+			if !connected {
+				if req.Frame.Command == frame.DISCONNECT {
+					// stop resuming the session, which the STOMP server
+					// ends once its grace period has elapsed, and let
+					// Disconnect return, as there is no connection that
+					// the receipt could be read from
+					c.resume.conn.Close()
+					c.failPending(pending, ErrConnectionLost)
+					if req.C != nil {
+						c.sendResponse(req.C, frame.New(frame.RECEIPT))
+					}
+					sendError(channels, ErrConnectionLost)
+					return
 				}
-
-			case frame.UNSUBSCRIBE:
-				id, _ := req.Frame.Header.Contains(frame.Id)
-				// is this trying to be too clever -- add a receipt
-				// header so that when the server responds with a
-				// RECEIPT frame, the corresponding channel will be closed
-				req.Frame.Header.Set(frame.Receipt, id)
-
+				// written once the session has been resumed, or failed
+				// once the resume timeout has elapsed without resuming it
+				pending = append(pending, req)
+				continue
 			}
 
-			// frame to send, if enabled
-			if sendFrame {
-				err := writer.Write(req.Frame)
-				if err != nil {
-					sendError(channels, err)
-					return
-				}
+			if !write(req) {
+				return
 			}
 		}
 	}
@@ -410,7 +586,10 @@ func sendError(m map[string]chan *frame.Frame, err error) {
 // disconnection: it sends a DISCONNECT frame with a receipt header
 // element. Once the RECEIPT frame has been received, the connection
 // with the STOMP server is closed and any further attempt to write
-// to the server will fail.
+// to the server will fail. If the connection of a resumable session
+// has been lost, Disconnect stops resuming the session and returns
+// without waiting for a receipt, and the frames that were waiting to
+// be written are not sent.
 func (c *Conn) Disconnect() error {
 	c.closeMutex.Lock()
 	defer c.closeMutex.Unlock()
@@ -476,6 +655,12 @@ func (c *Conn) Send(destination, contentType string, body []byte, opts ...func(*
 		return err
 	}
 
+	return c.send(f)
+}
+
+// send writes a SEND frame to the write channel, and waits for the
+// receipt if the frame requests one. The caller must hold closeMutex.
+func (c *Conn) send(f *frame.Frame) error {
 	if _, ok := f.Header.Contains(frame.Receipt); ok {
 		// receipt required
 		request := writeRequest{
@@ -651,6 +836,27 @@ func (c *Conn) Subscribe(destination string, ack AckMode, opts ...func(*frame.Fr
 		subscribeFrame.Header.Add(frame.Id, id)
 	}
 
+	var sel *selector.Selector
+	if expr, ok := subscribeFrame.Header.Contains(localSelectorHeader); ok {
+		var err error
+		if sel, err = selector.Parse(expr); err != nil {
+			return nil, err
+		}
+		if ack == AckClient {
+			// acknowledging a filtered message would acknowledge
+			// the delivered messages before it, see LocalSelector
+			return nil, ErrCumulativeAck
+		}
+		subscribeFrame.Header.Del(localSelectorHeader)
+	}
+	_, nackFiltered := subscribeFrame.Header.Contains(nackFilteredHeader)
+	if nackFiltered {
+		if sel != nil && ack != AckAuto && !c.version.SupportsNack() {
+			return nil, ErrNackNotSupported
+		}
+		subscribeFrame.Header.Del(nackFilteredHeader)
+	}
+
 	request := writeRequest{
 		Frame: subscribeFrame,
 		C:     ch,
@@ -658,13 +864,20 @@ func (c *Conn) Subscribe(destination string, ack AckMode, opts ...func(*frame.Fr
 
 	closeMutex := &sync.Mutex{}
 	sub := &Subscription{
-		id:          id,
-		destination: destination,
-		conn:        c,
-		ackMode:     ack,
-		C:           make(chan *Message, 16),
-		closeMutex:  closeMutex,
-		closeCond:   sync.NewCond(closeMutex),
+		id:            id,
+		destination:   destination,
+		conn:          c,
+		ackMode:       ack,
+		C:             make(chan *Message, 16),
+		closeMutex:    closeMutex,
+		closeCond:     sync.NewCond(closeMutex),
+		selector:      sel,
+		nackFiltered:  nackFiltered,
+		selectorStats: &selectorStats{},
+	}
+	if sel != nil && ack != AckAuto {
+		sub.acks = make(chan *Message, 16)
+		go sub.ackLoop()
 	}
 	go sub.readLoop(ch)
 
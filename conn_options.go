@@ -2,6 +2,7 @@ package stomp
 
 import (
 	"fmt"
+	"io"
 	"strings"
 	"time"
 
@@ -13,6 +14,7 @@ import (
 // for connecting to the other server.
 type connOptions struct {
 	FrameCommand                              string
+	Compression                               bool
 	Host                                      string
 	ReadTimeout                               time.Duration
 	WriteTimeout                              time.Duration
@@ -27,7 +29,11 @@ type connOptions struct {
 	ReadChannelCapacity, WriteChannelCapacity int
 	ReadBufferSize, WriteBufferSize           int
 	ResponseHeadersCallback                   func(*frame.Header)
+	CapabilitiesCallback                      func(*Capabilities)
 	Logger                                    Logger
+	Resumable                                 bool
+	Redial                                    func() (io.ReadWriteCloser, error)
+	ResumeTimeout                             time.Duration
 }
 
 func newConnOptions(conn *Conn, opts []func(*Conn) error) (*connOptions, error) {
@@ -41,6 +47,7 @@ func newConnOptions(conn *Conn, opts []func(*Conn) error) (*connOptions, error)
 		RcvReceiptTimeout:              DefaultRcvReceiptTimeout,
 		DisconnectReceiptTimeout:       DefaultDisconnectReceiptTimeout,
 		Logger:                         log.StdLogger{},
+		ResumeTimeout:                  DefaultResumeTimeout,
 	}
 
 	// This is a slight of hand, attach the options to the Conn long
@@ -89,6 +96,16 @@ func (co *connOptions) NewFrame() (*frame.Frame, error) {
 	// accept-version
 	f.Header.Set(frame.AcceptVersion, strings.Join(co.AcceptVersions, ","))
 
+	// compression
+	if co.Compression {
+		f.Header.Set(frame.Compression, frame.CompressionDeflate)
+	}
+
+	// resumable session
+	if co.Resumable {
+		f.Header.Set(frame.Resumable, "true")
+	}
+
 	// custom header entries -- note that these do not override
 	// header values already set as they are added to the end of
 	// the header array
@@ -114,6 +131,13 @@ var ConnOpt struct {
 	// Note that using "STOMP" is only valid for STOMP version 1.1 and later.
 	UseStomp func(*Conn) error
 
+	// Compression is a connect option that offers to compress all data
+	// sent over the connection, after the connect sequence, using the
+	// DEFLATE algorithm. Compression is only used if the STOMP server
+	// accepts the offer. This is an extension to the STOMP protocol that
+	// is supported by the STOMP server in the server package.
+	Compression func(*Conn) error
+
 	// AcceptVersoin is a connect option that allows the client to
 	// specify one or more versions of the STOMP protocol that the
 	// client program is prepared to accept. If this option is not
@@ -189,8 +213,37 @@ var ConnOpt struct {
 	// ResponseHeaders lets you provide a callback function to get the headers from the CONNECT response
 	ResponseHeaders func(func(*frame.Header)) func(*Conn) error
 
+	// Capabilities lets you provide a callback function that can modify the
+	// capabilities detected for the STOMP server. This is useful for brokers
+	// that are not recognised, or that have been configured differently.
+	Capabilities func(func(*Capabilities)) func(*Conn) error
+
 	// Logger lets you provide a callback function that sets the logger used by a connection
 	Logger func(logger Logger) func(*Conn) error
+
+	// Resumable is a connect option that asks the STOMP server to keep the
+	// session when the connection is lost, so that the subscriptions and the
+	// messages that have not been acknowledged are not lost if the client
+	// connects again within the grace period of the server. When the
+	// connection is lost, the client calls redial to create a new network
+	// connection, and resumes the session. The subscriptions continue to
+	// receive messages on their channels, and messages received before the
+	// connection was lost can still be acknowledged. Messages that were not
+	// acknowledged can be received again, and requests that were waiting
+	// for a receipt fail with ErrConnectionLost.
+	//
+	// If redial is nil, Dial connects to the same network address again,
+	// and Connect returns ErrNoRedial. This is an extension to the STOMP
+	// protocol that is supported by the STOMP server in the server package,
+	// and the option has no effect with other STOMP servers.
+	Resumable func(redial func() (io.ReadWriteCloser, error)) func(*Conn) error
+
+	// ResumeTimeout is a connect option that specifies how long a client
+	// with a resumable session tries to connect again after the connection
+	// has been lost. Frames sent in the meantime are written once the session
+	// has been resumed, and the requests fail if it cannot be resumed. If not
+	// specified, the default is DefaultResumeTimeout.
+	ResumeTimeout func(timeout time.Duration) func(*Conn) error
 }
 
 func init() {
@@ -214,6 +267,11 @@ func init() {
 		return nil
 	}
 
+	ConnOpt.Compression = func(c *Conn) error {
+		c.options.Compression = true
+		return nil
+	}
+
 	ConnOpt.AcceptVersion = func(versions ...Version) func(*Conn) error {
 		return func(c *Conn) error {
 			for _, version := range versions {
@@ -315,6 +373,13 @@ func init() {
 		}
 	}
 
+	ConnOpt.Capabilities = func(callback func(*Capabilities)) func(*Conn) error {
+		return func(c *Conn) error {
+			c.options.CapabilitiesCallback = callback
+			return nil
+		}
+	}
+
 	ConnOpt.Logger = func(log Logger) func(*Conn) error {
 		return func(c *Conn) error {
 			if log != nil {
@@ -324,4 +389,21 @@ func init() {
 			return nil
 		}
 	}
+
+	ConnOpt.Resumable = func(redial func() (io.ReadWriteCloser, error)) func(*Conn) error {
+		return func(c *Conn) error {
+			c.options.Resumable = true
+			if redial != nil {
+				c.options.Redial = redial
+			}
+			return nil
+		}
+	}
+
+	ConnOpt.ResumeTimeout = func(timeout time.Duration) func(*Conn) error {
+		return func(c *Conn) error {
+			c.options.ResumeTimeout = timeout
+			return nil
+		}
+	}
 }
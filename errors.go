@@ -20,6 +20,10 @@ var (
 	ErrMsgReceiptTimeout        = newErrorMessage("msg receipt timeout")
 	ErrDisconnectReceiptTimeout = newErrorMessage("disconnect receipt timeout")
 	ErrNilOption                = newErrorMessage("nil option")
+	ErrNoCodec                  = newErrorMessage("no codec for message body")
+	ErrCumulativeAck            = newErrorMessage("cannot ack out of order for a subscription with ack:client")
+	ErrNoRedial                 = newErrorMessage("resumable session requires a redial function")
+	ErrConnectionLost           = newErrorMessage("connection lost before receipt")
 )
 
 // StompError implements the Error interface, and provides
@@ -0,0 +1,10 @@
+package frame
+
+// Valid values for the "compression" header entry. The compression
+// header entry is an extension to the STOMP protocol: the client lists
+// the compression methods that it supports in the CONNECT frame, and
+// the server includes the method that it has chosen in the CONNECTED
+// frame. All subsequent data is compressed in both directions.
+const (
+	CompressionDeflate = "deflate" // DEFLATE stream, flushed after each frame
+)
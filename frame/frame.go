@@ -36,3 +36,16 @@ func (f *Frame) Clone() *Frame {
 	}
 	return fc
 }
+
+// Size returns the approximate number of bytes of memory used by the frame,
+// which is the length of its command, header entries and body.
+func (f *Frame) Size() int {
+	size := len(f.Command) + len(f.Body)
+	if f.Header != nil {
+		for i := 0; i < f.Header.Len(); i++ {
+			key, value := f.Header.GetAt(i)
+			size += len(key) + len(value)
+		}
+	}
+	return size
+}
@@ -65,3 +65,12 @@ func (s *FrameSuite) TestClone(c *C) {
 		c.Check(f1.Body[i], Equals, f2.Body[i])
 	}
 }
+
+func (s *FrameSuite) TestSize(c *C) {
+	f := &Frame{Command: "AAAA"}
+	c.Check(f.Size(), Equals, 4)
+
+	f = New("AAAA", "bb", "ccc")
+	f.Body = []byte("12345")
+	c.Check(f.Size(), Equals, 14)
+}
@@ -29,6 +29,10 @@ const (
 	Subscription  = "subscription"
 	MessageId     = "message-id"
 	Message       = "message"
+	Compression   = "compression"
+	Resumable     = "resumable"
+	ResumeToken   = "resume-token"
+	Resumed       = "resumed"
 )
 
 // A Header represents the header part of a STOMP frame.
@@ -3,6 +3,7 @@ package frame
 import (
 	"bufio"
 	"bytes"
+	"compress/flate"
 	"errors"
 	"io"
 )
@@ -40,6 +41,15 @@ func NewReaderSize(reader io.Reader, bufferSize int) *Reader {
 	return &Reader{reader: bufio.NewReaderSize(reader, bufferSize)}
 }
 
+// Deflate causes all subsequent input to be decompressed from a
+// DEFLATE stream. Any input that has already been buffered is
+// included in the compressed stream.
+func (r *Reader) Deflate() {
+	// bufio.Reader implements io.ByteReader, so the decompressor
+	// does not read past the end of the compressed stream
+	r.reader = bufio.NewReaderSize(flate.NewReader(r.reader), r.reader.Size())
+}
+
 // Read a STOMP frame from the input. If the input contains one
 // or more heart-beat characters and no frame, then nil will
 // be returned for the frame. Calling programs should always check
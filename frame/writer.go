@@ -2,6 +2,7 @@ package frame
 
 import (
 	"bufio"
+	"compress/flate"
 	"io"
 )
 
@@ -15,7 +16,9 @@ var (
 
 // Writes STOMP frames to an underlying io.Writer.
 type Writer struct {
-	writer *bufio.Writer
+	writer     *bufio.Writer
+	compressor *flate.Writer // compresses frames, if not nil
+	output     *bufio.Writer // buffers compressed output, if compressor is not nil
 }
 
 // Creates a new Writer object, which writes to an underlying io.Writer.
@@ -96,5 +99,33 @@ func (w *Writer) Write(f *Frame) error {
 		return err
 	}
 
+	if w.compressor != nil {
+		// flush so that the peer can decompress the whole frame
+		err = w.compressor.Flush()
+		if err != nil {
+			return err
+		}
+		err = w.output.Flush()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Deflate causes all subsequent frames to be compressed into a
+// DEFLATE stream. The stream is flushed after each frame is written.
+func (w *Writer) Deflate() error {
+	if w.compressor != nil {
+		return nil
+	}
+	compressor, err := flate.NewWriter(w.writer, flate.DefaultCompression)
+	if err != nil {
+		return err
+	}
+	w.output = w.writer
+	w.compressor = compressor
+	w.writer = bufio.NewWriterSize(compressor, w.output.Size())
 	return nil
 }
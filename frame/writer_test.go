@@ -46,3 +46,34 @@ func writeToBufferAndCheck(c *C, frameText string) {
 	c.Check(newFrameText, Equals, frameText)
 	c.Check(b.String(), Equals, frameText)
 }
+
+func (s *WriterSuite) TestDeflate(c *C) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	r := NewReader(&buf)
+
+	// the first frame is not compressed
+	c.Assert(w.Write(New(CONNECTED, Compression, CompressionDeflate)), IsNil)
+	c.Assert(w.Deflate(), IsNil)
+	c.Assert(w.Write(New(MESSAGE, Destination, "/queue/1")), IsNil)
+	c.Assert(w.Write(nil), IsNil)
+	c.Assert(w.Write(New(MESSAGE, Destination, "/queue/2")), IsNil)
+	prefix := "CONNECTED\ncompression:deflate\n\n\x00"
+	c.Check(strings.HasPrefix(buf.String(), prefix), Equals, true)
+	c.Check(strings.HasPrefix(buf.String()[len(prefix):], "MESSAGE"), Equals, false)
+
+	f, err := r.Read()
+	c.Assert(err, IsNil)
+	c.Check(f.Command, Equals, CONNECTED)
+	r.Deflate()
+
+	f, err = r.Read()
+	c.Assert(err, IsNil)
+	c.Check(f.Header.Get(Destination), Equals, "/queue/1")
+	f, err = r.Read()
+	c.Assert(err, IsNil)
+	c.Check(f, IsNil) // heart-beat
+	f, err = r.Read()
+	c.Assert(err, IsNil)
+	c.Check(f.Header.Get(Destination), Equals, "/queue/2")
+}
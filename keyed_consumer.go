@@ -0,0 +1,201 @@
+package stomp
+
+import (
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+)
+
+// Default parameters of a KeyedConsumer.
+const (
+	// Default number of messages handled concurrently.
+	// Override with KeyedOpt.Workers.
+	DefaultKeyedWorkers = 8
+
+	// Default maximum number of waiting messages with the same key.
+	// Override with KeyedOpt.Backlog.
+	DefaultKeyedBacklog = 16
+)
+
+// A KeyedConsumer handles the messages of a subscription concurrently,
+// while handling the messages that have the same key in the order in
+// which they were received. The key of a message is the value of a
+// header entry, such as "customer-id". Create a KeyedConsumer by calling
+// Subscription.KeyedConsumer.
+//
+// Messages are assigned to a fixed number of workers by their key, so
+// messages with different keys can also wait for each other. Messages
+// without the header entry all have the empty key, and are handled in
+// order.
+//
+// When the subscription requires acknowledgement, each message is acked
+// after its handler succeeds, and nacked after its handler fails. As
+// messages are acknowledged out of order, the subscription must use
+// AckClientIndividual rather than AckClient, for which an ACK frame
+// would acknowledge every earlier message, including those that are
+// still being handled.
+type KeyedConsumer struct {
+	sub     *Subscription
+	header  string
+	key     func(msg *Message) string
+	workers int
+	backlog int
+	stats   *keyedStats
+
+	mutex   sync.Mutex
+	cond    *sync.Cond
+	waiting map[string]int // messages waiting or being handled, by key
+}
+
+// KeyedConsumerStats contains counters for the messages
+// handled by a KeyedConsumer.
+type KeyedConsumerStats struct {
+	Handled uint64 // Number of messages handled successfully
+	Failed  uint64 // Number of messages for which the handler returned an error
+	Waiting uint64 // Number of messages read from the subscription, but not yet handled
+}
+
+type keyedStats struct {
+	handled, failed, waiting uint64
+}
+
+// A message and its key.
+type keyedMessage struct {
+	msg *Message
+	key string
+}
+
+// KeyedConsumer creates a KeyedConsumer for the messages of the
+// subscription, with the key of each message in the given header entry.
+// Options specified in opts control the number of workers and the
+// back-pressure applied to each key.
+func (s *Subscription) KeyedConsumer(header string, opts ...func(*KeyedConsumer)) *KeyedConsumer {
+	kc := &KeyedConsumer{
+		sub:     s,
+		header:  header,
+		workers: DefaultKeyedWorkers,
+		backlog: DefaultKeyedBacklog,
+		stats:   &keyedStats{},
+		waiting: make(map[string]int),
+	}
+	kc.cond = sync.NewCond(&kc.mutex)
+	kc.key = func(msg *Message) string {
+		return msg.Header.Get(kc.header)
+	}
+	for _, opt := range opts {
+		if opt != nil {
+			opt(kc)
+		}
+	}
+	return kc
+}
+
+// Stats returns the message counters for the consumer.
+func (kc *KeyedConsumer) Stats() KeyedConsumerStats {
+	return KeyedConsumerStats{
+		Handled: atomic.LoadUint64(&kc.stats.handled),
+		Failed:  atomic.LoadUint64(&kc.stats.failed),
+		Waiting: atomic.LoadUint64(&kc.stats.waiting),
+	}
+}
+
+// Run reads messages from the subscription and calls the handler for
+// each of them, until the subscription is unsubscribed or fails. The
+// handler is called from several goroutines, but never concurrently for
+// messages with the same key. Once no more messages can be read, Run
+// waits for the messages that have been read to be handled, and then
+// returns the error that ended the subscription, or nil if it was
+// unsubscribed.
+//
+// Returns ErrCumulativeAck without reading any messages
+// if the subscription uses AckClient.
+func (kc *KeyedConsumer) Run(handler func(msg *Message) error) error {
+	if kc.sub.AckMode() == AckClient {
+		return ErrCumulativeAck
+	}
+
+	queues := make([]chan keyedMessage, kc.workers)
+	var wg sync.WaitGroup
+	wg.Add(len(queues))
+	for i := range queues {
+		queues[i] = make(chan keyedMessage, kc.backlog)
+		go func(queue chan keyedMessage) {
+			defer wg.Done()
+			for km := range queue {
+				kc.handle(km, handler)
+			}
+		}(queues[i])
+	}
+
+	var err error
+	for msg := range kc.sub.C {
+		if msg.Err != nil {
+			err = msg.Err
+			break
+		}
+		key := kc.key(msg)
+		kc.acquire(key)
+		queues[shard(key, len(queues))] <- keyedMessage{msg: msg, key: key}
+	}
+
+	for _, queue := range queues {
+		close(queue)
+	}
+	wg.Wait()
+	return err
+}
+
+// Handles a message, and acknowledges it if required.
+func (kc *KeyedConsumer) handle(km keyedMessage, handler func(msg *Message) error) {
+	defer kc.release(km.key)
+
+	err := handler(km.msg)
+	if err == nil {
+		atomic.AddUint64(&kc.stats.handled, 1)
+	} else {
+		atomic.AddUint64(&kc.stats.failed, 1)
+	}
+
+	if !km.msg.ShouldAck() {
+		return
+	}
+	if err == nil {
+		err = km.msg.Conn.Ack(km.msg)
+	} else {
+		err = km.msg.Conn.Nack(km.msg)
+	}
+	if err != nil {
+		kc.sub.conn.log.Warningf("Subscription %s: %s: cannot acknowledge message: %s",
+			kc.sub.id, kc.sub.destination, err.Error())
+	}
+}
+
+// Waits until fewer than the backlog of messages with
+// the key are waiting, and adds a waiting message.
+func (kc *KeyedConsumer) acquire(key string) {
+	kc.mutex.Lock()
+	defer kc.mutex.Unlock()
+	for kc.waiting[key] >= kc.backlog {
+		kc.cond.Wait()
+	}
+	kc.waiting[key]++
+	atomic.AddUint64(&kc.stats.waiting, 1)
+}
+
+// Removes a waiting message with the key.
+func (kc *KeyedConsumer) release(key string) {
+	kc.mutex.Lock()
+	defer kc.mutex.Unlock()
+	if kc.waiting[key]--; kc.waiting[key] == 0 {
+		delete(kc.waiting, key)
+	}
+	atomic.AddUint64(&kc.stats.waiting, ^uint64(0))
+	kc.cond.Broadcast()
+}
+
+// Returns the worker that handles the messages with the key.
+func shard(key string, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(n))
+}
@@ -0,0 +1,43 @@
+package stomp
+
+// KeyedOpt contains options for the Subscription.KeyedConsumer function.
+var KeyedOpt struct {
+	// Workers specifies the number of messages that are handled
+	// concurrently. If not specified, DefaultKeyedWorkers is used.
+	Workers func(n int) func(*KeyedConsumer)
+
+	// Backlog specifies the maximum number of messages with the same
+	// key that are waiting to be handled. When a message would exceed
+	// it, no more messages are read from the subscription until one of
+	// the waiting messages has been handled. If not specified,
+	// DefaultKeyedBacklog is used.
+	Backlog func(n int) func(*KeyedConsumer)
+
+	// Key specifies a function that returns the key of a message,
+	// instead of the value of a header entry.
+	Key func(key func(msg *Message) string) func(*KeyedConsumer)
+}
+
+func init() {
+	KeyedOpt.Workers = func(n int) func(*KeyedConsumer) {
+		return func(kc *KeyedConsumer) {
+			if n > 0 {
+				kc.workers = n
+			}
+		}
+	}
+
+	KeyedOpt.Backlog = func(n int) func(*KeyedConsumer) {
+		return func(kc *KeyedConsumer) {
+			if n > 0 {
+				kc.backlog = n
+			}
+		}
+	}
+
+	KeyedOpt.Key = func(key func(msg *Message) string) func(*KeyedConsumer) {
+		return func(kc *KeyedConsumer) {
+			kc.key = key
+		}
+	}
+}
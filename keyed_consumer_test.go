@@ -0,0 +1,199 @@
+package stomp
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	. "gopkg.in/check.v1"
+)
+
+// Serves a subscription on rw: writes the messages, with the given
+// keys in the "customer-id" header entry, and reads n ACK or NACK frames
+// into acks, before handling UNSUBSCRIBE and DISCONNECT.
+func serveKeyed(c *C, rw *fakeReaderWriter, keys []string, n int, acks map[string]string, stop chan struct{}) {
+	defer func() {
+		rw.Close()
+		close(stop)
+	}()
+
+	f1, err := rw.Read()
+	c.Assert(err, IsNil)
+	c.Assert(f1.Command, Equals, frame.SUBSCRIBE)
+	id := f1.Header.Get(frame.Id)
+
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		for i, key := range keys {
+			messageId := fmt.Sprintf("m-%d", i)
+			f := frame.New(frame.MESSAGE,
+				frame.Subscription, id,
+				frame.MessageId, messageId,
+				frame.Ack, messageId,
+				frame.Destination, "/queue/orders",
+				"customer-id", key)
+			f.Body = []byte(messageId)
+			c.Assert(rw.Write(f), IsNil)
+		}
+	}()
+
+	for i := 0; i < n; i++ {
+		f, err := rw.Read()
+		c.Assert(err, IsNil)
+		acks[f.Header.Get(frame.Id)] = f.Command
+	}
+
+	f2, err := rw.Read()
+	c.Assert(err, IsNil)
+	c.Assert(f2.Command, Equals, frame.UNSUBSCRIBE)
+	<-written
+	c.Assert(rw.Write(frame.New(frame.RECEIPT, frame.ReceiptId, f2.Header.Get(frame.Receipt))), IsNil)
+
+	f3, err := rw.Read()
+	c.Assert(err, IsNil)
+	c.Assert(f3.Command, Equals, frame.DISCONNECT)
+	c.Assert(rw.Write(frame.New(frame.RECEIPT, frame.ReceiptId, f3.Header.Get(frame.Receipt))), IsNil)
+}
+
+// Waits until the consumer has handled n messages.
+func waitHandled(c *C, kc *KeyedConsumer, n uint64) {
+	for i := 0; i < 500; i++ {
+		stats := kc.Stats()
+		if stats.Handled+stats.Failed == n {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	c.Fatalf("timed out waiting for %d messages: %+v", n, kc.Stats())
+}
+
+func (s *StompSuite) Test_keyed_consumer(c *C) {
+	conn, rw := connectHelper(c, V12)
+	keys := make([]string, 30)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("customer-%d", i%5)
+	}
+	acks := make(map[string]string)
+	stop := make(chan struct{})
+	go serveKeyed(c, rw, keys, len(keys), acks, stop)
+
+	sub, err := conn.Subscribe("/queue/orders", AckClientIndividual)
+	c.Assert(err, IsNil)
+	kc := sub.KeyedConsumer("customer-id", KeyedOpt.Workers(3))
+
+	var mutex sync.Mutex
+	order := make(map[string][]string)
+	busy := make(map[string]bool)
+	done := make(chan error)
+	go func() {
+		done <- kc.Run(func(msg *Message) error {
+			key := msg.Header.Get("customer-id")
+			mutex.Lock()
+			c.Check(busy[key], Equals, false)
+			busy[key] = true
+			mutex.Unlock()
+
+			time.Sleep(time.Millisecond)
+
+			mutex.Lock()
+			busy[key] = false
+			order[key] = append(order[key], string(msg.Body))
+			mutex.Unlock()
+			if string(msg.Body) == "m-7" {
+				return errors.New("failed")
+			}
+			return nil
+		})
+	}()
+
+	waitHandled(c, kc, uint64(len(keys)))
+	c.Assert(sub.Unsubscribe(), IsNil)
+	c.Assert(<-done, IsNil)
+	c.Assert(conn.Disconnect(), IsNil)
+	<-stop
+
+	c.Check(kc.Stats(), Equals, KeyedConsumerStats{Handled: 29, Failed: 1})
+	for i := 0; i < 5; i++ {
+		key := fmt.Sprintf("customer-%d", i)
+		c.Check(order[key], DeepEquals, []string{
+			fmt.Sprintf("m-%d", i), fmt.Sprintf("m-%d", i+5), fmt.Sprintf("m-%d", i+10),
+			fmt.Sprintf("m-%d", i+15), fmt.Sprintf("m-%d", i+20), fmt.Sprintf("m-%d", i+25),
+		})
+	}
+	c.Check(acks, HasLen, len(keys))
+	for id, command := range acks {
+		if id == "m-7" {
+			c.Check(command, Equals, frame.NACK)
+		} else {
+			c.Check(command, Equals, frame.ACK, Commentf("message %s", id))
+		}
+	}
+}
+
+func (s *StompSuite) Test_keyed_consumer_back_pressure(c *C) {
+	conn, rw := connectHelper(c, V12)
+	stop := make(chan struct{})
+	go serveKeyed(c, rw, []string{"a", "a", "b"}, 0, nil, stop)
+
+	sub, err := conn.Subscribe("/queue/orders", AckAuto)
+	c.Assert(err, IsNil)
+	kc := sub.KeyedConsumer("customer-id", KeyedOpt.Workers(2), KeyedOpt.Backlog(1))
+
+	release := make(chan struct{})
+	var mutex sync.Mutex
+	var handled []string
+	done := make(chan error)
+	go func() {
+		done <- kc.Run(func(msg *Message) error {
+			if string(msg.Body) == "m-0" {
+				<-release
+			}
+			mutex.Lock()
+			handled = append(handled, string(msg.Body))
+			mutex.Unlock()
+			return nil
+		})
+	}()
+
+	// the second message for "a" waits for the first, and
+	// no more messages are read from the subscription
+	time.Sleep(50 * time.Millisecond)
+	mutex.Lock()
+	c.Check(handled, HasLen, 0)
+	mutex.Unlock()
+	c.Check(kc.Stats().Waiting, Equals, uint64(1))
+
+	close(release)
+	waitHandled(c, kc, 3)
+	mutex.Lock()
+	c.Check(handled, HasLen, 3)
+	c.Check(handled[0], Equals, "m-0")
+	mutex.Unlock()
+	c.Check(kc.Stats().Waiting, Equals, uint64(0))
+
+	c.Assert(sub.Unsubscribe(), IsNil)
+	c.Assert(<-done, IsNil)
+	c.Assert(conn.Disconnect(), IsNil)
+	<-stop
+}
+
+func (s *StompSuite) Test_keyed_consumer_ack_client(c *C) {
+	conn, rw := connectHelper(c, V12)
+	stop := make(chan struct{})
+	go serveKeyed(c, rw, nil, 0, nil, stop)
+
+	sub, err := conn.Subscribe("/queue/orders", AckClient)
+	c.Assert(err, IsNil)
+	err = sub.KeyedConsumer("customer-id").Run(func(msg *Message) error {
+		c.Errorf("unexpected message %v", msg)
+		return nil
+	})
+	c.Check(err, Equals, ErrCumulativeAck)
+
+	c.Assert(sub.Unsubscribe(), IsNil)
+	c.Assert(conn.Disconnect(), IsNil)
+	<-stop
+}
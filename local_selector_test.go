@@ -0,0 +1,150 @@
+package stomp
+
+import (
+	"fmt"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/go-stomp/stomp/v3/selector"
+	. "gopkg.in/check.v1"
+)
+
+// Serves a subscription on rw: writes n messages, with the message number
+// in the "priority" header entry, and reads the ACK or NACK frames for all
+// of them into acks, in order, and closes acked before handling UNSUBSCRIBE
+// and DISCONNECT.
+func serveSelected(c *C, rw *fakeReaderWriter, n int, acks *[]*frame.Frame, acked, stop chan struct{}) {
+	defer func() {
+		rw.Close()
+		close(stop)
+	}()
+
+	f1, err := rw.Read()
+	c.Assert(err, IsNil)
+	c.Assert(f1.Command, Equals, frame.SUBSCRIBE)
+	_, ok := f1.Header.Contains(localSelectorHeader)
+	c.Check(ok, Equals, false)
+	_, ok = f1.Header.Contains(nackFilteredHeader)
+	c.Check(ok, Equals, false)
+	id := f1.Header.Get(frame.Id)
+
+	// the messages are written while the acknowledgements are read,
+	// as the client stops reading while it cannot write
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		for i := 0; i < n; i++ {
+			messageId := fmt.Sprintf("m-%d", i)
+			f := frame.New(frame.MESSAGE,
+				frame.Subscription, id,
+				frame.MessageId, messageId,
+				frame.Ack, messageId,
+				frame.Destination, "/queue/selected",
+				"priority", fmt.Sprint(i))
+			c.Assert(rw.Write(f), IsNil)
+		}
+	}()
+
+	for i := 0; i < n; i++ {
+		f, err := rw.Read()
+		c.Assert(err, IsNil)
+		*acks = append(*acks, f)
+	}
+	<-written
+	close(acked)
+
+	f2, err := rw.Read()
+	c.Assert(err, IsNil)
+	c.Assert(f2.Command, Equals, frame.UNSUBSCRIBE)
+	c.Assert(rw.Write(frame.New(frame.RECEIPT, frame.ReceiptId, f2.Header.Get(frame.Receipt))), IsNil)
+
+	f3, err := rw.Read()
+	c.Assert(err, IsNil)
+	c.Assert(f3.Command, Equals, frame.DISCONNECT)
+	c.Assert(rw.Write(frame.New(frame.RECEIPT, frame.ReceiptId, f3.Header.Get(frame.Receipt))), IsNil)
+}
+
+func (s *StompSuite) Test_local_selector(c *C) {
+	for _, nack := range []bool{false, true} {
+		conn, rw := connectHelper(c, V12)
+		var frames []*frame.Frame
+		acked, stop := make(chan struct{}), make(chan struct{})
+		go serveSelected(c, rw, 6, &frames, acked, stop)
+
+		opts := []func(*frame.Frame) error{SubscribeOpt.LocalSelector("priority >= 3")}
+		filtered := frame.ACK
+		if nack {
+			opts = append(opts, SubscribeOpt.NackFiltered)
+			filtered = frame.NACK
+		}
+		sub, err := conn.Subscribe("/queue/selected", AckClientIndividual, opts...)
+		c.Assert(err, IsNil)
+		c.Check(sub.Selector(), Equals, "priority >= 3")
+
+		for i := 3; i < 6; i++ {
+			msg := <-sub.C
+			c.Assert(msg.Err, IsNil)
+			c.Check(msg.Header.Get(frame.MessageId), Equals, fmt.Sprintf("m-%d", i))
+			c.Assert(conn.Ack(msg), IsNil)
+		}
+		c.Check(sub.SelectorStats(), Equals, SelectorStats{Matched: 3, Filtered: 3})
+
+		<-acked
+		c.Assert(sub.Unsubscribe(), IsNil)
+		c.Assert(conn.Disconnect(), IsNil)
+		<-stop
+
+		acks := make(map[string]string)
+		for _, f := range frames {
+			acks[f.Header.Get(frame.Id)] = f.Command
+		}
+		c.Check(acks, DeepEquals, map[string]string{
+			"m-0": filtered, "m-1": filtered, "m-2": filtered,
+			"m-3": frame.ACK, "m-4": frame.ACK, "m-5": frame.ACK,
+		})
+	}
+}
+
+func (s *StompSuite) Test_local_selector_order(c *C) {
+	const n = 50
+	conn, rw := connectHelper(c, V12)
+	var frames []*frame.Frame
+	acked, stop := make(chan struct{}), make(chan struct{})
+	go serveSelected(c, rw, n, &frames, acked, stop)
+
+	sub, err := conn.Subscribe("/queue/selected", AckClientIndividual,
+		SubscribeOpt.LocalSelector(fmt.Sprintf("priority >= %d", n-1)))
+	c.Assert(err, IsNil)
+	msg := <-sub.C
+	c.Assert(msg.Err, IsNil)
+	c.Assert(conn.Ack(msg), IsNil)
+	<-acked
+	c.Assert(sub.Unsubscribe(), IsNil)
+	c.Assert(conn.Disconnect(), IsNil)
+	<-stop
+
+	// the filtered messages are acknowledged in the order received
+	var filtered []string
+	for _, f := range frames {
+		if id := f.Header.Get(frame.Id); id != msg.Header.Get(frame.Ack) {
+			filtered = append(filtered, id)
+		}
+	}
+	c.Assert(filtered, HasLen, n-1)
+	for i, id := range filtered {
+		c.Check(id, Equals, fmt.Sprintf("m-%d", i))
+	}
+}
+
+func (s *StompSuite) Test_local_selector_invalid(c *C) {
+	conn, rw := connectHelper(c, V12)
+	defer rw.Close()
+
+	_, err := conn.Subscribe("/queue/selected", AckAuto, SubscribeOpt.LocalSelector("priority >"))
+	c.Check(err, FitsTypeOf, &selector.SyntaxError{})
+	_, err = conn.Subscribe("/queue/selected", AckClient, SubscribeOpt.LocalSelector("priority > 3"))
+	c.Check(err, Equals, ErrCumulativeAck)
+
+	f := frame.New(frame.UNSUBSCRIBE)
+	c.Check(SubscribeOpt.LocalSelector("priority > 3")(f), Equals, ErrInvalidCommand)
+	c.Check(SubscribeOpt.NackFiltered(f), Equals, ErrInvalidCommand)
+}
@@ -0,0 +1,245 @@
+package stomp
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/go-stomp/stomp/v3/frame"
+)
+
+// The MultiSubscription type represents subscriptions to the same
+// destination on several connections, typically to independent brokers
+// that producers may publish to. It is created by calling SubscribeAll.
+//
+// The messages received by every subscription are merged into the C
+// channel. Each message keeps the Conn and Subscription it was received
+// from, so Ack and Nack are sent to the broker that delivered the message.
+//
+// When the subscription on one connection fails, for example because the
+// broker has gone away, the failure is recorded and messages continue to
+// be received from the other connections. Once every subscription has
+// failed, a message containing the last error is sent on C, and C is
+// closed.
+type MultiSubscription struct {
+	C           chan *Message
+	destination string
+	ackMode     AckMode
+	subs        []*Subscription
+	state       int32
+	mutex       sync.Mutex
+	errs        map[*Conn]error
+	lastErr     error
+	wg          sync.WaitGroup
+	done        chan struct{} // closed by Unsubscribe
+	closed      chan struct{} // closed when C is closed
+}
+
+// SubscribeAll subscribes to the same destination on each of the
+// connections, with the same acknowledgement mode and options. An
+// error is returned only if the subscription fails on every connection;
+// connections that fail are reported by Err.
+//
+// The options must not specify the "id" header entry, as the
+// subscription ids are allocated separately for each connection.
+func SubscribeAll(conns []*Conn, destination string, ack AckMode, opts ...func(*frame.Frame) error) (*MultiSubscription, error) {
+	ms := &MultiSubscription{
+		C:           make(chan *Message, 16),
+		destination: destination,
+		ackMode:     ack,
+		errs:        make(map[*Conn]error),
+		done:        make(chan struct{}),
+		closed:      make(chan struct{}),
+	}
+
+	var lastErr error = ErrCompletedSubscription
+	for _, conn := range conns {
+		sub, err := conn.Subscribe(destination, ack, opts...)
+		if err != nil {
+			ms.errs[conn] = err
+			lastErr = err
+			continue
+		}
+		ms.subs = append(ms.subs, sub)
+	}
+	if len(ms.subs) == 0 {
+		return nil, lastErr
+	}
+
+	ms.wg.Add(len(ms.subs))
+	for _, sub := range ms.subs {
+		go ms.forward(sub)
+	}
+	go ms.closeWhenDone()
+	return ms, nil
+}
+
+// Destination for which the subscriptions apply.
+func (ms *MultiSubscription) Destination() string {
+	return ms.destination
+}
+
+// AckMode returns the Acknowledgement mode specified when the
+// subscriptions were created.
+func (ms *MultiSubscription) AckMode() AckMode {
+	return ms.ackMode
+}
+
+// Subscriptions returns the underlying subscription for each
+// connection on which subscribing succeeded.
+func (ms *MultiSubscription) Subscriptions() []*Subscription {
+	return append([]*Subscription(nil), ms.subs...)
+}
+
+// Active returns whether the subscription is still active, which is
+// the case until it is unsubscribed or it has failed on every connection.
+func (ms *MultiSubscription) Active() bool {
+	if atomic.LoadInt32(&ms.state) != subStateActive {
+		return false
+	}
+	select {
+	case <-ms.closed:
+		return false
+	default:
+		return true
+	}
+}
+
+// Healthy returns the connections on which messages
+// are still being received.
+func (ms *MultiSubscription) Healthy() []*Conn {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	var conns []*Conn
+	for _, sub := range ms.subs {
+		if _, failed := ms.errs[sub.conn]; !failed && sub.Active() {
+			conns = append(conns, sub.conn)
+		}
+	}
+	return conns
+}
+
+// Err returns the error that ended the subscription on the connection,
+// or nil if the subscription on the connection has not failed.
+func (ms *MultiSubscription) Err(conn *Conn) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	return ms.errs[conn]
+}
+
+// Ack acknowledges a message received from the subscription, by
+// sending an ACK frame on the connection that the message came from.
+func (ms *MultiSubscription) Ack(msg *Message) error {
+	if msg.Conn == nil {
+		return ErrNotReceivedMessage
+	}
+	return msg.Conn.Ack(msg)
+}
+
+// Nack indicates that a message received from the subscription was not
+// consumed, by sending a NACK frame on the connection that the message
+// came from.
+func (ms *MultiSubscription) Nack(msg *Message) error {
+	if msg.Conn == nil {
+		return ErrNotReceivedMessage
+	}
+	return msg.Conn.Nack(msg)
+}
+
+// Read a message from the subscription. This is a convenience
+// method: many callers will prefer to read from the channel C
+// directly.
+func (ms *MultiSubscription) Read() (*Message, error) {
+	msg, ok := <-ms.C
+	if !ok {
+		return nil, ErrCompletedSubscription
+	}
+	if msg.Err != nil {
+		return nil, msg.Err
+	}
+	return msg, nil
+}
+
+// Handle calls the handler for each message received from the
+// subscription, until it is unsubscribed or it has failed on every
+// connection. Returns the last error in the latter case.
+func (ms *MultiSubscription) Handle(handler func(msg *Message)) error {
+	for {
+		msg, err := ms.Read()
+		if err == ErrCompletedSubscription {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		handler(msg)
+	}
+}
+
+// Unsubscribes on every connection and closes the channel C. Messages
+// that have been received but not read from C are discarded, and are
+// redelivered by the brokers if they require acknowledgement.
+func (ms *MultiSubscription) Unsubscribe(opts ...func(*frame.Frame) error) error {
+	if !atomic.CompareAndSwapInt32(&ms.state, subStateActive, subStateClosing) {
+		return ErrCompletedSubscription
+	}
+	close(ms.done)
+
+	var firstErr error
+	for _, sub := range ms.subs {
+		err := sub.Unsubscribe(opts...)
+		if err != nil && err != ErrCompletedSubscription && firstErr == nil {
+			firstErr = err
+		}
+	}
+	<-ms.closed
+	atomic.StoreInt32(&ms.state, subStateClosed)
+	return firstErr
+}
+
+// Forwards the messages of one subscription to C, until
+// the subscription is unsubscribed or fails.
+func (ms *MultiSubscription) forward(sub *Subscription) {
+	defer ms.wg.Done()
+	for msg := range sub.C {
+		if msg.Err != nil {
+			ms.fail(sub.conn, msg.Err)
+			continue
+		}
+		select {
+		case ms.C <- msg:
+		case <-ms.done:
+			// unsubscribing, keep reading so that the
+			// subscription can receive its receipt
+		}
+	}
+}
+
+// Records the error that ended the subscription on a connection.
+func (ms *MultiSubscription) fail(conn *Conn, err error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	ms.errs[conn] = err
+	ms.lastErr = err
+}
+
+// Closes C once every subscription has ended. If they ended because
+// they failed, the last error is sent on C first.
+func (ms *MultiSubscription) closeWhenDone() {
+	ms.wg.Wait()
+	select {
+	case <-ms.done:
+	default:
+		ms.mutex.Lock()
+		err := ms.lastErr
+		ms.mutex.Unlock()
+		if err == nil {
+			err = ErrCompletedSubscription
+		}
+		select {
+		case ms.C <- &Message{Err: err}:
+		case <-ms.done:
+		}
+	}
+	close(ms.C)
+	close(ms.closed)
+}
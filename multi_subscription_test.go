@@ -0,0 +1,166 @@
+package stomp
+
+import (
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	. "gopkg.in/check.v1"
+)
+
+func (s *StompSuite) Test_multi_subscription(c *C) {
+	conn1, rw1 := connectHelper(c, V12)
+	conn2, rw2 := connectHelper(c, V12)
+	send := make(chan struct{})
+	stop1 := make(chan struct{})
+	stop2 := make(chan struct{})
+
+	// the first broker acks its message and then goes away
+	go func() {
+		defer close(stop1)
+		f1, err := rw1.Read()
+		c.Assert(err, IsNil)
+		c.Assert(f1.Command, Equals, frame.SUBSCRIBE)
+		err = rw1.Write(frame.New(frame.MESSAGE,
+			frame.Subscription, f1.Header.Get(frame.Id),
+			frame.MessageId, "a-1",
+			frame.Ack, "a-1",
+			frame.Destination, "/queue/orders"))
+		c.Assert(err, IsNil)
+
+		f2, err := rw1.Read()
+		c.Assert(err, IsNil)
+		c.Check(f2.Command, Equals, frame.ACK)
+		c.Check(f2.Header.Get(frame.Id), Equals, "a-1")
+		rw1.Close()
+	}()
+
+	// the second broker keeps delivering
+	go func() {
+		defer func() {
+			rw2.Close()
+			close(stop2)
+		}()
+		f1, err := rw2.Read()
+		c.Assert(err, IsNil)
+		c.Assert(f1.Command, Equals, frame.SUBSCRIBE)
+		id := f1.Header.Get(frame.Id)
+		err = rw2.Write(frame.New(frame.MESSAGE,
+			frame.Subscription, id,
+			frame.MessageId, "b-1",
+			frame.Ack, "b-1",
+			frame.Destination, "/queue/orders"))
+		c.Assert(err, IsNil)
+
+		f2, err := rw2.Read()
+		c.Assert(err, IsNil)
+		c.Check(f2.Command, Equals, frame.NACK)
+		c.Check(f2.Header.Get(frame.Id), Equals, "b-1")
+
+		<-send
+		err = rw2.Write(frame.New(frame.MESSAGE,
+			frame.Subscription, id,
+			frame.MessageId, "b-2",
+			frame.Ack, "b-2",
+			frame.Destination, "/queue/orders"))
+		c.Assert(err, IsNil)
+
+		f3, err := rw2.Read()
+		c.Assert(err, IsNil)
+		c.Check(f3.Command, Equals, frame.UNSUBSCRIBE)
+		c.Check(f3.Header.Get(frame.Id), Equals, id)
+		err = rw2.Write(frame.New(frame.RECEIPT, frame.ReceiptId, f3.Header.Get(frame.Receipt)))
+		c.Assert(err, IsNil)
+
+		f4, err := rw2.Read()
+		c.Assert(err, IsNil)
+		c.Check(f4.Command, Equals, frame.DISCONNECT)
+		err = rw2.Write(frame.New(frame.RECEIPT, frame.ReceiptId, f4.Header.Get(frame.Receipt)))
+		c.Assert(err, IsNil)
+	}()
+
+	ms, err := SubscribeAll([]*Conn{conn1, conn2}, "/queue/orders", AckClientIndividual)
+	c.Assert(err, IsNil)
+	c.Check(ms.Destination(), Equals, "/queue/orders")
+	c.Check(ms.Subscriptions(), HasLen, 2)
+
+	// acks and nacks go to the broker that delivered the message
+	for i := 0; i < 2; i++ {
+		msg, err := ms.Read()
+		c.Assert(err, IsNil)
+		switch msg.Header.Get(frame.MessageId) {
+		case "a-1":
+			c.Check(msg.Conn, Equals, conn1)
+			c.Assert(ms.Ack(msg), IsNil)
+		case "b-1":
+			c.Check(msg.Conn, Equals, conn2)
+			c.Assert(ms.Nack(msg), IsNil)
+		default:
+			c.Fatalf("unexpected message %s", msg.Header.Get(frame.MessageId))
+		}
+	}
+
+	<-stop1
+	for i := 0; i < 100 && ms.Err(conn1) == nil; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Check(ms.Err(conn1), NotNil)
+	c.Check(ms.Err(conn2), IsNil)
+	c.Check(ms.Healthy(), DeepEquals, []*Conn{conn2})
+	c.Check(ms.Active(), Equals, true)
+
+	close(send)
+	msg, err := ms.Read()
+	c.Assert(err, IsNil)
+	c.Check(msg.Header.Get(frame.MessageId), Equals, "b-2")
+	c.Check(msg.Conn, Equals, conn2)
+
+	c.Assert(ms.Unsubscribe(), IsNil)
+	c.Check(ms.Active(), Equals, false)
+	_, ok := <-ms.C
+	c.Check(ok, Equals, false)
+	c.Check(ms.Unsubscribe(), Equals, ErrCompletedSubscription)
+
+	c.Assert(conn2.Disconnect(), IsNil)
+	<-stop2
+}
+
+func (s *StompSuite) Test_multi_subscription_all_fail(c *C) {
+	conn1, rw1 := connectHelper(c, V12)
+	conn2, rw2 := connectHelper(c, V12)
+
+	for _, rw := range []*fakeReaderWriter{rw1, rw2} {
+		go func(rw *fakeReaderWriter) {
+			f1, err := rw.Read()
+			c.Assert(err, IsNil)
+			c.Assert(f1.Command, Equals, frame.SUBSCRIBE)
+			rw.Close()
+		}(rw)
+	}
+
+	ms, err := SubscribeAll([]*Conn{conn1, conn2}, "/queue/orders", AckAuto)
+	c.Assert(err, IsNil)
+
+	err = ms.Handle(func(msg *Message) {
+		c.Errorf("unexpected message %v", msg)
+	})
+	c.Check(err, NotNil)
+	c.Check(ms.Err(conn1), NotNil)
+	c.Check(ms.Err(conn2), NotNil)
+	c.Check(ms.Healthy(), HasLen, 0)
+	c.Check(ms.Active(), Equals, false)
+}
+
+func (s *StompSuite) Test_multi_subscription_closed_conns(c *C) {
+	conn, rw := connectHelper(c, V12)
+	go func() {
+		f1, _ := rw.Read()
+		c.Check(f1.Command, Equals, frame.DISCONNECT)
+		rw.Write(frame.New(frame.RECEIPT, frame.ReceiptId, f1.Header.Get(frame.Receipt)))
+		rw.Close()
+	}()
+	c.Assert(conn.Disconnect(), IsNil)
+
+	ms, err := SubscribeAll([]*Conn{conn}, "/queue/orders", AckAuto)
+	c.Check(ms, IsNil)
+	c.Check(err, Equals, ErrClosedUnexpectedly)
+}
@@ -0,0 +1,246 @@
+package outbox
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A minimal in-memory database/sql driver that understands just
+// the statements issued by this package.
+
+var fakeDB = &fakeDriver{databases: make(map[string]*fakeDatabase)}
+
+func init() {
+	sql.Register("outboxfake", fakeDB)
+}
+
+type fakeDriver struct {
+	mutex     sync.Mutex
+	databases map[string]*fakeDatabase
+}
+
+func (d *fakeDriver) Open(name string) (driver.Conn, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	db, ok := d.databases[name]
+	if !ok {
+		db = &fakeDatabase{}
+		d.databases[name] = db
+	}
+	return &fakeConn{db: db}, nil
+}
+
+type fakeRow struct {
+	id          int64
+	key         string
+	destination string
+	header      string
+	body        []byte
+	attempts    int64
+	sent        bool
+
+	nextAttempt  int64
+	claimedBy    string
+	claimedUntil int64
+}
+
+type fakeDatabase struct {
+	mutex  sync.Mutex
+	rows   []*fakeRow
+	lastId int64
+}
+
+func (db *fakeDatabase) insert(rows []*fakeRow) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	for _, row := range rows {
+		db.lastId++
+		row.id = db.lastId
+		db.rows = append(db.rows, row)
+	}
+}
+
+// database returns the database with the given name.
+func (d *fakeDriver) database(name string) *fakeDatabase {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.databases[name]
+}
+
+func (db *fakeDatabase) find(id int64) *fakeRow {
+	for _, row := range db.rows {
+		if row.id == id {
+			return row
+		}
+	}
+	return nil
+}
+
+// snapshot returns copies of all rows, ordered by id
+func (db *fakeDatabase) snapshot() []fakeRow {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	var rows []fakeRow
+	for _, row := range db.rows {
+		rows = append(rows, *row)
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].id < rows[j].id })
+	return rows
+}
+
+type fakeConn struct {
+	db *fakeDatabase
+	tx *fakeTx
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{conn: c, query: query}, nil
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	if c.tx != nil {
+		return nil, errors.New("transaction already in progress")
+	}
+	c.tx = &fakeTx{conn: c}
+	return c.tx, nil
+}
+
+type fakeTx struct {
+	conn    *fakeConn
+	pending []*fakeRow
+}
+
+func (tx *fakeTx) Commit() error {
+	tx.conn.db.insert(tx.pending)
+	tx.conn.tx = nil
+	return nil
+}
+
+func (tx *fakeTx) Rollback() error {
+	tx.conn.tx = nil
+	return nil
+}
+
+type fakeStmt struct {
+	conn  *fakeConn
+	query string
+}
+
+func (s *fakeStmt) Close() error {
+	return nil
+}
+
+func (s *fakeStmt) NumInput() int {
+	return -1
+}
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	db := s.conn.db
+	switch {
+	case strings.HasPrefix(s.query, "INSERT"):
+		row := &fakeRow{
+			key:         args[0].(string),
+			destination: args[1].(string),
+			header:      args[2].(string),
+		}
+		if args[3] != nil {
+			row.body = args[3].([]byte)
+		}
+		if s.conn.tx != nil {
+			s.conn.tx.pending = append(s.conn.tx.pending, row)
+		} else {
+			db.insert([]*fakeRow{row})
+		}
+		return driver.RowsAffected(1), nil
+
+	case strings.HasPrefix(s.query, "UPDATE"):
+		db.mutex.Lock()
+		defer db.mutex.Unlock()
+		row := db.find(args[len(args)-1].(int64))
+		switch {
+		case strings.Contains(s.query, "SET claimed_by ="):
+			row = db.find(args[2].(int64))
+			if row == nil || row.sent || row.claimedUntil > args[3].(int64) {
+				return driver.RowsAffected(0), nil
+			}
+			row.claimedBy = args[0].(string)
+			row.claimedUntil = args[1].(int64)
+		case row == nil:
+			return driver.RowsAffected(0), nil
+		case strings.Contains(s.query, "SET sent = 1"):
+			row.sent = true
+			row.claimedUntil = 0
+		case strings.Contains(s.query, "SET attempts = attempts + 1"):
+			row.attempts++
+			row.nextAttempt = args[0].(int64)
+			row.claimedUntil = 0
+		default:
+			return nil, errors.New("unsupported update: " + s.query)
+		}
+		return driver.RowsAffected(1), nil
+	}
+	return nil, errors.New("unsupported statement: " + s.query)
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	if !strings.HasPrefix(s.query, "SELECT") {
+		return nil, errors.New("unsupported query: " + s.query)
+	}
+	now, limit := args[0].(int64), int(args[4].(int64))
+	result := &fakeRows{}
+	held := make(map[string]bool) // aggregate keys with a message not due or claimed
+	for _, row := range s.conn.db.snapshot() {
+		if row.sent {
+			continue
+		}
+		due := row.nextAttempt <= now && row.claimedUntil <= now
+		if row.key != "" {
+			if held[row.key] {
+				continue
+			}
+			held[row.key] = !due
+		}
+		if due && len(result.rows) < limit {
+			r := row
+			result.rows = append(result.rows, &r)
+		}
+	}
+	return result, nil
+}
+
+type fakeRows struct {
+	rows  []*fakeRow
+	index int
+}
+
+func (r *fakeRows) Columns() []string {
+	return []string{"id", "aggregate_key", "destination", "header", "body", "attempts"}
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.index >= len(r.rows) {
+		return io.EOF
+	}
+	row := r.rows[r.index]
+	r.index++
+	dest[0] = row.id
+	dest[1] = row.key
+	dest[2] = row.destination
+	dest[3] = row.header
+	dest[4] = row.body
+	dest[5] = row.attempts
+	return nil
+}
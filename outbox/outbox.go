@@ -0,0 +1,153 @@
+/*
+Package outbox implements the transactional outbox pattern on top of
+database/sql.
+
+A message is written to an outbox table in the same database transaction
+as the business data it describes, using Enqueue. A Relay then reads
+unsent rows from the outbox table, publishes them to the STOMP server and
+marks them as sent. If the transaction rolls back, the message is never
+published; if the STOMP server is unavailable, the message is published
+once it becomes available again.
+
+The outbox table is expected to have the following columns. The exact
+column types depend on the database in use.
+
+	CREATE TABLE stomp_outbox (
+		id            INTEGER PRIMARY KEY AUTOINCREMENT,
+		aggregate_key VARCHAR(255) NOT NULL,
+		destination   VARCHAR(255) NOT NULL,
+		header        TEXT NOT NULL,
+		body          BLOB,
+		attempts      INTEGER NOT NULL DEFAULT 0,
+		sent          INTEGER NOT NULL DEFAULT 0,
+		next_attempt  BIGINT NOT NULL DEFAULT 0,
+		claimed_by    VARCHAR(255),
+		claimed_until BIGINT NOT NULL DEFAULT 0
+	)
+
+The next_attempt and claimed_until columns hold times in milliseconds
+since the Unix epoch. A message that could not be published is not read
+again until its next_attempt time, and a message that a relay is publishing
+is claimed by that relay until its claimed_until time.
+*/
+package outbox
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/go-stomp/stomp/v3/frame"
+)
+
+// AggregateKeyHeader is the name of the header entry that identifies
+// the aggregate a message belongs to. Messages with the same aggregate
+// key are published in the order in which they were enqueued. Messages
+// without an aggregate key are not ordered with respect to each other.
+const AggregateKeyHeader = "aggregate-key"
+
+// A Table describes the database table used to hold outbox messages.
+type Table struct {
+	// Name of the outbox table.
+	Name string
+
+	// Placeholder returns the bind parameter placeholder for the
+	// n-th (one-based) parameter of a statement. If nil, "?" is used
+	// for every parameter. Use Dollar for PostgreSQL-style drivers.
+	Placeholder func(n int) string
+}
+
+// DefaultTable is the table used by the Enqueue function and by a
+// Relay that does not specify a table.
+var DefaultTable = &Table{Name: "stomp_outbox"}
+
+// Dollar returns PostgreSQL-style placeholders ($1, $2, ...).
+func Dollar(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+// Enqueue inserts a message into the default outbox table as part of the
+// database transaction tx. The message will be published to destination
+// by a Relay once the transaction has been committed. The header may be
+// nil.
+func Enqueue(tx *sql.Tx, destination string, header *frame.Header, body []byte) error {
+	return DefaultTable.Enqueue(tx, destination, header, body)
+}
+
+// Enqueue inserts a message into the outbox table as part of the
+// database transaction tx.
+func (t *Table) Enqueue(tx *sql.Tx, destination string, header *frame.Header, body []byte) error {
+	if header == nil {
+		header = frame.NewHeader()
+	}
+	encoded, err := encodeHeader(header)
+	if err != nil {
+		return err
+	}
+	query := fmt.Sprintf("INSERT INTO %s (aggregate_key, destination, header, body, attempts, sent, next_attempt, claimed_until) VALUES (%s, %s, %s, %s, 0, 0, 0, 0)",
+		t.Name, t.placeholder(1), t.placeholder(2), t.placeholder(3), t.placeholder(4))
+	_, err = tx.Exec(query, header.Get(AggregateKeyHeader), destination, encoded, body)
+	return err
+}
+
+// Selects the unsent messages that are due and not claimed, in order,
+// leaving out the messages that follow a message with the same aggregate
+// key that is not due or is claimed. The parameters are the current time,
+// four times, and the maximum number of rows.
+func (t *Table) selectUnsentQuery() string {
+	return fmt.Sprintf("SELECT id, aggregate_key, destination, header, body, attempts FROM %[1]s m"+
+		" WHERE sent = 0 AND next_attempt <= %[2]s AND claimed_until <= %[3]s"+
+		" AND (aggregate_key = '' OR NOT EXISTS (SELECT 1 FROM %[1]s e"+
+		" WHERE e.aggregate_key = m.aggregate_key AND e.sent = 0 AND e.id < m.id"+
+		" AND (e.next_attempt > %[4]s OR e.claimed_until > %[5]s)))"+
+		" ORDER BY id LIMIT %[6]s",
+		t.Name, t.placeholder(1), t.placeholder(2), t.placeholder(3), t.placeholder(4), t.placeholder(5))
+}
+
+// Claims an unsent message that is not claimed. The parameters are the
+// name of the relay, the end of the claim, the id and the current time.
+func (t *Table) claimQuery() string {
+	return fmt.Sprintf("UPDATE %s SET claimed_by = %s, claimed_until = %s WHERE id = %s AND sent = 0 AND claimed_until <= %s",
+		t.Name, t.placeholder(1), t.placeholder(2), t.placeholder(3), t.placeholder(4))
+}
+
+func (t *Table) markSentQuery() string {
+	return fmt.Sprintf("UPDATE %s SET sent = 1, claimed_until = 0 WHERE id = %s", t.Name, t.placeholder(1))
+}
+
+// The parameters are the time of the next attempt and the id.
+func (t *Table) markFailedQuery() string {
+	return fmt.Sprintf("UPDATE %s SET attempts = attempts + 1, next_attempt = %s, claimed_until = 0 WHERE id = %s",
+		t.Name, t.placeholder(1), t.placeholder(2))
+}
+
+func (t *Table) placeholder(n int) string {
+	if t.Placeholder == nil {
+		return "?"
+	}
+	return t.Placeholder(n)
+}
+
+// Header entries are stored as a JSON array of alternating keys and
+// values, which preserves both order and repeated keys.
+func encodeHeader(header *frame.Header) (string, error) {
+	slice := make([]string, 0, header.Len()*2)
+	for i := 0; i < header.Len(); i++ {
+		key, value := header.GetAt(i)
+		slice = append(slice, key, value)
+	}
+	b, err := json.Marshal(slice)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func decodeHeader(s string) (*frame.Header, error) {
+	var slice []string
+	if err := json.Unmarshal([]byte(s), &slice); err != nil {
+		return nil, err
+	}
+	return frame.NewHeader(slice...), nil
+}
@@ -0,0 +1,233 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	. "gopkg.in/check.v1"
+)
+
+// Runs all gocheck tests in this package.
+// See other *_test.go files for gocheck tests.
+func TestOutbox(t *testing.T) {
+	TestingT(t)
+}
+
+type OutboxSuite struct{}
+
+var _ = Suite(&OutboxSuite{})
+
+var errSendFailed = errors.New("send failed")
+
+// fakeSender records sent frames, and fails sends to
+// destinations listed in fail. If during is not nil, it
+// is called before each send.
+type fakeSender struct {
+	frames []*frame.Frame
+	fail   map[string]bool
+	during func()
+}
+
+func (s *fakeSender) Send(destination, contentType string, body []byte, opts ...func(*frame.Frame) error) error {
+	if s.during != nil {
+		s.during()
+	}
+	if s.fail[destination] {
+		return errSendFailed
+	}
+	f := frame.New(frame.SEND, frame.Destination, destination)
+	if contentType != "" {
+		f.Header.Set(frame.ContentType, contentType)
+	}
+	f.Body = body
+	for _, opt := range opts {
+		if err := opt(f); err != nil {
+			return err
+		}
+	}
+	s.frames = append(s.frames, f)
+	return nil
+}
+
+func openDB(c *C) *sql.DB {
+	db, err := sql.Open("outboxfake", c.TestName())
+	c.Assert(err, IsNil)
+	return db
+}
+
+func enqueue(c *C, db *sql.DB, destination string, header *frame.Header, body string) {
+	tx, err := db.Begin()
+	c.Assert(err, IsNil)
+	err = Enqueue(tx, destination, header, []byte(body))
+	c.Assert(err, IsNil)
+	c.Assert(tx.Commit(), IsNil)
+}
+
+func (s *OutboxSuite) TestEnqueueIsTransactional(c *C) {
+	db := openDB(c)
+	sender := &fakeSender{}
+	relay := &Relay{DB: db, Conn: sender}
+
+	tx, err := db.Begin()
+	c.Assert(err, IsNil)
+	err = Enqueue(tx, "/queue/rolled-back", nil, []byte("never sent"))
+	c.Assert(err, IsNil)
+	c.Assert(tx.Rollback(), IsNil)
+
+	enqueue(c, db, "/queue/committed", frame.NewHeader(
+		frame.ContentType, "text/plain",
+		"custom", "value"), "hello")
+
+	n, err := relay.Poll()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 1)
+	c.Assert(sender.frames, HasLen, 1)
+
+	f := sender.frames[0]
+	c.Check(f.Header.Get(frame.Destination), Equals, "/queue/committed")
+	c.Check(f.Header.Get(frame.ContentType), Equals, "text/plain")
+	c.Check(f.Header.Get("custom"), Equals, "value")
+	c.Check(f.Header.Get(frame.Receipt), Not(Equals), "")
+	c.Check(string(f.Body), Equals, "hello")
+
+	// nothing more to send
+	n, err = relay.Poll()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 0)
+	c.Assert(sender.frames, HasLen, 1)
+}
+
+func (s *OutboxSuite) TestFailureHoldsBackSameAggregate(c *C) {
+	db := openDB(c)
+	sender := &fakeSender{fail: map[string]bool{"/queue/broken": true}}
+	relay := &Relay{DB: db, Conn: sender, RetryInterval: time.Millisecond}
+
+	enqueue(c, db, "/queue/broken", frame.NewHeader(AggregateKeyHeader, "order-1"), "1")
+	enqueue(c, db, "/queue/ok", frame.NewHeader(AggregateKeyHeader, "order-1"), "2")
+	enqueue(c, db, "/queue/ok", frame.NewHeader(AggregateKeyHeader, "order-2"), "3")
+	enqueue(c, db, "/queue/ok", nil, "4")
+
+	n, err := relay.Poll()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 2)
+	c.Assert(sender.frames, HasLen, 2)
+	c.Check(string(sender.frames[0].Body), Equals, "3")
+	c.Check(string(sender.frames[1].Body), Equals, "4")
+
+	// the failed message is retried once the broker recovers,
+	// and the held back message follows it in order
+	delete(sender.fail, "/queue/broken")
+	time.Sleep(5 * time.Millisecond)
+
+	n, err = relay.Poll()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 2)
+	c.Assert(sender.frames, HasLen, 4)
+	c.Check(string(sender.frames[2].Body), Equals, "1")
+	c.Check(string(sender.frames[3].Body), Equals, "2")
+}
+
+func (s *OutboxSuite) TestFailedAttemptsAreRecorded(c *C) {
+	db := openDB(c)
+	sender := &fakeSender{fail: map[string]bool{"/queue/broken": true}}
+	relay := &Relay{DB: db, Conn: sender, RetryInterval: time.Hour}
+
+	enqueue(c, db, "/queue/broken", nil, "1")
+
+	_, err := relay.Poll()
+	c.Assert(err, IsNil)
+
+	// still within the retry interval, so no further attempt
+	_, err = relay.Poll()
+	c.Assert(err, IsNil)
+
+	rows := fakeDB.database(c.TestName()).snapshot()
+	c.Assert(rows, HasLen, 1)
+	c.Check(rows[0].attempts, Equals, int64(1))
+	c.Check(rows[0].nextAttempt > millis(time.Now().Add(time.Minute)), Equals, true)
+	c.Check(rows[0].claimedUntil, Equals, int64(0))
+}
+
+func (s *OutboxSuite) TestHeldBackMessagesDoNotFillBatch(c *C) {
+	db := openDB(c)
+	sender := &fakeSender{fail: map[string]bool{"/queue/broken": true}}
+	relay := &Relay{DB: db, Conn: sender, RetryInterval: time.Hour, BatchSize: 2}
+
+	for i := 0; i < 3; i++ {
+		enqueue(c, db, "/queue/broken", frame.NewHeader(AggregateKeyHeader, "order-1"), "held")
+	}
+	enqueue(c, db, "/queue/ok", frame.NewHeader(AggregateKeyHeader, "order-2"), "4")
+	enqueue(c, db, "/queue/ok", nil, "5")
+
+	n, err := relay.Poll()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 0)
+
+	// the messages of the blocked aggregate are no longer read
+	n, err = relay.Poll()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 2)
+	c.Assert(sender.frames, HasLen, 2)
+	c.Check(string(sender.frames[0].Body), Equals, "4")
+	c.Check(string(sender.frames[1].Body), Equals, "5")
+}
+
+func (s *OutboxSuite) TestClaimedMessagesAreNotPublishedTwice(c *C) {
+	db := openDB(c)
+	other := &Relay{DB: db, Conn: &fakeSender{}, Name: "other"}
+	sender := &fakeSender{}
+	relay := &Relay{DB: db, Conn: sender, Name: "relay"}
+
+	enqueue(c, db, "/queue/test", frame.NewHeader(AggregateKeyHeader, "order-1"), "1")
+	enqueue(c, db, "/queue/test", frame.NewHeader(AggregateKeyHeader, "order-1"), "2")
+
+	// while the first message is being published, the other relay
+	// publishes neither it, nor the message that follows it
+	polled := false
+	sender.during = func() {
+		if polled {
+			return
+		}
+		polled = true
+		rows := fakeDB.database(c.TestName()).snapshot()
+		c.Check(rows[0].claimedBy, Equals, "relay")
+		n, err := other.Poll()
+		c.Check(err, IsNil)
+		c.Check(n, Equals, 0)
+	}
+
+	n, err := relay.Poll()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 2)
+	c.Check(sender.frames, HasLen, 2)
+	c.Check(other.Conn.(*fakeSender).frames, HasLen, 0)
+
+	// an expired claim does not prevent publishing
+	enqueue(c, db, "/queue/test", nil, "3")
+	_, err = db.Exec(DefaultTable.claimQuery(), "crashed", millis(time.Now())-1, int64(3), millis(time.Now()))
+	c.Assert(err, IsNil)
+	n, err = other.Poll()
+	c.Assert(err, IsNil)
+	c.Check(n, Equals, 1)
+}
+
+func (s *OutboxSuite) TestRunStopsWhenContextDone(c *C) {
+	db := openDB(c)
+	sender := &fakeSender{}
+	relay := &Relay{DB: db, Conn: sender, PollInterval: time.Millisecond}
+
+	enqueue(c, db, "/queue/test", nil, "hello")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- relay.Run(ctx) }()
+
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	c.Assert(<-done, Equals, context.Canceled)
+	c.Assert(sender.frames, HasLen, 1)
+}
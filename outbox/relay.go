@@ -0,0 +1,283 @@
+package outbox
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"strconv"
+	"time"
+
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/go-stomp/stomp/v3/internal/log"
+)
+
+// Default relay parameters.
+const (
+	// Default time between polls of the outbox table when there
+	// are no more messages to publish.
+	DefaultPollInterval = time.Second
+
+	// Default time to wait before retrying a message that could
+	// not be published.
+	DefaultRetryInterval = 5 * time.Second
+
+	// Default maximum number of rows read from the outbox table
+	// in one poll.
+	DefaultBatchSize = 100
+
+	// Default time for which a relay claims a message that it is
+	// publishing. It should be longer than it takes to publish a
+	// message, including waiting for the receipt.
+	DefaultClaimTimeout = time.Minute
+)
+
+// Sender is the interface that wraps the Send method. It is implemented
+// by *stomp.Conn.
+type Sender interface {
+	Send(destination, contentType string, body []byte, opts ...func(*frame.Frame) error) error
+}
+
+// A Relay publishes messages from the outbox table to a STOMP server.
+//
+// Each message is sent with a receipt, and is only marked as sent once the
+// receipt has been received. If the relay stops between publishing a message
+// and marking it as sent, the message will be published again, so delivery
+// is at-least-once.
+//
+// Messages with the same aggregate key are published in order: if a message
+// cannot be published, later messages with the same aggregate key are held
+// back until it has been published successfully. The time of the next
+// attempt is stored in the outbox table, so held back messages do not
+// prevent other messages from being read.
+//
+// Several relays can process the same outbox table: a relay claims each
+// message before publishing it, and a claimed message is not published by
+// other relays until the claim has expired. A Relay is not safe for
+// concurrent use by multiple goroutines.
+type Relay struct {
+	DB            *sql.DB       // Database containing the outbox table
+	Conn          Sender        // Connection used to publish messages
+	Table         *Table        // Outbox table, DefaultTable if nil
+	PollInterval  time.Duration // Time between polls, DefaultPollInterval if zero
+	RetryInterval time.Duration // Time before retrying a failed message, DefaultRetryInterval if zero
+	ClaimTimeout  time.Duration // Time for which a message is claimed, DefaultClaimTimeout if zero
+	BatchSize     int           // Maximum rows per poll, DefaultBatchSize if zero
+	Name          string        // Stored in claimed_by, a random name if empty
+	Log           stomp.Logger
+}
+
+// message is a row read from the outbox table.
+type message struct {
+	id           int64
+	aggregateKey string
+	destination  string
+	header       *frame.Header
+	body         []byte
+	attempts     int
+}
+
+// Run polls the outbox table and publishes messages until ctx is done.
+// Errors accessing the database are logged and the poll is retried after
+// the poll interval. Run returns ctx.Err() when ctx is done.
+func (r *Relay) Run(ctx context.Context) error {
+	for {
+		n, err := r.Poll()
+		if err != nil {
+			r.logger().Errorf("outbox: poll failed: %v", err)
+		}
+
+		// If a full batch was published there are probably more
+		// messages waiting, so poll again straight away.
+		if err == nil && n > 0 && n >= r.batchSize() {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			continue
+		}
+
+		timer := time.NewTimer(r.pollInterval())
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Poll reads one batch of unsent messages from the outbox table and
+// publishes them. Returns the number of messages published.
+func (r *Relay) Poll() (int, error) {
+	table := r.table()
+
+	messages, err := r.readBatch(table)
+	if err != nil {
+		return 0, err
+	}
+
+	blocked := make(map[string]bool)
+	published := 0
+
+	for _, m := range messages {
+		key := m.orderKey()
+		if blocked[key] {
+			continue
+		}
+
+		// another relay might have claimed or published
+		// the message since the batch was read
+		claimed, err := r.claim(table, m)
+		if err != nil {
+			return published, err
+		}
+		if !claimed {
+			blocked[key] = true
+			continue
+		}
+
+		if err := r.publish(m); err != nil {
+			r.logger().Warningf("outbox: failed to publish message %d to %s (attempt %d): %v",
+				m.id, m.destination, m.attempts+1, err)
+
+			// hold back later messages for the same aggregate
+			blocked[key] = true
+			nextAttempt := millis(time.Now().Add(r.retryInterval()))
+			if _, err := r.DB.Exec(table.markFailedQuery(), nextAttempt, m.id); err != nil {
+				return published, err
+			}
+			continue
+		}
+
+		if _, err := r.DB.Exec(table.markSentQuery(), m.id); err != nil {
+			return published, err
+		}
+		published++
+	}
+
+	return published, nil
+}
+
+// claim claims the message for the relay. Returns false if the message
+// has been claimed by another relay, or has already been published.
+func (r *Relay) claim(table *Table, m *message) (bool, error) {
+	now := time.Now()
+	result, err := r.DB.Exec(table.claimQuery(), r.name(), millis(now.Add(r.claimTimeout())), m.id, millis(now))
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+func (r *Relay) readBatch(table *Table) ([]*message, error) {
+	now := millis(time.Now())
+	rows, err := r.DB.Query(table.selectUnsentQuery(), now, now, now, now, r.batchSize())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []*message
+	for rows.Next() {
+		var header string
+		m := &message{}
+		err = rows.Scan(&m.id, &m.aggregateKey, &m.destination, &header, &m.body, &m.attempts)
+		if err != nil {
+			return nil, err
+		}
+		if m.header, err = decodeHeader(header); err != nil {
+			return nil, err
+		}
+		messages = append(messages, m)
+	}
+	return messages, rows.Err()
+}
+
+// publish sends the message with a receipt, so that a nil return
+// value means that the STOMP server has accepted the message.
+func (r *Relay) publish(m *message) error {
+	opts := []func(*frame.Frame) error{stomp.SendOpt.Receipt}
+	for i := 0; i < m.header.Len(); i++ {
+		key, value := m.header.GetAt(i)
+		switch key {
+		case frame.ContentType, frame.ContentLength, frame.Destination,
+			frame.Receipt, frame.Transaction:
+			// set by the send operation
+			continue
+		}
+		opts = append(opts, stomp.SendOpt.Header(key, value))
+	}
+	return r.Conn.Send(m.destination, m.header.Get(frame.ContentType), m.body, opts...)
+}
+
+// orderKey returns the key used to order messages. Messages without
+// an aggregate key are only ordered with respect to themselves.
+func (m *message) orderKey() string {
+	if m.aggregateKey == "" {
+		// a NUL byte cannot appear in a STOMP header value,
+		// so this cannot clash with a real aggregate key
+		return "\x00" + strconv.FormatInt(m.id, 10)
+	}
+	return m.aggregateKey
+}
+
+func (r *Relay) table() *Table {
+	if r.Table == nil {
+		return DefaultTable
+	}
+	return r.Table
+}
+
+func (r *Relay) pollInterval() time.Duration {
+	if r.PollInterval <= 0 {
+		return DefaultPollInterval
+	}
+	return r.PollInterval
+}
+
+func (r *Relay) retryInterval() time.Duration {
+	if r.RetryInterval <= 0 {
+		return DefaultRetryInterval
+	}
+	return r.RetryInterval
+}
+
+func (r *Relay) claimTimeout() time.Duration {
+	if r.ClaimTimeout <= 0 {
+		return DefaultClaimTimeout
+	}
+	return r.ClaimTimeout
+}
+
+func (r *Relay) name() string {
+	if r.Name == "" {
+		b := make([]byte, 8)
+		rand.Read(b)
+		r.Name = "relay-" + hex.EncodeToString(b)
+	}
+	return r.Name
+}
+
+func (r *Relay) batchSize() int {
+	if r.BatchSize <= 0 {
+		return DefaultBatchSize
+	}
+	return r.BatchSize
+}
+
+func (r *Relay) logger() stomp.Logger {
+	if r.Log == nil {
+		r.Log = log.StdLogger{}
+	}
+	return r.Log
+}
+
+// millis returns t in milliseconds since the Unix epoch.
+func millis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
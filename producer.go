@@ -0,0 +1,196 @@
+package stomp
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/go-stomp/stomp/v3/frame"
+)
+
+// A Producer sends messages to a single destination, applying a common
+// set of defaults to every message it sends. Create a Producer by calling
+// Conn.Producer.
+//
+// The defaults are specified using the ProducerOpt options, and include
+// the content type, custom header entries, the codec used to encode the
+// message body and whether to request a receipt from the server.
+//
+// A Producer is safe for concurrent use by multiple goroutines.
+type Producer struct {
+	conn        *Conn
+	tx          *Transaction
+	destination string
+	contentType string
+	header      *frame.Header
+	codec       Codec
+	receipt     bool
+	stats       *producerStats
+}
+
+// ProducerStats contains counters for the messages sent by a Producer.
+type ProducerStats struct {
+	Sent   uint64 // Number of messages sent successfully
+	Failed uint64 // Number of messages that could not be sent
+	Bytes  uint64 // Number of body bytes sent successfully
+}
+
+type producerStats struct {
+	sent, failed, bytes uint64
+}
+
+// Producer creates a Producer that sends messages to destination. Options
+// specified in opts provide the defaults for every message sent by the
+// producer.
+func (c *Conn) Producer(destination string, opts ...func(*Producer)) *Producer {
+	p := &Producer{
+		conn:        c,
+		destination: destination,
+		header:      frame.NewHeader(),
+		stats:       &producerStats{},
+	}
+	for _, opt := range opts {
+		if opt != nil {
+			opt(p)
+		}
+	}
+	return p
+}
+
+// Destination returns the destination that the producer sends messages to.
+func (p *Producer) Destination() string {
+	return p.destination
+}
+
+// InTransaction returns a producer with the same defaults that sends
+// its messages as part of the transaction tx. The returned producer
+// shares its statistics with p.
+func (p *Producer) InTransaction(tx *Transaction) *Producer {
+	pc := *p
+	pc.tx = tx
+	return &pc
+}
+
+// Stats returns the message counters for the producer.
+func (p *Producer) Stats() ProducerStats {
+	return ProducerStats{
+		Sent:   atomic.LoadUint64(&p.stats.sent),
+		Failed: atomic.LoadUint64(&p.stats.failed),
+		Bytes:  atomic.LoadUint64(&p.stats.bytes),
+	}
+}
+
+// Send sends a message to the producer's destination.
+//
+// If body is a []byte or string it is sent as is. Any other value is
+// encoded using the producer's codec, and ErrNoCodec is returned if
+// the producer does not have one.
+//
+// The options in opts are applied over the producer's defaults, so a
+// header entry specified in opts replaces the default header entry with
+// the same key.
+//
+// Cancellation only applies before the message is handed to the
+// connection: if ctx is done by then, Send returns ctx.Err() and the
+// message is not sent. Once the message has been handed to the connection,
+// cancelling ctx has no effect, and Send waits for the send operation to
+// complete, which is bounded by the connection's send and receipt timeouts.
+// An error returned by Send always means that the message was not sent,
+// and every such message is counted as failed in the producer's Stats.
+func (p *Producer) Send(ctx context.Context, body interface{}, opts ...func(*frame.Frame) error) error {
+	err := p.sendContext(ctx, body, opts)
+	if err != nil {
+		atomic.AddUint64(&p.stats.failed, 1)
+	}
+	return err
+}
+
+func (p *Producer) sendContext(ctx context.Context, body interface{}, opts []func(*frame.Frame) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	f, err := p.createFrame(body, opts)
+	if err != nil {
+		return err
+	}
+
+	// check again, because encoding the body can take some time
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return p.send(f)
+}
+
+func (p *Producer) send(f *frame.Frame) error {
+	var err error
+	if p.tx != nil {
+		if p.tx.completed {
+			err = ErrCompletedTransaction
+		} else {
+			f.Header.Set(frame.Transaction, p.tx.id)
+			err = p.tx.conn.sendFrame(f)
+		}
+	} else {
+		p.conn.closeMutex.Lock()
+		if p.conn.closed {
+			err = ErrAlreadyClosed
+		} else {
+			err = p.conn.send(f)
+		}
+		p.conn.closeMutex.Unlock()
+	}
+
+	if err != nil {
+		return err
+	}
+	atomic.AddUint64(&p.stats.sent, 1)
+	atomic.AddUint64(&p.stats.bytes, uint64(len(f.Body)))
+	return nil
+}
+
+func (p *Producer) createFrame(body interface{}, opts []func(*frame.Frame) error) (*frame.Frame, error) {
+	var data []byte
+	contentType := p.contentType
+
+	switch b := body.(type) {
+	case []byte:
+		data = b
+	case string:
+		data = []byte(b)
+	default:
+		if p.codec == nil {
+			return nil, ErrNoCodec
+		}
+		var err error
+		if data, err = p.codec.Marshal(body); err != nil {
+			return nil, err
+		}
+		if contentType == "" {
+			contentType = p.codec.ContentType()
+		}
+	}
+
+	// The first header entry for a key takes precedence, so the frame is
+	// created without a content type, and the defaults are filled in after
+	// the options have been applied.
+	f, err := createSendFrame(p.destination, "", data, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := f.Header.Contains(frame.ContentType); !ok && contentType != "" {
+		f.Header.Set(frame.ContentType, contentType)
+	}
+	for i := 0; i < p.header.Len(); i++ {
+		key, value := p.header.GetAt(i)
+		if _, ok := f.Header.Contains(key); !ok {
+			f.Header.Add(key, value)
+		}
+	}
+	if _, ok := f.Header.Contains(frame.Receipt); !ok && p.receipt {
+		f.Header.Set(frame.Receipt, allocateId())
+	}
+
+	return f, nil
+}
@@ -0,0 +1,48 @@
+package stomp
+
+// ProducerOpt contains options for the Conn.Producer function. These
+// options provide the defaults for every message sent by the producer.
+var ProducerOpt struct {
+	// ContentType specifies the content type of messages sent by
+	// the producer. If not specified, the content type of the codec
+	// is used for encoded values.
+	ContentType func(contentType string) func(*Producer)
+
+	// Header specifies a header entry to include in every message
+	// sent by the producer. This option can be specified multiple
+	// times if multiple header entries are required.
+	Header func(key, value string) func(*Producer)
+
+	// Codec specifies the codec used to encode message bodies that
+	// are not a []byte or string.
+	Codec func(codec Codec) func(*Producer)
+
+	// Receipt specifies that every message sent by the producer
+	// should request acknowledgement from the server before the
+	// send operation successfully completes.
+	Receipt func(*Producer)
+}
+
+func init() {
+	ProducerOpt.ContentType = func(contentType string) func(*Producer) {
+		return func(p *Producer) {
+			p.contentType = contentType
+		}
+	}
+
+	ProducerOpt.Header = func(key, value string) func(*Producer) {
+		return func(p *Producer) {
+			p.header.Add(key, value)
+		}
+	}
+
+	ProducerOpt.Codec = func(codec Codec) func(*Producer) {
+		return func(p *Producer) {
+			p.codec = codec
+		}
+	}
+
+	ProducerOpt.Receipt = func(p *Producer) {
+		p.receipt = true
+	}
+}
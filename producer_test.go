@@ -0,0 +1,152 @@
+package stomp
+
+import (
+	"context"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	. "gopkg.in/check.v1"
+)
+
+func (s *StompSuite) Test_producer_send(c *C) {
+	conn, rw := connectHelper(c, V12)
+	stop := make(chan struct{})
+
+	go func() {
+		defer func() {
+			rw.Close()
+			close(stop)
+		}()
+
+		f1, err := rw.Read()
+		c.Assert(err, IsNil)
+		c.Assert(f1.Command, Equals, frame.SEND)
+		c.Check(f1.Header.Get(frame.Destination), Equals, "/queue/orders")
+		c.Check(f1.Header.Get(frame.ContentType), Equals, "text/plain")
+		c.Check(f1.Header.Get("source"), Equals, "billing")
+		c.Check(f1.Header.GetAll("priority"), DeepEquals, []string{"9"})
+		c.Check(string(f1.Body), Equals, "hello")
+		receipt, ok := f1.Header.Contains(frame.Receipt)
+		c.Assert(ok, Equals, true)
+		err = rw.Write(frame.New(frame.RECEIPT, frame.ReceiptId, receipt))
+		c.Assert(err, IsNil)
+
+		f2, err := rw.Read()
+		c.Assert(err, IsNil)
+		c.Check(f2.Header.Get(frame.ContentType), Equals, "application/json")
+		c.Check(string(f2.Body), Equals, `{"id":42}`)
+		err = rw.Write(frame.New(frame.RECEIPT, frame.ReceiptId, f2.Header.Get(frame.Receipt)))
+		c.Assert(err, IsNil)
+
+		f3, err := rw.Read()
+		c.Assert(err, IsNil)
+		c.Check(f3.Command, Equals, frame.DISCONNECT)
+		err = rw.Write(frame.New(frame.RECEIPT, frame.ReceiptId, f3.Header.Get(frame.Receipt)))
+		c.Assert(err, IsNil)
+	}()
+
+	p := conn.Producer("/queue/orders",
+		ProducerOpt.ContentType("text/plain"),
+		ProducerOpt.Header("source", "billing"),
+		ProducerOpt.Header("priority", "4"),
+		ProducerOpt.Codec(JSONCodec),
+		ProducerOpt.Receipt)
+	c.Assert(p.Destination(), Equals, "/queue/orders")
+
+	err := p.Send(context.Background(), []byte("hello"), SendOpt.Header("priority", "9"))
+	c.Assert(err, IsNil)
+
+	// the content type of the producer applies to raw bodies only
+	p = conn.Producer("/queue/orders", ProducerOpt.Codec(JSONCodec), ProducerOpt.Receipt)
+	err = p.Send(context.Background(), struct {
+		Id int `json:"id"`
+	}{42})
+	c.Assert(err, IsNil)
+
+	stats := p.Stats()
+	c.Check(stats.Sent, Equals, uint64(1))
+	c.Check(stats.Failed, Equals, uint64(0))
+	c.Check(stats.Bytes, Equals, uint64(9))
+
+	err = conn.Disconnect()
+	c.Assert(err, IsNil)
+	<-stop
+}
+
+func (s *StompSuite) Test_producer_errors(c *C) {
+	conn, rw := connectHelper(c, V12)
+	defer rw.Close()
+
+	p := conn.Producer("/queue/orders")
+	err := p.Send(context.Background(), 42)
+	c.Assert(err, Equals, ErrNoCodec)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = p.Send(ctx, "hello")
+	c.Assert(err, Equals, context.Canceled)
+
+	// every error returned by Send is counted
+	c.Check(p.Stats().Failed, Equals, uint64(2))
+
+	// including a context that is done while the body is encoded
+	ctx, cancel = context.WithCancel(context.Background())
+	p = conn.Producer("/queue/orders", ProducerOpt.Codec(cancelCodec{cancel}))
+	err = p.Send(ctx, 42)
+	c.Assert(err, Equals, context.Canceled)
+	c.Check(p.Stats().Failed, Equals, uint64(1))
+}
+
+// cancelCodec encodes values as JSON, and cancels a context
+// while doing so.
+type cancelCodec struct {
+	cancel context.CancelFunc
+}
+
+func (cancelCodec) ContentType() string {
+	return JSONCodec.ContentType()
+}
+
+func (cc cancelCodec) Marshal(v interface{}) ([]byte, error) {
+	cc.cancel()
+	return JSONCodec.Marshal(v)
+}
+
+func (s *StompSuite) Test_producer_in_transaction(c *C) {
+	conn, rw := connectHelper(c, V12)
+	stop := make(chan struct{})
+
+	go func() {
+		defer func() {
+			rw.Close()
+			close(stop)
+		}()
+
+		f1, err := rw.Read()
+		c.Assert(err, IsNil)
+		c.Assert(f1.Command, Equals, frame.BEGIN)
+		tx := f1.Header.Get(frame.Transaction)
+
+		f2, err := rw.Read()
+		c.Assert(err, IsNil)
+		c.Assert(f2.Command, Equals, frame.SEND)
+		c.Check(f2.Header.Get(frame.Transaction), Equals, tx)
+		c.Check(f2.Header.Get("source"), Equals, "billing")
+
+		f3, err := rw.Read()
+		c.Assert(err, IsNil)
+		c.Check(f3.Command, Equals, frame.COMMIT)
+	}()
+
+	p := conn.Producer("/queue/orders", ProducerOpt.Header("source", "billing"))
+	tx := conn.Begin()
+	err := p.InTransaction(tx).Send(context.Background(), "hello")
+	c.Assert(err, IsNil)
+	c.Assert(tx.Commit(), IsNil)
+	<-stop
+
+	c.Check(p.Stats().Sent, Equals, uint64(1))
+
+	err = p.InTransaction(tx).Send(context.Background(), "too late")
+	c.Assert(err, Equals, ErrCompletedTransaction)
+	c.Check(p.Stats().Failed, Equals, uint64(1))
+}
@@ -0,0 +1,248 @@
+package stomp
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+)
+
+// Default time for which a connection with a resumable session tries to
+// connect to the STOMP server again after the connection has been lost.
+// Override with ConnOpt.ResumeTimeout.
+const DefaultResumeTimeout = 30 * time.Second
+
+// Intervals between attempts to connect again, which double
+// after each failed attempt up to the maximum.
+const (
+	resumeRetryInterval    = 100 * time.Millisecond
+	maxResumeRetryInterval = 2 * time.Second
+)
+
+// The state of a connection with a resumable session.
+type resumeState struct {
+	options *connOptions
+	token   string         // resume token from the last CONNECTED frame
+	conn    *resumableConn // replaced when the session is resumed
+}
+
+func newResumeState(conn io.ReadWriteCloser, options *connOptions, token string) *resumeState {
+	return &resumeState{
+		options: options,
+		token:   token,
+		conn:    &resumableConn{conn: conn, done: make(chan struct{})},
+	}
+}
+
+// A resumableConn is the network connection of a Conn with a resumable
+// session. The connection is replaced when the session is resumed, and
+// closing the resumableConn closes the connection that is current, and
+// prevents it from being replaced.
+type resumableConn struct {
+	mutex  sync.Mutex
+	conn   io.ReadWriteCloser
+	closed bool
+	done   chan struct{} // closed by Close
+}
+
+func (rc *resumableConn) current() io.ReadWriteCloser {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+	return rc.conn
+}
+
+func (rc *resumableConn) Read(p []byte) (int, error) {
+	return rc.current().Read(p)
+}
+
+func (rc *resumableConn) Write(p []byte) (int, error) {
+	return rc.current().Write(p)
+}
+
+func (rc *resumableConn) Close() error {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+	if !rc.closed {
+		rc.closed = true
+		close(rc.done)
+	}
+	return rc.conn.Close()
+}
+
+// Closes the current connection, which has been lost, without
+// preventing it from being replaced.
+func (rc *resumableConn) closeCurrent() {
+	rc.current().Close()
+}
+
+func (rc *resumableConn) isClosed() bool {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+	return rc.closed
+}
+
+// Replaces the current connection, unless the resumableConn has been
+// closed, in which case conn is closed. Returns false if closed.
+func (rc *resumableConn) replace(conn io.ReadWriteCloser) bool {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+	if rc.closed {
+		conn.Close()
+		return false
+	}
+	rc.conn = conn
+	return true
+}
+
+// Returns true if the session is to be resumed when
+// the connection to the STOMP server is lost.
+func (c *Conn) resumable(disconnecting bool) bool {
+	return c.resume != nil && !disconnecting && !c.resume.conn.isClosed()
+}
+
+// The result of resuming a session, which is sent to processLoop
+// by the go-routine that connects to the STOMP server again.
+type resumeResult struct {
+	writer *frame.Writer
+	readCh chan *frame.Frame
+	err    error
+}
+
+// Connects to the STOMP server again after the connection has been lost,
+// and presents the resume token to resume the session. Tries again until
+// the resume timeout has elapsed, or the Conn is closed. Returns the writer
+// for the new connection, and the channel of the frames read from it.
+func (c *Conn) reconnect() (*frame.Writer, chan *frame.Frame, error) {
+	deadline := time.Now().Add(c.resume.options.ResumeTimeout)
+	interval := resumeRetryInterval
+	for {
+		if c.resume.conn.isClosed() {
+			return nil, nil, ErrAlreadyClosed
+		}
+		writer, ch, err := c.resumeSession()
+		if err == nil {
+			return writer, ch, nil
+		}
+		if time.Now().Add(interval).After(deadline) {
+			c.log.Errorf("cannot resume session: %s", err.Error())
+			return nil, nil, err
+		}
+		c.log.Warningf("cannot resume session, retrying in %v: %s", interval, err.Error())
+		select {
+		case <-time.After(interval):
+		case <-c.resume.conn.done:
+			return nil, nil, ErrAlreadyClosed
+		}
+		if interval *= 2; interval > maxResumeRetryInterval {
+			interval = maxResumeRetryInterval
+		}
+	}
+}
+
+// Makes one attempt to connect again and resume the session.
+func (c *Conn) resumeSession() (*frame.Writer, chan *frame.Frame, error) {
+	conn, err := c.resume.options.Redial()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	connectFrame, err := c.resume.options.NewFrame()
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	connectFrame.Header.Set(frame.ResumeToken, c.resume.token)
+
+	reader, writer, response, err := handshake(conn, connectFrame, c.resume.options)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	if response.Header.Get(frame.Resumed) == "true" {
+		c.log.Infof("resumed session %s", response.Header.Get(frame.Session))
+	} else {
+		// the subscriptions are made again in the new session, but
+		// the messages that were not acknowledged are redelivered
+		// by the STOMP server to other subscriptions
+		c.log.Warningf("session expired, continuing in new session %s", response.Header.Get(frame.Session))
+	}
+	if token := response.Header.Get(frame.ResumeToken); token != "" {
+		c.resume.token = token
+	}
+	if !c.resume.conn.replace(conn) {
+		return nil, nil, ErrAlreadyClosed
+	}
+
+	ch := make(chan *frame.Frame, cap(c.readCh))
+	go readLoop(c, reader, ch)
+	return writer, ch, nil
+}
+
+// Writes the SUBSCRIBE frames of the active subscriptions, and the
+// UNSUBSCRIBE frames that are waiting for receipts, to the connection
+// of a resumed session. The STOMP server ignores the frames for the
+// subscriptions that it has kept and already unsubscribed.
+func replay(writer *frame.Writer, subscriptions, unsubscriptions map[string]*frame.Frame) error {
+	for _, m := range []map[string]*frame.Frame{subscriptions, unsubscriptions} {
+		for _, f := range m {
+			if _, ok := f.Header.Contains(ReplyToHeader); ok {
+				continue
+			}
+			if err := writer.Write(f); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Sends ERROR frames to the requests waiting for receipts that will not
+// arrive, because their frames were written to the lost connection. The
+// channels of the subscriptions are kept.
+func (c *Conn) failReceipts(channels map[string]chan *frame.Frame, subscriptions, unsubscriptions map[string]*frame.Frame) {
+	keep := make(map[chan *frame.Frame]bool)
+	for _, m := range []map[string]*frame.Frame{subscriptions, unsubscriptions} {
+		for id := range m {
+			keep[channels[id]] = true
+		}
+	}
+
+	f := frame.New(frame.ERROR, frame.Message, ErrConnectionLost.Error())
+	for id, ch := range channels {
+		if keep[ch] {
+			continue
+		}
+		delete(channels, id)
+		c.sendResponse(ch, f)
+	}
+}
+
+// Sends ERROR frames to the write requests that were received while the
+// connection was lost, and that cannot be written because the session
+// could not be resumed.
+func (c *Conn) failPending(pending []writeRequest, err error) {
+	f := frame.New(frame.ERROR, frame.Message, err.Error())
+	for _, req := range pending {
+		if req.C != nil {
+			c.sendResponse(req.C, f)
+		}
+	}
+}
+
+// Sends a response frame to a request channel,
+// without waiting for the request to receive it.
+func (c *Conn) sendResponse(ch chan *frame.Frame, f *frame.Frame) {
+	// the request might no longer be waiting
+	go func() {
+		var timeout <-chan time.Time
+		if c.rcvReceiptTimeout > 0 {
+			timeout = time.After(c.rcvReceiptTimeout)
+		}
+		select {
+		case ch <- f:
+		case <-timeout:
+		}
+	}()
+}
@@ -0,0 +1,212 @@
+package stomp
+
+import (
+	"errors"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/go-stomp/stomp/v3/testutil"
+	. "gopkg.in/check.v1"
+)
+
+func (s *StompSuite) Test_resume_session(c *C) {
+	fc1, fc2 := testutil.NewFakeConn(c)
+	fc3, fc4 := testutil.NewFakeConn(c)
+	stop := make(chan struct{})
+
+	go func() {
+		defer close(stop)
+
+		// the first connection is lost after subscribing
+		reader := frame.NewReader(fc2)
+		writer := frame.NewWriter(fc2)
+		f1, err := reader.Read()
+		c.Assert(err, IsNil)
+		c.Assert(f1.Header.Get(frame.Resumable), Equals, "true")
+		c.Assert(f1.Header.Get(frame.ResumeToken), Equals, "")
+		err = writer.Write(frame.New(frame.CONNECTED,
+			frame.Version, "1.2",
+			frame.Session, "session-1",
+			frame.ResumeToken, "token-1"))
+		c.Assert(err, IsNil)
+		f2, err := reader.Read()
+		c.Assert(err, IsNil)
+		c.Assert(f2.Command, Equals, frame.SUBSCRIBE)
+		id := f2.Header.Get(frame.Id)
+		fc2.Close()
+
+		// the session is resumed on the second connection,
+		// and the subscription is repeated
+		reader = frame.NewReader(fc4)
+		writer = frame.NewWriter(fc4)
+		f3, err := reader.Read()
+		c.Assert(err, IsNil)
+		c.Assert(f3.Command, Equals, frame.CONNECT)
+		c.Assert(f3.Header.Get(frame.ResumeToken), Equals, "token-1")
+		err = writer.Write(frame.New(frame.CONNECTED,
+			frame.Version, "1.2",
+			frame.Session, "session-1",
+			frame.ResumeToken, "token-1",
+			frame.Resumed, "true"))
+		c.Assert(err, IsNil)
+		f4, err := reader.Read()
+		c.Assert(err, IsNil)
+		c.Assert(f4.Command, Equals, frame.SUBSCRIBE)
+		c.Assert(f4.Header.Get(frame.Id), Equals, id)
+		err = writer.Write(frame.New(frame.MESSAGE,
+			frame.Subscription, id,
+			frame.MessageId, "1",
+			frame.Destination, "/queue/resume"))
+		c.Assert(err, IsNil)
+
+		f5, err := reader.Read()
+		c.Assert(err, IsNil)
+		c.Assert(f5.Command, Equals, frame.UNSUBSCRIBE)
+		err = writer.Write(frame.New(frame.RECEIPT, frame.ReceiptId, f5.Header.Get(frame.Receipt)))
+		c.Assert(err, IsNil)
+		f6, err := reader.Read()
+		c.Assert(err, IsNil)
+		c.Assert(f6.Command, Equals, frame.DISCONNECT)
+		err = writer.Write(frame.New(frame.RECEIPT, frame.ReceiptId, f6.Header.Get(frame.Receipt)))
+		c.Assert(err, IsNil)
+		fc4.Close()
+	}()
+
+	redialed := 0
+	conn, err := Connect(fc1, ConnOpt.Resumable(func() (io.ReadWriteCloser, error) {
+		redialed++
+		return fc3, nil
+	}))
+	c.Assert(err, IsNil)
+	sub, err := conn.Subscribe("/queue/resume", AckAuto)
+	c.Assert(err, IsNil)
+
+	msg := <-sub.C
+	c.Assert(msg.Err, IsNil)
+	c.Check(msg.Destination, Equals, "/queue/resume")
+	c.Check(msg.Conn, Equals, conn)
+	c.Assert(sub.Unsubscribe(), IsNil)
+	c.Assert(conn.Disconnect(), IsNil)
+	<-stop
+	c.Check(redialed, Equals, 1)
+}
+
+func (s *StompSuite) Test_resume_queues_writes(c *C) {
+	fc1, fc2 := testutil.NewFakeConn(c)
+	fc3, fc4 := testutil.NewFakeConn(c)
+	dialing, release := make(chan struct{}), make(chan struct{})
+	stop := make(chan struct{})
+
+	go func() {
+		defer close(stop)
+
+		// the first connection is lost after connecting
+		reader := frame.NewReader(fc2)
+		writer := frame.NewWriter(fc2)
+		_, err := reader.Read()
+		c.Assert(err, IsNil)
+		err = writer.Write(frame.New(frame.CONNECTED,
+			frame.Version, "1.2",
+			frame.ResumeToken, "token-1"))
+		c.Assert(err, IsNil)
+		fc2.Close()
+
+		// the messages sent while the session was being resumed
+		// are written once the session has been resumed
+		reader = frame.NewReader(fc4)
+		writer = frame.NewWriter(fc4)
+		f1, err := reader.Read()
+		c.Assert(err, IsNil)
+		c.Assert(f1.Command, Equals, frame.CONNECT)
+		err = writer.Write(frame.New(frame.CONNECTED,
+			frame.Version, "1.2",
+			frame.ResumeToken, "token-1",
+			frame.Resumed, "true"))
+		c.Assert(err, IsNil)
+		for _, body := range []string{"first", "second", "third"} {
+			f2, err := reader.Read()
+			c.Assert(err, IsNil)
+			c.Assert(f2.Command, Equals, frame.SEND)
+			c.Check(string(f2.Body), Equals, body)
+			if receipt, ok := f2.Header.Contains(frame.Receipt); ok {
+				err = writer.Write(frame.New(frame.RECEIPT, frame.ReceiptId, receipt))
+				c.Assert(err, IsNil)
+			}
+		}
+		f3, err := reader.Read()
+		c.Assert(err, IsNil)
+		c.Assert(f3.Command, Equals, frame.DISCONNECT)
+		err = writer.Write(frame.New(frame.RECEIPT, frame.ReceiptId, f3.Header.Get(frame.Receipt)))
+		c.Assert(err, IsNil)
+		fc4.Close()
+	}()
+
+	// with a write channel buffer of one frame, sending the second
+	// frame waits until the first is taken by the process loop
+	conn, err := Connect(fc1,
+		ConnOpt.Resumable(func() (io.ReadWriteCloser, error) {
+			close(dialing)
+			<-release
+			return fc3, nil
+		}),
+		ConnOpt.WriteChannelCapacity(1),
+		ConnOpt.MsgSendTimeout(time.Second))
+	c.Assert(err, IsNil)
+	<-dialing
+	c.Assert(conn.Send("/queue/resume", "text/plain", []byte("first")), IsNil)
+	c.Assert(conn.Send("/queue/resume", "text/plain", []byte("second")), IsNil)
+	close(release)
+
+	// the receipt arrives once the session has been resumed
+	c.Assert(conn.Send("/queue/resume", "text/plain", []byte("third"), SendOpt.Receipt), IsNil)
+	c.Assert(conn.Disconnect(), IsNil)
+	<-stop
+}
+
+func (s *StompSuite) Test_resume_disconnect(c *C) {
+	fc1, fc2 := testutil.NewFakeConn(c)
+
+	go func() {
+		reader := frame.NewReader(fc2)
+		writer := frame.NewWriter(fc2)
+		_, err := reader.Read()
+		c.Assert(err, IsNil)
+		err = writer.Write(frame.New(frame.CONNECTED,
+			frame.Version, "1.2",
+			frame.ResumeToken, "token-1"))
+		c.Assert(err, IsNil)
+		fc2.Close()
+	}()
+
+	var redialed int32
+	dialing := make(chan struct{}, 1)
+	conn, err := Connect(fc1, ConnOpt.Resumable(func() (io.ReadWriteCloser, error) {
+		atomic.AddInt32(&redialed, 1)
+		select {
+		case dialing <- struct{}{}:
+		default:
+		}
+		return nil, errors.New("connection refused")
+	}))
+	c.Assert(err, IsNil)
+	<-dialing
+
+	// disconnecting stops resuming the session, rather than
+	// waiting for the resume timeout to elapse
+	start := time.Now()
+	c.Assert(conn.Disconnect(), IsNil)
+	c.Check(time.Since(start) < time.Second, Equals, true)
+	n := atomic.LoadInt32(&redialed)
+	time.Sleep(2 * resumeRetryInterval)
+	c.Check(atomic.LoadInt32(&redialed), Equals, n)
+	c.Check(conn.Send("/queue/resume", "text/plain", nil), Equals, ErrAlreadyClosed)
+}
+
+func (s *StompSuite) Test_resume_requires_redial(c *C) {
+	fc1, fc2 := testutil.NewFakeConn(c)
+	defer fc2.Close()
+	_, err := Connect(fc1, ConnOpt.Resumable(nil))
+	c.Check(err, Equals, ErrNoRedial)
+}
@@ -0,0 +1,292 @@
+package selector
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A value is the result of evaluating an expression: a bool,
+// float64 or string, or nil if the value is unknown (NULL).
+type value interface{}
+
+// A node of the parsed expression.
+type node interface {
+	eval(header func(name string) (string, bool)) value
+}
+
+type literalNode struct {
+	v value
+}
+
+type identifierNode struct {
+	name string
+}
+
+type notNode struct {
+	x node
+}
+
+type andNode struct {
+	left, right node
+}
+
+type orNode struct {
+	left, right node
+}
+
+type compareNode struct {
+	op          string
+	left, right node
+}
+
+type arithNode struct {
+	op          string
+	left, right node
+}
+
+type negateNode struct {
+	x node
+}
+
+type likeNode struct {
+	x       node
+	re      *regexp.Regexp
+	negated bool
+}
+
+type inNode struct {
+	x       node
+	list    []value
+	negated bool
+}
+
+type betweenNode struct {
+	x, low, high node
+	negated      bool
+}
+
+type isNullNode struct {
+	x       node
+	negated bool
+}
+
+func (n literalNode) eval(header func(string) (string, bool)) value {
+	return n.v
+}
+
+func (n identifierNode) eval(header func(string) (string, bool)) value {
+	if v, ok := header(n.name); ok {
+		return v
+	}
+	return nil
+}
+
+func (n notNode) eval(header func(string) (string, bool)) value {
+	return not(toBool(n.x.eval(header)))
+}
+
+func (n andNode) eval(header func(string) (string, bool)) value {
+	left := toBool(n.left.eval(header))
+	if left == false {
+		return false
+	}
+	right := toBool(n.right.eval(header))
+	if right == false {
+		return false
+	}
+	if left == nil || right == nil {
+		return nil
+	}
+	return true
+}
+
+func (n orNode) eval(header func(string) (string, bool)) value {
+	left := toBool(n.left.eval(header))
+	if left == true {
+		return true
+	}
+	right := toBool(n.right.eval(header))
+	if right == true {
+		return true
+	}
+	if left == nil || right == nil {
+		return nil
+	}
+	return false
+}
+
+func (n compareNode) eval(header func(string) (string, bool)) value {
+	return compare(n.op, n.left.eval(header), n.right.eval(header))
+}
+
+func (n arithNode) eval(header func(string) (string, bool)) value {
+	left, right := toNumber(n.left.eval(header)), toNumber(n.right.eval(header))
+	if left == nil || right == nil {
+		return nil
+	}
+	a, b := left.(float64), right.(float64)
+	switch n.op {
+	case "+":
+		return a + b
+	case "-":
+		return a - b
+	case "*":
+		return a * b
+	}
+	if b == 0 {
+		return nil
+	}
+	return a / b
+}
+
+func (n negateNode) eval(header func(string) (string, bool)) value {
+	if x := toNumber(n.x.eval(header)); x != nil {
+		return -x.(float64)
+	}
+	return nil
+}
+
+func (n likeNode) eval(header func(string) (string, bool)) value {
+	s, ok := n.x.eval(header).(string)
+	if !ok {
+		return nil
+	}
+	return n.re.MatchString(s) != n.negated
+}
+
+func (n inNode) eval(header func(string) (string, bool)) value {
+	x := n.x.eval(header)
+	if x == nil {
+		return nil
+	}
+	var result value = false
+	for _, v := range n.list {
+		switch compare("=", x, v) {
+		case true:
+			result = true
+		case nil:
+			if result == false {
+				result = nil
+			}
+		}
+		if result == true {
+			break
+		}
+	}
+	if n.negated {
+		return not(result)
+	}
+	return result
+}
+
+func (n betweenNode) eval(header func(string) (string, bool)) value {
+	x := n.x.eval(header)
+	result := andNode{
+		literalNode{compare(">=", x, n.low.eval(header))},
+		literalNode{compare("<=", x, n.high.eval(header))},
+	}.eval(header)
+	if n.negated {
+		return not(result)
+	}
+	return result
+}
+
+func (n isNullNode) eval(header func(string) (string, bool)) value {
+	return (n.x.eval(header) == nil) != n.negated
+}
+
+func not(v value) value {
+	if b, ok := v.(bool); ok {
+		return !b
+	}
+	return nil
+}
+
+// Converts a value to a bool, or nil if it is not a bool, or a
+// string that is "true" or "false" ignoring case.
+func toBool(v value) value {
+	switch v := v.(type) {
+	case bool:
+		return v
+	case string:
+		if strings.EqualFold(v, "true") {
+			return true
+		} else if strings.EqualFold(v, "false") {
+			return false
+		}
+	}
+	return nil
+}
+
+// Converts a value to a float64, or nil if it is not
+// a number, or a string that can be parsed as a number.
+func toNumber(v value) value {
+	switch v := v.(type) {
+	case float64:
+		return v
+	case string:
+		if n, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+			return n
+		}
+	}
+	return nil
+}
+
+// Compares two values. If either value is a number, both are compared
+// as numbers, and if either is a bool, both are compared as bools, which
+// can only be equal or not equal. Otherwise both are compared as strings.
+// Returns nil if either value is unknown or cannot be converted.
+func compare(op string, a, b value) value {
+	if a == nil || b == nil {
+		return nil
+	}
+
+	var c int
+	_, aNumber := a.(float64)
+	_, bNumber := b.(float64)
+	_, aBool := a.(bool)
+	_, bBool := b.(bool)
+	switch {
+	case aNumber || bNumber:
+		a, b = toNumber(a), toNumber(b)
+		if a == nil || b == nil {
+			return nil
+		}
+		x, y := a.(float64), b.(float64)
+		switch {
+		case x < y:
+			c = -1
+		case x > y:
+			c = 1
+		}
+	case aBool || bBool:
+		a, b = toBool(a), toBool(b)
+		if a == nil || b == nil {
+			return nil
+		}
+		switch op {
+		case "=":
+			return a == b
+		case "<>":
+			return a != b
+		}
+		return nil
+	default:
+		c = strings.Compare(a.(string), b.(string))
+	}
+
+	switch op {
+	case "=":
+		return c == 0
+	case "<>":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	}
+	return c >= 0
+}
@@ -0,0 +1,177 @@
+package selector
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokEOF        tokenKind = iota
+	tokIdentifier           // identifier or quoted identifier
+	tokKeyword              // AND, OR, NOT, ...; text is upper case
+	tokString               // string literal; text is the unquoted value
+	tokNumber               // numeric literal
+	tokOperator             // = <> < <= > >= + - * / ( ) ,
+)
+
+type token struct {
+	kind   tokenKind
+	text   string
+	number float64
+	offset int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of expression"
+	case tokString:
+		return fmt.Sprintf("string '%s'", t.text)
+	case tokIdentifier:
+		return fmt.Sprintf("identifier %q", t.text)
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+var keywords = map[string]bool{
+	"AND":     true,
+	"OR":      true,
+	"NOT":     true,
+	"LIKE":    true,
+	"ESCAPE":  true,
+	"IN":      true,
+	"BETWEEN": true,
+	"IS":      true,
+	"NULL":    true,
+	"TRUE":    true,
+	"FALSE":   true,
+}
+
+// Splits a selector expression into tokens.
+type lexer struct {
+	text string
+	pos  int
+}
+
+func (l *lexer) errorf(offset int, format string, args ...interface{}) error {
+	return &SyntaxError{Offset: offset, Message: fmt.Sprintf(format, args...)}
+}
+
+// Returns the next token.
+func (l *lexer) next() (token, error) {
+	for l.pos < len(l.text) && isSpace(l.text[l.pos]) {
+		l.pos++
+	}
+	start := l.pos
+	if l.pos == len(l.text) {
+		return token{kind: tokEOF, offset: start}, nil
+	}
+
+	ch := l.text[l.pos]
+	switch {
+	case isIdentifierStart(ch):
+		for l.pos < len(l.text) && isIdentifierPart(l.text[l.pos]) {
+			l.pos++
+		}
+		word := l.text[start:l.pos]
+		if upper := strings.ToUpper(word); keywords[upper] {
+			return token{kind: tokKeyword, text: upper, offset: start}, nil
+		}
+		return token{kind: tokIdentifier, text: word, offset: start}, nil
+
+	case ch == '"' || ch == '\'':
+		text, err := l.quoted(ch)
+		if err != nil {
+			return token{}, err
+		}
+		kind := tokString
+		if ch == '"' {
+			kind = tokIdentifier
+		}
+		return token{kind: kind, text: text, offset: start}, nil
+
+	case isDigit(ch) || ch == '.' && l.pos+1 < len(l.text) && isDigit(l.text[l.pos+1]):
+		return l.numberToken()
+	}
+
+	for _, op := range []string{"<>", "<=", ">=", "=", "<", ">", "+", "-", "*", "/", "(", ")", ","} {
+		if strings.HasPrefix(l.text[l.pos:], op) {
+			l.pos += len(op)
+			return token{kind: tokOperator, text: op, offset: start}, nil
+		}
+	}
+	return token{}, l.errorf(start, "unexpected character %q", ch)
+}
+
+// Reads a string or identifier in quotes, in which
+// the quote character is escaped by doubling it.
+func (l *lexer) quoted(quote byte) (string, error) {
+	start := l.pos
+	l.pos++
+	var b strings.Builder
+	for l.pos < len(l.text) {
+		ch := l.text[l.pos]
+		l.pos++
+		if ch != quote {
+			b.WriteByte(ch)
+			continue
+		}
+		if l.pos < len(l.text) && l.text[l.pos] == quote {
+			b.WriteByte(quote)
+			l.pos++
+			continue
+		}
+		return b.String(), nil
+	}
+	return "", l.errorf(start, "unterminated %c", quote)
+}
+
+func (l *lexer) numberToken() (token, error) {
+	start := l.pos
+	for l.pos < len(l.text) && isDigit(l.text[l.pos]) {
+		l.pos++
+	}
+	if l.pos < len(l.text) && l.text[l.pos] == '.' {
+		l.pos++
+		for l.pos < len(l.text) && isDigit(l.text[l.pos]) {
+			l.pos++
+		}
+	}
+	if l.pos < len(l.text) && (l.text[l.pos] == 'e' || l.text[l.pos] == 'E') {
+		l.pos++
+		if l.pos < len(l.text) && (l.text[l.pos] == '+' || l.text[l.pos] == '-') {
+			l.pos++
+		}
+		for l.pos < len(l.text) && isDigit(l.text[l.pos]) {
+			l.pos++
+		}
+	}
+	text := l.text[start:l.pos]
+	if l.pos < len(l.text) && isIdentifierPart(l.text[l.pos]) {
+		return token{}, l.errorf(start, "invalid number %q", text+string(l.text[l.pos]))
+	}
+	n, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return token{}, l.errorf(start, "invalid number %q", text)
+	}
+	return token{kind: tokNumber, text: text, number: n, offset: start}, nil
+}
+
+func isSpace(ch byte) bool {
+	return ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r'
+}
+
+func isDigit(ch byte) bool {
+	return '0' <= ch && ch <= '9'
+}
+
+func isIdentifierStart(ch byte) bool {
+	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_' || ch == '$'
+}
+
+func isIdentifierPart(ch byte) bool {
+	return isIdentifierStart(ch) || isDigit(ch) || ch == '.'
+}
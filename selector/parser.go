@@ -0,0 +1,333 @@
+package selector
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// A recursive descent parser with one token of lookahead.
+type parser struct {
+	lexer
+	tok token // current token
+}
+
+func (p *parser) next() error {
+	tok, err := p.lexer.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return p.lexer.errorf(p.tok.offset, format, args...)
+}
+
+func (p *parser) isKeyword(keyword string) bool {
+	return p.tok.kind == tokKeyword && p.tok.text == keyword
+}
+
+func (p *parser) isOperator(op string) bool {
+	return p.tok.kind == tokOperator && p.tok.text == op
+}
+
+// Skips the current token, which must be the keyword or operator.
+func (p *parser) expect(text string) error {
+	if (p.tok.kind != tokKeyword && p.tok.kind != tokOperator) || p.tok.text != text {
+		return p.errorf("expected %s, found %s", text, p.tok)
+	}
+	return p.next()
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.isKeyword("OR") {
+		var right node
+		if err = p.next(); err == nil {
+			if right, err = p.parseAnd(); err == nil {
+				left = orNode{left, right}
+			}
+		}
+	}
+	return left, err
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseNot()
+	for err == nil && p.isKeyword("AND") {
+		var right node
+		if err = p.next(); err == nil {
+			if right, err = p.parseNot(); err == nil {
+				left = andNode{left, right}
+			}
+		}
+	}
+	return left, err
+}
+
+func (p *parser) parseNot() (node, error) {
+	if !p.isKeyword("NOT") {
+		return p.parseComparison()
+	}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	x, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	return notNode{x}, nil
+}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.tok.kind == tokOperator {
+		switch op := p.tok.text; op {
+		case "=", "<>", "<", "<=", ">", ">=":
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			right, err := p.parseAdditive()
+			if err != nil {
+				return nil, err
+			}
+			return compareNode{op, left, right}, nil
+		}
+		return left, nil
+	}
+
+	if p.isKeyword("IS") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		negated := p.isKeyword("NOT")
+		if negated {
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+		}
+		if err := p.expect("NULL"); err != nil {
+			return nil, err
+		}
+		return isNullNode{left, negated}, nil
+	}
+
+	negated := p.isKeyword("NOT")
+	if negated {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+	}
+	switch {
+	case p.isKeyword("LIKE"):
+		return p.parseLike(left, negated)
+	case p.isKeyword("IN"):
+		return p.parseIn(left, negated)
+	case p.isKeyword("BETWEEN"):
+		return p.parseBetween(left, negated)
+	case negated:
+		return nil, p.errorf("expected LIKE, IN or BETWEEN, found %s", p.tok)
+	}
+	return left, nil
+}
+
+func (p *parser) parseLike(x node, negated bool) (node, error) {
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokString {
+		return nil, p.errorf("expected pattern string, found %s", p.tok)
+	}
+	pattern, offset := p.tok.text, p.tok.offset
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+
+	var escape rune
+	if p.isKeyword("ESCAPE") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		runes := []rune(p.tok.text)
+		if p.tok.kind != tokString || len(runes) != 1 {
+			return nil, p.errorf("expected escape character, found %s", p.tok)
+		}
+		escape = runes[0]
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+	}
+
+	re, err := likePattern(pattern, escape)
+	if err != nil {
+		return nil, p.lexer.errorf(offset, "%s", err.Error())
+	}
+	return likeNode{x, re, negated}, nil
+}
+
+// Converts a LIKE pattern, in which % matches any sequence of
+// characters and _ matches any one character, to a regular expression.
+func likePattern(pattern string, escape rune) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("(?s)^")
+	escaped := false
+	for _, r := range pattern {
+		switch {
+		case escaped:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+			escaped = false
+		case escape != 0 && r == escape:
+			escaped = true
+		case r == '%':
+			b.WriteString(".*")
+		case r == '_':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	if escaped {
+		return nil, fmt.Errorf("pattern ends with escape character")
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+func (p *parser) parseIn(x node, negated bool) (node, error) {
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var list []value
+	for {
+		switch p.tok.kind {
+		case tokString:
+			list = append(list, p.tok.text)
+		case tokNumber:
+			list = append(list, p.tok.number)
+		default:
+			return nil, p.errorf("expected literal, found %s", p.tok)
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		if !p.isOperator(",") {
+			break
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	return inNode{x, list, negated}, nil
+}
+
+func (p *parser) parseBetween(x node, negated bool) (node, error) {
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	low, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect("AND"); err != nil {
+		return nil, err
+	}
+	high, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	return betweenNode{x, low, high, negated}, nil
+}
+
+func (p *parser) parseAdditive() (node, error) {
+	left, err := p.parseMultiplicative()
+	for err == nil && (p.isOperator("+") || p.isOperator("-")) {
+		op := p.tok.text
+		var right node
+		if err = p.next(); err == nil {
+			if right, err = p.parseMultiplicative(); err == nil {
+				left = arithNode{op, left, right}
+			}
+		}
+	}
+	return left, err
+}
+
+func (p *parser) parseMultiplicative() (node, error) {
+	left, err := p.parseUnary()
+	for err == nil && (p.isOperator("*") || p.isOperator("/")) {
+		op := p.tok.text
+		var right node
+		if err = p.next(); err == nil {
+			if right, err = p.parseUnary(); err == nil {
+				left = arithNode{op, left, right}
+			}
+		}
+	}
+	return left, err
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if !p.isOperator("+") && !p.isOperator("-") {
+		return p.parsePrimary()
+	}
+	op := p.tok.text
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	x, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	if op == "-" {
+		return negateNode{x}, nil
+	}
+	return x, nil
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	var n node
+	switch {
+	case p.tok.kind == tokNumber:
+		n = literalNode{p.tok.number}
+	case p.tok.kind == tokString:
+		n = literalNode{p.tok.text}
+	case p.tok.kind == tokIdentifier:
+		n = identifierNode{p.tok.text}
+	case p.isKeyword("TRUE"):
+		n = literalNode{true}
+	case p.isKeyword("FALSE"):
+		n = literalNode{false}
+	case p.isKeyword("NULL"):
+		n = literalNode{nil}
+	case p.isOperator("("):
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return x, nil
+	default:
+		return nil, p.errorf("unexpected %s", p.tok)
+	}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
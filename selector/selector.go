@@ -0,0 +1,90 @@
+/*
+Package selector implements message selectors, which are SQL-92 style
+conditional expressions over the header entries of a message, as used
+by the "selector" SUBSCRIBE header entry of some STOMP brokers.
+
+An identifier in an expression is the value of the header entry with that
+name, or NULL if the message does not have the header entry. Header names
+that are not valid identifiers, such as "customer-id", are written in
+double quotes. String literals are written in single quotes, with a single
+quote in a string written as two single quotes.
+
+	priority > 5 AND type IN ('order', 'refund')
+	"customer-id" LIKE 'eu-%' OR region IS NULL
+	amount BETWEEN 100 AND 1000 AND NOT urgent = TRUE
+
+The operators are, from lowest to highest precedence: OR; AND; NOT;
+the comparisons =, <>, <, <=, >, >=, [NOT] LIKE with an optional ESCAPE,
+[NOT] IN, [NOT] BETWEEN and IS [NOT] NULL; the additive operators + and -;
+the multiplicative operators * and /; and the unary operators + and -.
+Keywords are not case-sensitive.
+
+Header values are strings, which are converted to numbers when they are
+compared with, or used in arithmetic with, numbers, and to booleans when
+they are compared with TRUE or FALSE, or used as conditions. A value that
+cannot be converted, like a missing header entry, is unknown, and the
+logical operators follow the three-valued logic of SQL. A message is
+selected only if the expression is true.
+*/
+package selector
+
+import (
+	"fmt"
+)
+
+// A Selector is a parsed message selector. It is safe
+// for concurrent use by multiple goroutines.
+type Selector struct {
+	text string
+	expr node
+}
+
+// A SyntaxError describes an expression that cannot be parsed.
+type SyntaxError struct {
+	Offset  int    // Byte offset in the expression where the error was found
+	Message string // Description of the error
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("selector: %s at offset %d", e.Message, e.Offset)
+}
+
+// Parse parses a selector expression. Returns
+// a *SyntaxError if the expression is not valid.
+func Parse(text string) (*Selector, error) {
+	p := &parser{lexer: lexer{text: text}}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, p.errorf("unexpected %s", p.tok)
+	}
+	return &Selector{text: text, expr: expr}, nil
+}
+
+// MustParse is like Parse, but panics if the expression is not valid.
+func MustParse(text string) *Selector {
+	s, err := Parse(text)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// String returns the expression of the selector.
+func (s *Selector) String() string {
+	return s.text
+}
+
+// Matches returns true if the expression is true for a message whose
+// header entries are looked up by the header function, which returns
+// the value of the header entry with the given name, and whether the
+// message has the header entry. The Contains method of frame.Header
+// has this signature.
+func (s *Selector) Matches(header func(name string) (string, bool)) bool {
+	return toBool(s.expr.eval(header)) == true
+}
@@ -0,0 +1,155 @@
+package selector
+
+import (
+	"testing"
+
+	. "gopkg.in/check.v1"
+)
+
+// Runs all gocheck tests in this package.
+// See other *_test.go files for gocheck tests.
+func TestSelector(t *testing.T) {
+	TestingT(t)
+}
+
+type SelectorSuite struct{}
+
+var _ = Suite(&SelectorSuite{})
+
+// Returns a header lookup function for the header entries in m.
+func lookup(m map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		v, ok := m[name]
+		return v, ok
+	}
+}
+
+func (s *SelectorSuite) TestMatches(c *C) {
+	header := lookup(map[string]string{
+		"type":        "order",
+		"priority":    "7",
+		"amount":      "250.5",
+		"urgent":      "true",
+		"customer-id": "eu-1042",
+		"path":        "50%_off",
+		"name":        "O'Brien",
+	})
+
+	testCases := []struct {
+		expr    string
+		matches bool
+	}{
+		{"type = 'order'", true},
+		{"type <> 'order'", false},
+		{"TYPE = 'order'", false},
+		{"type = 'order' and priority > 5", true},
+		{"type = 'refund' OR priority >= 7", true},
+		{"NOT type = 'order'", false},
+		{"priority = 7", true},
+		{"priority < 7.5", true},
+		{"priority * 2 + 1 = 15", true},
+		{"-priority = -7", true},
+		{"amount / 2 > 125", true},
+		{"amount / 0 > 0", false},
+		{"(priority - 2) * 2 = 10", true},
+		{"priority BETWEEN 5 AND 10", true},
+		{"priority NOT BETWEEN 5 AND 10", false},
+		{"amount BETWEEN 1e2 AND .3e3", true},
+		{"type IN ('order', 'refund')", true},
+		{"type NOT IN ('order', 'refund')", false},
+		{"priority IN (1, 7)", true},
+		{"\"customer-id\" LIKE 'eu-%'", true},
+		{"\"customer-id\" LIKE 'eu-____'", true},
+		{"\"customer-id\" LIKE 'eu-___'", false},
+		{"\"customer-id\" NOT LIKE 'us-%'", true},
+		{"path LIKE '50!%!_%' ESCAPE '!'", true},
+		{"path LIKE '50!%!_' ESCAPE '!'", false},
+		{"name = 'O''Brien'", true},
+		{"urgent = TRUE", true},
+		{"urgent", true},
+		{"urgent AND type = 'order'", true},
+		{"urgent = FALSE", false},
+		{"region IS NULL", true},
+		{"type IS NOT NULL", true},
+		{"region IS NOT NULL", false},
+		{"type > 'apple'", true},
+	}
+
+	for _, tc := range testCases {
+		sel, err := Parse(tc.expr)
+		c.Assert(err, IsNil, Commentf("%s", tc.expr))
+		c.Check(sel.Matches(header), Equals, tc.matches, Commentf("%s", tc.expr))
+		c.Check(sel.String(), Equals, tc.expr)
+	}
+}
+
+func (s *SelectorSuite) TestUnknown(c *C) {
+	header := lookup(map[string]string{
+		"type":     "order",
+		"priority": "high",
+	})
+
+	// a missing header entry, or a value that cannot be converted,
+	// is unknown, and neither the condition nor its negation is true
+	testCases := []struct {
+		expr    string
+		matches bool
+	}{
+		{"region = 'eu'", false},
+		{"NOT region = 'eu'", false},
+		{"priority > 5", false},
+		{"NOT priority > 5", false},
+		{"region = 'eu' AND type = 'order'", false},
+		{"region = 'eu' OR type = 'order'", true},
+		{"NOT (region = 'eu' AND type = 'refund')", true},
+		{"region IN ('eu')", false},
+		{"region NOT IN ('eu')", false},
+		{"region NOT LIKE 'e%'", false},
+		{"region + 1 = 1", false},
+		{"type = TRUE", false},
+		{"type <> TRUE", false},
+		{"type = NULL", false},
+	}
+
+	for _, tc := range testCases {
+		sel, err := Parse(tc.expr)
+		c.Assert(err, IsNil, Commentf("%s", tc.expr))
+		c.Check(sel.Matches(header), Equals, tc.matches, Commentf("%s", tc.expr))
+	}
+}
+
+func (s *SelectorSuite) TestSyntaxError(c *C) {
+	testCases := []struct {
+		expr   string
+		offset int
+	}{
+		{"", 0},
+		{"type =", 6},
+		{"type = 'order", 7},
+		{"type == 'order'", 6},
+		{"type 'order'", 5},
+		{"(priority > 5", 13},
+		{"priority > 5)", 12},
+		{"priority IS 5", 12},
+		{"type NOT = 'order'", 9},
+		{"type IN ()", 9},
+		{"type IN (type)", 9},
+		{"type LIKE type", 10},
+		{"type LIKE 'a' ESCAPE 'ab'", 21},
+		{"type LIKE 'a!' ESCAPE '!'", 10},
+		{"priority BETWEEN 1 OR 2", 19},
+		{"priority > 5x", 11},
+		{"type = #", 7},
+		{"\"type = 'order'", 0},
+	}
+
+	for _, tc := range testCases {
+		_, err := Parse(tc.expr)
+		c.Assert(err, FitsTypeOf, &SyntaxError{}, Commentf("%s", tc.expr))
+		c.Check(err.(*SyntaxError).Offset, Equals, tc.offset, Commentf("%s: %v", tc.expr, err))
+	}
+
+	_, err := Parse("type =")
+	c.Check(err, ErrorMatches, "selector: unexpected end of expression at offset 6")
+	c.Check(func() { MustParse("type =") }, PanicMatches, "selector: .*")
+}
@@ -0,0 +1,235 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/internal/log"
+)
+
+// Default interval between checks of the certificate files of a
+// TLS listener for changes. Override by setting Server.CertificateCheckInterval.
+const DefaultCertificateCheckInterval = time.Minute
+
+var errNotServingTLS = errors.New("server is not serving TLS")
+
+// A CertificateSource provides the certificate of a TLS listener, which
+// it loads from a PEM encoded certificate file and key file. The files
+// are loaded again by Reload, and by Watch when they change, so that a
+// renewed certificate is used for new TLS handshakes while existing
+// connections continue. If the files cannot be loaded, the previous
+// certificate continues to be used.
+type CertificateSource struct {
+	certFile string
+	keyFile  string
+	log      stomp.Logger
+
+	mutex sync.RWMutex
+	cert  *tls.Certificate
+	stamp [2]fileStamp // of the certificate and key files when last loaded
+}
+
+// Modification time and size of a file.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// NewCertificateSource loads the certificate and key from the given
+// files. Reload failures are logged to logger, or to the standard
+// logger if logger is nil.
+func NewCertificateSource(certFile, keyFile string, logger stomp.Logger) (*CertificateSource, error) {
+	if logger == nil {
+		logger = log.StdLogger{}
+	}
+	cs := &CertificateSource{certFile: certFile, keyFile: keyFile, log: logger}
+	if err := cs.load(); err != nil {
+		return nil, err
+	}
+	return cs, nil
+}
+
+// Certificate returns the certificate that is currently in use.
+func (cs *CertificateSource) Certificate() *tls.Certificate {
+	cs.mutex.RLock()
+	defer cs.mutex.RUnlock()
+	return cs.cert
+}
+
+// GetCertificate returns the certificate that is currently in use. It
+// has the signature of the tls.Config field of the same name.
+func (cs *CertificateSource) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return cs.Certificate(), nil
+}
+
+// TLSConfig returns a copy of config, or a new configuration if config
+// is nil, that uses the certificate of the source.
+func (cs *CertificateSource) TLSConfig(config *tls.Config) *tls.Config {
+	if config == nil {
+		config = &tls.Config{}
+	} else {
+		config = config.Clone()
+	}
+	config.Certificates = nil
+	config.GetCertificate = cs.GetCertificate
+	return config
+}
+
+// Reload loads the certificate and key files again. If they cannot be
+// loaded, the error is logged and returned, and the previous certificate
+// continues to be used.
+func (cs *CertificateSource) Reload() error {
+	err := cs.load()
+	if err != nil {
+		cs.log.Errorf("failed to reload certificate %s: %s", cs.certFile, err.Error())
+	}
+	return err
+}
+
+// Watch checks the certificate and key files for changes at the given
+// interval, and reloads them when either has changed. Returns a function
+// that stops watching, which returns once no more reloads can happen.
+func (cs *CertificateSource) Watch(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer close(exited)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				// both cases can be ready, in which case either is chosen
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if cs.changed() {
+					cs.Reload()
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+		<-exited
+	}
+}
+
+// Loads the certificate and key files, and replaces the
+// current certificate if successful.
+func (cs *CertificateSource) load() error {
+	// stamp the files before reading them, so that a change made
+	// while they are read is picked up by the next check
+	stamp := cs.stampFiles()
+	cert, err := tls.LoadX509KeyPair(cs.certFile, cs.keyFile)
+	if err != nil {
+		return err
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return err
+		}
+	}
+
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+	cs.cert = &cert
+	cs.stamp = stamp
+	return nil
+}
+
+// Returns true if the certificate or key file has changed since
+// the certificate was last loaded, or was last found to be invalid.
+func (cs *CertificateSource) changed() bool {
+	stamp := cs.stampFiles()
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+	if stamp == cs.stamp {
+		return false
+	}
+	// a failed reload is not repeated until the files change again
+	cs.stamp = stamp
+	return true
+}
+
+func (cs *CertificateSource) stampFiles() [2]fileStamp {
+	var stamp [2]fileStamp
+	for i, name := range []string{cs.certFile, cs.keyFile} {
+		if info, err := os.Stat(name); err == nil {
+			stamp[i] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+		}
+	}
+	return stamp
+}
+
+// ListenAndServeTLS listens on the TCP network address s.Addr and
+// then calls ServeTLS to handle requests on the incoming connections.
+// If s.Addr is blank, then DefaultAddr is used.
+func (s *Server) ListenAndServeTLS(certFile, keyFile string) error {
+	addr := s.Addr
+	if addr == "" {
+		addr = DefaultAddr
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return s.ServeTLS(l, certFile, keyFile)
+}
+
+// ServeTLS accepts incoming connections on the Listener l, and performs
+// a TLS handshake on each connection before calling Serve. The certificate
+// and key are loaded from the given files, which are checked for changes
+// every CertificateCheckInterval. A changed certificate is used for new
+// connections, and existing connections continue unaffected.
+func (s *Server) ServeTLS(l net.Listener, certFile, keyFile string) error {
+	if s.Log == nil {
+		s.Log = log.StdLogger{}
+	}
+	certs, err := NewCertificateSource(certFile, keyFile, s.Log)
+	if err != nil {
+		return err
+	}
+
+	interval := s.CertificateCheckInterval
+	if interval == 0 {
+		interval = DefaultCertificateCheckInterval
+	}
+	if interval > 0 {
+		stop := certs.Watch(interval)
+		defer stop()
+	}
+
+	s.mutex.Lock()
+	s.certs = certs
+	s.mutex.Unlock()
+
+	return s.Serve(tls.NewListener(l, certs.TLSConfig(s.TLSConfig)))
+}
+
+// ReloadCertificate loads the certificate and key files of ServeTLS
+// again, for example when the certificate has been renewed. If they
+// cannot be loaded, the error is logged and returned, and the previous
+// certificate continues to be used.
+func (s *Server) ReloadCertificate() error {
+	s.mutex.Lock()
+	certs := s.certs
+	s.mutex.Unlock()
+	if certs == nil {
+		return errNotServingTLS
+	}
+	return certs.Reload()
+}
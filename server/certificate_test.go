@@ -0,0 +1,190 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-stomp/stomp/v3"
+	. "gopkg.in/check.v1"
+)
+
+type CertificateSuite struct{}
+
+var _ = Suite(&CertificateSuite{})
+
+// Writes a self-signed certificate with the given serial number, and its
+// key, to cert.pem and key.pem in dir. The modification time of the files
+// is set to the serial number of seconds after the epoch, so that every
+// certificate is detected as a change.
+func writeCertificate(c *C, dir string, serial int64) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, IsNil)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	c.Assert(err, IsNil)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	c.Assert(err, IsNil)
+
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	err = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	c.Assert(err, IsNil)
+	err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	c.Assert(err, IsNil)
+	for _, name := range []string{certFile, keyFile} {
+		err = os.Chtimes(name, time.Unix(serial, 0), time.Unix(serial, 0))
+		c.Assert(err, IsNil)
+	}
+	return certFile, keyFile
+}
+
+func serialOf(cert *tls.Certificate) int64 {
+	return cert.Leaf.SerialNumber.Int64()
+}
+
+func (s *CertificateSuite) TestReload(c *C) {
+	dir := c.MkDir()
+	certFile, keyFile := writeCertificate(c, dir, 1)
+
+	cs, err := NewCertificateSource(certFile, keyFile, nil)
+	c.Assert(err, IsNil)
+	c.Check(serialOf(cs.Certificate()), Equals, int64(1))
+
+	writeCertificate(c, dir, 2)
+	c.Assert(cs.Reload(), IsNil)
+	cert, err := cs.GetCertificate(nil)
+	c.Assert(err, IsNil)
+	c.Check(serialOf(cert), Equals, int64(2))
+
+	// a failed reload keeps the previous certificate
+	err = ioutil.WriteFile(certFile, []byte("not a certificate"), 0600)
+	c.Assert(err, IsNil)
+	c.Check(cs.Reload(), NotNil)
+	c.Check(serialOf(cs.Certificate()), Equals, int64(2))
+
+	_, err = NewCertificateSource(certFile, keyFile, nil)
+	c.Check(err, NotNil)
+	_, err = NewCertificateSource(filepath.Join(dir, "missing.pem"), keyFile, nil)
+	c.Check(err, NotNil)
+}
+
+func (s *CertificateSuite) TestWatch(c *C) {
+	dir := c.MkDir()
+	certFile, keyFile := writeCertificate(c, dir, 1)
+
+	cs, err := NewCertificateSource(certFile, keyFile, nil)
+	c.Assert(err, IsNil)
+	stop := cs.Watch(5 * time.Millisecond)
+	defer stop()
+
+	writeCertificate(c, dir, 2)
+	waitForSerial(c, cs.Certificate, 2)
+
+	stop()
+	stop()
+	writeCertificate(c, dir, 3)
+	time.Sleep(20 * time.Millisecond)
+	c.Check(serialOf(cs.Certificate()), Equals, int64(2))
+}
+
+// Waits for the certificate returned by cert to have the serial number.
+func waitForSerial(c *C, cert func() *tls.Certificate, serial int64) {
+	for i := 0; i < 200 && serialOf(cert()) != serial; i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	c.Assert(serialOf(cert()), Equals, serial)
+}
+
+// Connects to the server over TLS, and returns the
+// connection and the serial number of the server's certificate.
+func dialTLS(c *C, addr string) (*tls.Conn, int64) {
+	conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+	c.Assert(err, IsNil)
+	return conn, conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+}
+
+func (s *CertificateSuite) TestServeTLS(c *C) {
+	dir := c.MkDir()
+	certFile, keyFile := writeCertificate(c, dir, 1)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer l.Close()
+	srv := &Server{CertificateCheckInterval: -1}
+	c.Check(srv.ReloadCertificate(), Equals, errNotServingTLS)
+	go srv.ServeTLS(l, certFile, keyFile)
+
+	conn1, serial := dialTLS(c, l.Addr().String())
+	defer conn1.Close()
+	c.Check(serial, Equals, int64(1))
+	client1, err := stomp.Connect(conn1)
+	c.Assert(err, IsNil)
+	defer client1.Disconnect()
+	sub, err := client1.Subscribe("/queue/tls", stomp.AckAuto)
+	c.Assert(err, IsNil)
+
+	// new connections get the new certificate
+	writeCertificate(c, dir, 2)
+	c.Assert(srv.ReloadCertificate(), IsNil)
+	conn2, serial := dialTLS(c, l.Addr().String())
+	defer conn2.Close()
+	c.Check(serial, Equals, int64(2))
+	client2, err := stomp.Connect(conn2)
+	c.Assert(err, IsNil)
+	defer client2.Disconnect()
+
+	// and existing connections continue
+	err = client2.Send("/queue/tls", "text/plain", []byte("rotated"))
+	c.Assert(err, IsNil)
+	expectOnce(c, sub, "rotated")
+
+	// a failed reload keeps the current certificate
+	err = ioutil.WriteFile(keyFile, []byte("not a key"), 0600)
+	c.Assert(err, IsNil)
+	c.Check(srv.ReloadCertificate(), NotNil)
+	conn3, serial := dialTLS(c, l.Addr().String())
+	conn3.Close()
+	c.Check(serial, Equals, int64(2))
+}
+
+func (s *CertificateSuite) TestServeTLSWatch(c *C) {
+	dir := c.MkDir()
+	certFile, keyFile := writeCertificate(c, dir, 1)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer l.Close()
+	srv := &Server{CertificateCheckInterval: 5 * time.Millisecond}
+	go srv.ServeTLS(l, certFile, keyFile)
+
+	conn, serial := dialTLS(c, l.Addr().String())
+	conn.Close()
+	c.Check(serial, Equals, int64(1))
+
+	writeCertificate(c, dir, 2)
+	waitForSerial(c, func() *tls.Certificate {
+		conn, serial := dialTLS(c, l.Addr().String())
+		conn.Close()
+		return &tls.Certificate{Leaf: &x509.Certificate{SerialNumber: big.NewInt(serial)}}
+	}, 2)
+}
@@ -22,4 +22,57 @@ type Config interface {
 
 	// Logger provides the logger for a client
 	Logger() stomp.Logger
+
+	// BrokerId returns the identifier of this broker when it is
+	// federated with other brokers, or an empty string otherwise.
+	// The value is returned in the CONNECTED frame so that peer
+	// brokers can identify this broker.
+	BrokerId() string
+
+	// FederationPeer returns true if a client that logged in with
+	// login, and identified itself as a peer broker in its CONNECT
+	// frame, is trusted as a peer broker.
+	FederationPeer(login string) bool
+
+	// Compression returns true if the server accepts offers from
+	// clients to compress the connection.
+	Compression() bool
+
+	// Tracer returns the tracer for frames read from and written
+	// to client connections, or nil if frames are not traced.
+	Tracer() Tracer
+
+	// TransactionLimits returns the limits on the
+	// transactions of each client connection.
+	TransactionLimits() TransactionLimits
+
+	// SpanExporter returns the exporter for the spans recorded for
+	// messages that carry a trace context, or nil if spans are not
+	// recorded.
+	SpanExporter() SpanExporter
+
+	// NackLimit returns the number of times a message can be NACKed
+	// before it is discarded instead of requeued, or zero if there
+	// is no limit.
+	NackLimit() int
+
+	// Sessions returns the store of resumable sessions, or nil
+	// if clients cannot resume their sessions.
+	Sessions() *SessionStore
 }
+
+// BrokerIdHeader is the CONNECTED frame header entry that
+// identifies a federated broker.
+const BrokerIdHeader = "broker-id"
+
+// Header entries used by federation links between brokers. They are
+// removed from the frames of clients that are not trusted as peer brokers.
+const (
+	// FederationBrokerHeader identifies the broker on whose behalf a
+	// federation link connects to, and subscribes at, a peer broker.
+	FederationBrokerHeader = "federation-broker"
+
+	// FederationPathHeader lists the identifiers of the brokers that a
+	// message has been forwarded from, separated by commas.
+	FederationPathHeader = "federation-path"
+)
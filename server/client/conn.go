@@ -5,6 +5,8 @@ import (
 	"io"
 	"net"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-stomp/stomp/v3"
@@ -21,6 +23,12 @@ const maxPendingWrites = 16
 // go routine starts blocking.
 const maxPendingReads = 16
 
+// Maximum number of SEND frames from a client that can be waiting
+// for the upper layer to process them. Once this number is reached,
+// the client connection blocks until the upper layer catches up, so
+// that one client cannot fill the upper layer's request channel.
+const maxPendingEnqueues = 32
+
 // Represents a connection with the STOMP client.
 type Conn struct {
 	config         Config
@@ -39,9 +47,21 @@ type Conn struct {
 	subList        *SubscriptionList                   // List of subscriptions requiring acknowledgement
 	subs           map[string]*Subscription            // All subscriptions, keyed by id
 	validator      stomp.Validator                     // For validating STOMP frames
+	session        string                              // Session identifier
+	login          string                              // Login presented by the client
+	peer           string                              // Broker id of a trusted peer broker, empty for other clients
+	tracer         Tracer                              // Traces frames, nil if not tracing
+	spans          SpanExporter                        // Exports broker spans, nil if not recording spans
+	credits        chan struct{}                       // Enqueue requests not yet processed by the upper layer
+	resume         *session                            // Resumable session, nil if the session is not resumable
+	replay         map[string]bool                     // Resumed subscriptions that the client has not subscribed again
+	ended          bool                                // Client disconnected or was sent an ERROR frame
 	log            stomp.Logger
 }
 
+// Last session identifier allocated to a connection.
+var lastSessionId uint64
+
 // Creates a new client connection. The config parameter contains
 // process-wide configuration parameters relevant to a client connection.
 // The rw parameter is a network connection object for communicating with
@@ -55,9 +75,13 @@ func NewConn(config Config, rw net.Conn, ch chan Request) *Conn {
 		subChannel:     make(chan *Subscription, maxPendingWrites),
 		writeChannel:   make(chan *frame.Frame, maxPendingWrites),
 		readChannel:    make(chan *frame.Frame, maxPendingReads),
-		txStore:        &txStore{},
+		txStore:        &txStore{limits: config.TransactionLimits()},
 		subList:        NewSubscriptionList(),
 		subs:           make(map[string]*Subscription),
+		session:        "session-" + strconv.FormatUint(atomic.AddUint64(&lastSessionId, 1), 10),
+		tracer:         config.Tracer(),
+		spans:          config.SpanExporter(),
+		credits:        make(chan struct{}, maxPendingEnqueues),
 		log:            config.Logger(),
 	}
 	go c.readLoop()
@@ -65,6 +89,44 @@ func NewConn(config Config, rw net.Conn, ch chan Request) *Conn {
 	return c
 }
 
+// Session returns the session identifier of the connection,
+// which is sent to the client in the CONNECTED frame.
+func (c *Conn) Session() string {
+	return c.session
+}
+
+// Login returns the login presented by the client in the CONNECT frame,
+// whether or not the client was authenticated. Only safe to call from
+// the connection's processing go-routine, such as from a Tracer, or
+// from the upper layer after it has received the ConnectedOp request.
+func (c *Conn) Login() string {
+	return c.login
+}
+
+// Transactions returns the number of transactions in progress.
+// Safe to call from any go-routine.
+func (c *Conn) Transactions() int {
+	return c.txStore.Count()
+}
+
+// TransactionBytes returns the approximate size of the frames in the
+// transactions in progress. Safe to call from any go-routine.
+func (c *Conn) TransactionBytes() int64 {
+	return c.txStore.Bytes()
+}
+
+// RemoteAddr returns the network address of the client.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.rw.RemoteAddr()
+}
+
+// ReleaseCredit is called by the upper layer when it has taken an
+// EnqueueOp request from the connection for processing, which allows
+// the connection to send another.
+func (c *Conn) ReleaseCredit() {
+	<-c.credits
+}
+
 // Write a frame to the connection without requiring
 // any acknowledgement.
 func (c *Conn) Send(f *frame.Frame) {
@@ -87,6 +149,7 @@ func (c *Conn) SendError(err error) {
 // whose contents have caused the error. Include the receipt-id
 // header if the frame contains a receipt header.
 func (c *Conn) sendErrorImmediately(err error, f *frame.Frame) {
+	c.ended = true
 	errorFrame := frame.New(frame.ERROR,
 		frame.Message, err.Error())
 
@@ -106,7 +169,25 @@ func (c *Conn) sendErrorImmediately(err error, f *frame.Frame) {
 // Sends a STOMP frame to the client immediately, does not push onto the
 // write channel to be processed in turn.
 func (c *Conn) sendImmediately(f *frame.Frame) error {
-	return c.writer.Write(f)
+	return c.write(f)
+}
+
+// Writes a frame to the client, tracing it and recording
+// its delivery span if required.
+func (c *Conn) write(f *frame.Frame) error {
+	var span *Span
+	if c.spans != nil && f != nil && f.Command == frame.MESSAGE {
+		span = c.startDeliverSpan(f)
+	}
+	if c.tracer != nil && f != nil {
+		c.tracer.TraceFrame(c, f, true)
+	}
+	err := c.writer.Write(f)
+	if span != nil && err == nil {
+		span.End = time.Now()
+		c.spans.ExportSpan(*span)
+	}
+	return err
 }
 
 // Go routine for reading bytes from a client and assembling into
@@ -152,6 +233,12 @@ func (c *Conn) readLoop() {
 		// some extent, but letting this go-routine work out its own
 		// read timeout means no synchronization is necessary.
 		if expectingConnect {
+			// If the client has offered compression and the server
+			// accepts it, everything after this frame is compressed.
+			if c.acceptsCompression(f) {
+				reader.Deflate()
+			}
+
 			// Expecting a CONNECT or STOMP command, get the heart-beat
 			cx, _, err := getHeartBeat(f)
 
@@ -192,6 +279,15 @@ func (c *Conn) processLoop() {
 
 	var timerChannel <-chan time.Time
 	var timer *time.Timer
+
+	// check for transactions that have exceeded their lifetime
+	var txChannel <-chan time.Time
+	if lifetime := c.txStore.limits.MaxLifetime; lifetime > 0 {
+		ticker := time.NewTicker(txCheckInterval(lifetime))
+		defer ticker.Stop()
+		txChannel = ticker.C
+	}
+
 	for {
 		if c.writeTimeout > 0 && timer == nil {
 			timer = time.NewTimer(c.writeTimeout)
@@ -218,7 +314,7 @@ func (c *Conn) processLoop() {
 			c.allocateMessageId(f, nil)
 
 			// write the frame to the client
-			err := c.writer.Write(f)
+			err := c.write(f)
 			if err != nil {
 				// if there is an error writing to
 				// the client, there is not much
@@ -231,6 +327,7 @@ func (c *Conn) processLoop() {
 			// frame, we disconnect
 			if f.Command == frame.ERROR {
 				// sent an ERROR frame, so disconnect
+				c.ended = true
 				return
 			}
 
@@ -241,6 +338,13 @@ func (c *Conn) processLoop() {
 				return
 			}
 
+			if f.Command == frame.CONNECT || f.Command == frame.STOMP {
+				c.login = f.Header.Get(frame.Login)
+			}
+			if c.tracer != nil {
+				c.tracer.TraceFrame(c, f, false)
+			}
+
 			// Just received a frame from the client.
 			// Validate the frame, checking for mandatory
 			// headers and prohibited headers.
@@ -286,7 +390,7 @@ func (c *Conn) processLoop() {
 				c.allocateMessageId(sub.frame, sub)
 
 				// write the frame to the client
-				err := c.writer.Write(sub.frame)
+				err := c.write(sub.frame)
 				if err != nil {
 					// if there is an error writing to
 					// the client, there is not much
@@ -299,6 +403,7 @@ func (c *Conn) processLoop() {
 					// subscription does not require acknowledgement,
 					// so send the subscription back the upper layer
 					// straight away
+					c.notify(sub.frame, AckOutcomeAck)
 					sub.frame = nil
 					c.requestChannel <- Request{Op: SubscribeOp, Sub: sub}
 				} else {
@@ -307,7 +412,13 @@ func (c *Conn) processLoop() {
 				}
 			} else {
 				// Subscription no longer exists, requeue
-				c.requestChannel <- Request{Op: RequeueOp, Frame: sub.frame}
+				c.requestChannel <- Request{Op: RequeueOp, Frame: sub.frame, Conn: c}
+			}
+
+		case now := <-txChannel:
+			if err := c.txStore.Expired(now); err != nil {
+				c.sendErrorImmediately(err, nil)
+				return
 			}
 
 		case _ = <-timerChannel:
@@ -325,6 +436,19 @@ func (c *Conn) processLoop() {
 	}
 }
 
+// Returns the interval between checks for transactions
+// that have exceeded their maximum lifetime.
+func txCheckInterval(lifetime time.Duration) time.Duration {
+	interval := lifetime / 10
+	if interval < 10*time.Millisecond {
+		interval = 10 * time.Millisecond
+	}
+	if interval > time.Second {
+		interval = time.Second
+	}
+	return interval
+}
+
 // Called when the connection is closing, and takes care of
 // unsubscribing all subscriptions with the upper layer, and
 // re-queueing all unacknowledged messages to the upper layer.
@@ -338,11 +462,28 @@ func (c *Conn) cleanupConn() {
 	// This should be done before cleaning up the subscription
 	// channel. If we requeued messages before doing this,
 	// we might end up getting them back again.
+	detaching := c.resume != nil && !c.ended
 	for _, sub := range c.subs {
 		// Note that we only really need to send a request if the
 		// subscription does not have a frame, but for simplicity
 		// all subscriptions are unsubscribed from the upper layer.
-		c.requestChannel <- Request{Op: UnsubscribeOp, Sub: sub}
+		if detaching {
+			// the queue keeps counting the subscription as a
+			// consumer until the session is resumed or ends
+			c.requestChannel <- Request{Op: DetachOp, Sub: sub}
+		} else {
+			c.requestChannel <- Request{Op: UnsubscribeOp, Sub: sub}
+		}
+	}
+
+	if detaching {
+		// The connection was lost, so keep the subscriptions and
+		// their unacknowledged frames for the client to resume
+		// the session.
+		subs := c.detachSubscriptions()
+		c.config.Sessions().detach(c.resume, subs, c.lastMsgId, c.requestChannel)
+	} else if c.resume != nil {
+		c.config.Sessions().end(c.resume)
 	}
 
 	// Clear out the map of subscriptions
@@ -351,7 +492,7 @@ func (c *Conn) cleanupConn() {
 	// Every subscription requiring acknowledgement has a frame
 	// that needs to be requeued in the upper layer
 	for sub := c.subList.Get(); sub != nil; sub = c.subList.Get() {
-		c.requestChannel <- Request{Op: RequeueOp, Frame: sub.frame}
+		c.requestChannel <- Request{Op: RequeueOp, Frame: sub.frame, Conn: c}
 	}
 
 	// empty the subscription and write queue
@@ -395,7 +536,7 @@ func (c *Conn) cleanupSubChannel() {
 			if !ok {
 				finished = true
 			} else {
-				c.requestChannel <- Request{Op: RequeueOp, Frame: sub.frame}
+				c.requestChannel <- Request{Op: RequeueOp, Frame: sub.frame, Conn: c}
 			}
 
 		default:
@@ -419,6 +560,7 @@ func (c *Conn) allocateMessageId(f *frame.Frame, sub *Subscription) {
 			f.Header.Del(frame.Ack)
 		} else {
 			f.Header.Set(frame.Ack, messageId)
+			sub.msgId = c.lastMsgId
 		}
 	}
 }
@@ -434,6 +576,11 @@ func connecting(c *Conn, f *frame.Frame) error {
 
 // State function for after connect frame received.
 func connected(c *Conn, f *frame.Frame) error {
+	if c.replay != nil && f.Command != frame.SUBSCRIBE && f.Command != frame.UNSUBSCRIBE {
+		// the client has finished repeating the frames of its subscriptions
+		c.replay = nil
+	}
+
 	switch f.Command {
 	case frame.CONNECT, frame.STOMP:
 		return unexpectedCommand
@@ -482,6 +629,10 @@ func (c *Conn) handleConnect(f *frame.Frame) error {
 		return authenticationFailed
 	}
 
+	if broker, ok := f.Header.Contains(FederationBrokerHeader); ok && c.config.FederationPeer(login) {
+		c.peer = broker
+	}
+
 	c.version, err = determineVersion(f)
 	if err != nil {
 		c.log.Error("protocol version negotiation failed")
@@ -519,20 +670,79 @@ func (c *Conn) handleConnect(f *frame.Frame) error {
 	// go-routine
 	c.writeTimeout = time.Duration(cy) * time.Millisecond
 
+	var resumed *session
+	if sessions := c.config.Sessions(); sessions != nil {
+		token, resuming := f.Header.Contains(frame.ResumeToken)
+		if resuming {
+			resumed = sessions.resume(token, c)
+		}
+		if resumed != nil {
+			c.resume = resumed
+			c.restoreSession(resumed)
+		} else if resuming || f.Header.Get(frame.Resumable) == "true" {
+			// a session that cannot be resumed is replaced by a new one
+			if c.resume, err = sessions.open(c); err != nil {
+				return err
+			}
+		}
+	}
+
 	response := frame.New(frame.CONNECTED,
 		frame.Version, string(c.version),
-		frame.Server, "stompd/x.y.z", // TODO: get version
+		frame.Server, "stompd",
+		frame.Session, c.session,
 		frame.HeartBeat, fmt.Sprintf("%d,%d", cy, cx))
 
+	if brokerId := c.config.BrokerId(); brokerId != "" {
+		response.Header.Add(BrokerIdHeader, brokerId)
+	}
+
+	if c.resume != nil {
+		response.Header.Add(frame.ResumeToken, c.resume.token)
+		if resumed != nil {
+			response.Header.Add(frame.Resumed, "true")
+		}
+	}
+
+	compress := c.acceptsCompression(f)
+	if compress {
+		response.Header.Add(frame.Compression, frame.CompressionDeflate)
+	}
+
 	c.sendImmediately(response)
+	if compress {
+		if err = c.writer.Deflate(); err != nil {
+			return err
+		}
+	}
 	c.stateFunc = connected
 
 	// tell the upper layer we are connected
 	c.requestChannel <- Request{Op: ConnectedOp, Conn: c}
 
+	if resumed != nil {
+		return c.redeliver()
+	}
 	return nil
 }
 
+// Returns true if the CONNECT or STOMP frame f offers compression
+// that the server accepts.
+func (c *Conn) acceptsCompression(f *frame.Frame) bool {
+	if f.Command != frame.CONNECT && f.Command != frame.STOMP {
+		return false
+	}
+	if !c.config.Compression() {
+		return false
+	}
+	for _, method := range strings.Split(f.Header.Get(frame.Compression), ",") {
+		if strings.TrimSpace(method) == frame.CompressionDeflate {
+			return true
+		}
+	}
+	return false
+}
+
 // Sends a RECEIPT frame to the client if the frame f contains
 // a receipt header. If the frame does contain a receipt header,
 // it will be removed from the frame.
@@ -556,6 +766,7 @@ func (c *Conn) handleDisconnect(f *frame.Frame) error {
 	// of a RECEIPT frame if the client has requested one.
 	// Ignore the error condition if we cannot send a RECEIPT frame,
 	// as the connection is about to close anyway.
+	c.ended = true
 	_ = c.sendReceiptImmediately(f)
 	return nil
 }
@@ -624,12 +835,29 @@ func (c *Conn) handleSubscribe(f *frame.Frame) error {
 		ack = frame.AckAuto
 	}
 
+	// only a peer broker can subscribe on behalf of a broker,
+	// and only on behalf of the broker it connected as
+	if _, ok := f.Header.Contains(FederationBrokerHeader); ok {
+		if c.peer == "" {
+			f.Header.Del(FederationBrokerHeader)
+		} else {
+			f.Header.Set(FederationBrokerHeader, c.peer)
+		}
+	}
+
 	sub, ok := c.subs[id]
 	if ok {
+		if c.replay[id] && sameSubscription(sub.header, f.Header) {
+			// a client that resumes its session repeats
+			// the SUBSCRIBE frames of its subscriptions
+			delete(c.replay, id)
+			return nil
+		}
 		return subscriptionExists
 	}
 
 	sub = newSubscription(c, dest, id, ack)
+	sub.header = f.Header
 	c.subs[id] = sub
 
 	// send information about new subscription to upper layer
@@ -637,6 +865,30 @@ func (c *Conn) handleSubscribe(f *frame.Frame) error {
 	return nil
 }
 
+// Returns true if the header entries of two SUBSCRIBE frames,
+// other than the receipt header entry, are the same.
+func sameSubscription(h1, h2 *frame.Header) bool {
+	entries := func(h *frame.Header) []string {
+		var kv []string
+		for i := 0; i < h.Len(); i++ {
+			if key, value := h.GetAt(i); key != frame.Receipt {
+				kv = append(kv, key, value)
+			}
+		}
+		return kv
+	}
+	e1, e2 := entries(h1), entries(h2)
+	if len(e1) != len(e2) {
+		return false
+	}
+	for i := range e1 {
+		if e1[i] != e2[i] {
+			return false
+		}
+	}
+	return true
+}
+
 func (c *Conn) handleUnsubscribe(f *frame.Frame) error {
 	id, ok := f.Header.Contains(frame.Id)
 	if !ok {
@@ -645,9 +897,20 @@ func (c *Conn) handleUnsubscribe(f *frame.Frame) error {
 
 	sub, ok := c.subs[id]
 	if !ok {
+		if c.resume != nil {
+			// a client that resumes its session repeats UNSUBSCRIBE
+			// frames, which might have been handled already
+			return c.sendReceiptImmediately(f)
+		}
 		return subscriptionNotFound
 	}
 
+	// Send a receipt and remove the header
+	err := c.sendReceiptImmediately(f)
+	if err != nil {
+		return err
+	}
+
 	// remove the subscription
 	delete(c.subs, id)
 
@@ -660,7 +923,11 @@ func (c *Conn) handleAck(f *frame.Frame) error {
 	var err error
 	var msgId string
 
-	if ack, ok := f.Header.Contains(frame.Ack); ok {
+	if id, ok := f.Header.Contains(frame.Id); ok {
+		// STOMP 1.2 clients send the ack header value
+		// of the MESSAGE frame in the id header
+		msgId = id
+	} else if ack, ok := f.Header.Contains(frame.Ack); ok {
 		msgId = ack
 	} else if msgId, ok = f.Header.Contains(frame.MessageId); !ok {
 		return missingHeader(frame.MessageId)
@@ -687,6 +954,8 @@ func (c *Conn) handleAck(f *frame.Frame) error {
 	} else {
 		// handle any subscriptions that are acknowledged by this msg
 		c.subList.Ack(msgId64, func(s *Subscription) {
+			c.notify(s.frame, AckOutcomeAck)
+
 			// remove frame from the subscription, it has been delivered
 			s.frame = nil
 
@@ -703,7 +972,11 @@ func (c *Conn) handleNack(f *frame.Frame) error {
 	var err error
 	var msgId string
 
-	if ack, ok := f.Header.Contains(frame.Ack); ok {
+	if id, ok := f.Header.Contains(frame.Id); ok {
+		// STOMP 1.2 clients send the ack header value
+		// of the MESSAGE frame in the id header
+		msgId = id
+	} else if ack, ok := f.Header.Contains(frame.Ack); ok {
 		msgId = ack
 	} else if msgId, ok = f.Header.Contains(frame.MessageId); !ok {
 		return missingHeader(frame.MessageId)
@@ -730,10 +1003,15 @@ func (c *Conn) handleNack(f *frame.Frame) error {
 	} else {
 		// handle any subscriptions that are acknowledged by this msg
 		c.subList.Nack(msgId64, func(s *Subscription) {
-			// send frame back to upper layer for requeue
-			c.requestChannel <- Request{Op: RequeueOp, Frame: s.frame}
+			if c.nackExhausted(s.frame) {
+				// discard the frame, it has been NACKed too many times
+				c.notify(s.frame, AckOutcomeNack)
+			} else {
+				// send frame back to upper layer for requeue
+				c.requestChannel <- Request{Op: RequeueOp, Frame: s.frame, Conn: c}
+			}
 
-			// remove frame from the subscription, it has been requeued
+			// remove frame from the subscription, it has been requeued or discarded
 			s.frame = nil
 
 			// let the upper layer know that this subscription
@@ -748,12 +1026,28 @@ func (c *Conn) handleNack(f *frame.Frame) error {
 // this method is called after a SEND message is received,
 // but also after a transaction commit.
 func (c *Conn) handleSend(f *frame.Frame) error {
+	start := time.Now()
+
 	// Send a receipt and remove the header
 	err := c.sendReceiptImmediately(f)
 	if err != nil {
 		return err
 	}
 
+	// broker spans are only recorded by the broker,
+	// so the client cannot be trusted to provide one
+	f.Header.Del(brokerSpanHeader)
+
+	// the NACK count is only recorded by the broker,
+	// so that a message cannot arrive already exhausted
+	f.Header.Del(NackCountHeader)
+
+	// only a peer broker can forward a message
+	// that has passed through other brokers
+	if c.peer == "" {
+		f.Header.Del(FederationPathHeader)
+	}
+
 	if tx, ok := f.Header.Contains(frame.Transaction); ok {
 		// the transaction header is removed from the frame
 		err = c.txStore.Add(tx, f)
@@ -764,7 +1058,11 @@ func (c *Conn) handleSend(f *frame.Frame) error {
 		// not in a transaction
 		// change from SEND to MESSAGE
 		f.Command = frame.MESSAGE
-		c.requestChannel <- Request{Op: EnqueueOp, Frame: f}
+		if c.spans != nil {
+			c.recordEnqueueSpan(f, start)
+		}
+		c.credits <- struct{}{}
+		c.requestChannel <- Request{Op: EnqueueOp, Frame: f, Conn: c}
 	}
 
 	return nil
@@ -34,3 +34,7 @@ func missingHeader(name string) errorMessage {
 func prohibitedHeader(name string) errorMessage {
 	return errorMessage("prohibited header: " + name)
 }
+
+func txLimitExceeded(limit string) errorMessage {
+	return errorMessage("transaction limit exceeded: " + limit)
+}
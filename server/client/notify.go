@@ -0,0 +1,81 @@
+package client
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+)
+
+// NotifyOnAckHeader is the SEND header entry that asks the server to
+// publish a notification to the given destination when a message sent
+// to a queue is acknowledged by a consumer, is discarded after being
+// NACKed too many times, or expires. The notification is a MESSAGE frame
+// with an empty body and the Ack*Header entries, and the correlation-id
+// header entry of the original message, if it has one.
+const NotifyOnAckHeader = "notify-on-ack"
+
+// Header entries of a notification.
+const (
+	AckOutcomeHeader       = "ack-outcome"       // AckOutcomeAck, AckOutcomeNack or AckOutcomeExpired
+	AckedMessageIdHeader   = "acked-message-id"  // Message id of the message, as last delivered to a consumer
+	AckedDestinationHeader = "acked-destination" // Destination of the message
+	AckSessionHeader       = "ack-session"       // Session of the consumer, not present if the message expired
+	AckTimestampHeader     = "ack-timestamp"     // Time of the outcome, in milliseconds since the Unix epoch
+	CorrelationIdHeader    = "correlation-id"    // Copied from the message
+)
+
+// Outcomes reported by a notification.
+const (
+	AckOutcomeAck     = "ack"     // The message was acknowledged
+	AckOutcomeNack    = "nack"    // The message was NACKed too many times, and discarded
+	AckOutcomeExpired = "expired" // The message expired before it was acknowledged
+)
+
+// NackCountHeader is the header entry that contains the number
+// of times that a message has been NACKed by consumers.
+const NackCountHeader = "nack-count"
+
+// Notification returns the notification of an outcome for the message f,
+// or nil if the message did not ask for one. The session is the session
+// of the consumer, or empty if there is none.
+func Notification(f *frame.Frame, outcome string, session string) *frame.Frame {
+	destination, ok := f.Header.Contains(NotifyOnAckHeader)
+	if !ok || destination == "" {
+		return nil
+	}
+
+	n := frame.New(frame.MESSAGE,
+		frame.Destination, destination,
+		AckOutcomeHeader, outcome,
+		AckedDestinationHeader, f.Header.Get(frame.Destination),
+		AckTimestampHeader, strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10))
+	if id, ok := f.Header.Contains(frame.MessageId); ok {
+		n.Header.Set(AckedMessageIdHeader, id)
+	}
+	if session != "" {
+		n.Header.Set(AckSessionHeader, session)
+	}
+	if id, ok := f.Header.Contains(CorrelationIdHeader); ok {
+		n.Header.Set(CorrelationIdHeader, id)
+	}
+	return n
+}
+
+// Publishes the notification of an outcome for
+// the message f, if the message asked for one.
+func (c *Conn) notify(f *frame.Frame, outcome string) {
+	if n := Notification(f, outcome, c.session); n != nil {
+		c.requestChannel <- Request{Op: EnqueueOp, Frame: n}
+	}
+}
+
+// Records that the message f has been NACKed, and returns true if it
+// has been NACKed too many times and should be discarded.
+func (c *Conn) nackExhausted(f *frame.Frame) bool {
+	count, _ := strconv.Atoi(f.Header.Get(NackCountHeader))
+	count++
+	f.Header.Set(NackCountHeader, strconv.Itoa(count))
+	limit := c.config.NackLimit()
+	return limit > 0 && count >= limit
+}
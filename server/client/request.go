@@ -21,12 +21,15 @@ const (
 	RequeueOp                       // re-queue a message, not successfully sent
 	ConnectedOp                     // connection established
 	DisconnectedOp                  // connection disconnected
+	DetachOp                        // subscription kept for a detached session
+	ResumeOp                        // subscription of a resumed session
+	ExpireOp                        // subscription of a detached session that has ended
 )
 
 // Client requests received to be processed by main processing loop
 type Request struct {
 	Op    RequestOp     // opcode for request
-	Sub   *Subscription // SubscribeOp, UnsubscribeOp
-	Frame *frame.Frame  // EnqueueOp, RequeueOp
-	Conn  *Conn         // ConnectedOp, DisconnectedOp
+	Sub   *Subscription // SubscribeOp, UnsubscribeOp, DetachOp, ResumeOp, ExpireOp
+	Frame *frame.Frame  // EnqueueOp, RequeueOp, ResumeOp (unacknowledged frame, if any)
+	Conn  *Conn         // ConnectedOp, DisconnectedOp, EnqueueOp, RequeueOp (nil if not from a client)
 }
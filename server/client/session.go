@@ -0,0 +1,240 @@
+package client
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Longest time that a connection resuming a session waits for the
+// connection that has the session to close.
+const maxResumeWait = 5 * time.Second
+
+// A SessionStore keeps the sessions of the clients that ask for resumable
+// sessions, with the "resumable:true" CONNECT header entry. The server
+// returns a token in the "resume-token" header entry of the CONNECTED
+// frame. When the connection of such a client is lost, without the client
+// sending a DISCONNECT frame, its subscriptions and the messages that it
+// has not acknowledged are kept for a grace period. A client that connects
+// again within the grace period, presenting the token in the
+// "resume-token" CONNECT header entry, gets back its subscriptions, and
+// the unacknowledged messages are delivered again with the same message
+// ids. The CONNECTED frame then has the "resumed:true" header entry. After
+// the grace period, the unacknowledged messages are requeued.
+//
+// While the session is detached, its subscriptions are still counted as
+// consumers of their queues, so that auto-delete queues are kept. Queue
+// messages wait in their queues, messages sent to topics are not kept,
+// and transactions in progress are aborted.
+type SessionStore struct {
+	grace    time.Duration
+	mutex    sync.Mutex
+	sessions map[string]*session // by resume token
+}
+
+// A resumable session.
+type session struct {
+	token     string
+	login     string
+	id        string          // session identifier
+	conn      *Conn           // connection with the session, nil while detached
+	detached  chan struct{}   // closed when the session is detached from conn
+	subs      []*Subscription // subscriptions while detached, with their unacknowledged frames
+	lastMsgId uint64          // last message-id value while detached
+	timer     *time.Timer     // ends the session while detached
+}
+
+// NewSessionStore creates a store that keeps sessions
+// for the grace period after their connection is lost.
+func NewSessionStore(grace time.Duration) *SessionStore {
+	return &SessionStore{
+		grace:    grace,
+		sessions: make(map[string]*session),
+	}
+}
+
+// Detached returns the number of sessions that are waiting to be resumed.
+func (ss *SessionStore) Detached() int {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+	n := 0
+	for _, s := range ss.sessions {
+		if s.conn == nil {
+			n++
+		}
+	}
+	return n
+}
+
+// Creates a new resumable session for the connection.
+func (ss *SessionStore) open(c *Conn) (*session, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	s := &session{
+		token:    hex.EncodeToString(b),
+		login:    c.login,
+		id:       c.session,
+		conn:     c,
+		detached: make(chan struct{}),
+	}
+
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+	ss.sessions[s.token] = s
+	return s, nil
+}
+
+// Attaches the session with the token to the connection, if the
+// session exists and has the same login. If the session is attached
+// to another connection, that connection is closed, and the session
+// is attached once it has been detached. Returns nil if the session
+// cannot be resumed.
+func (ss *SessionStore) resume(token string, c *Conn) *session {
+	ss.mutex.Lock()
+	s, ok := ss.sessions[token]
+	if !ok || s.login != c.login {
+		ss.mutex.Unlock()
+		return nil
+	}
+	if prev := s.conn; prev != nil {
+		// the client has reconnected before the server noticed
+		// that the previous connection was lost; detach and resume
+		// replace the channel, so it is read with the mutex held
+		detached := s.detached
+		ss.mutex.Unlock()
+		prev.rw.Close()
+		select {
+		case <-detached:
+		case <-time.After(maxResumeWait):
+			return nil
+		}
+		ss.mutex.Lock()
+		if ss.sessions[token] != s || s.conn != nil {
+			ss.mutex.Unlock()
+			return nil
+		}
+	}
+	defer ss.mutex.Unlock()
+
+	s.timer.Stop()
+	s.timer = nil
+	s.conn = c
+	s.detached = make(chan struct{})
+	return s
+}
+
+// Detaches the session from its connection, keeping the subscriptions
+// and the last message-id value of the connection until the session is
+// resumed or the grace period ends. At the end of the grace period, the
+// unacknowledged frames of the subscriptions are requeued on ch.
+func (ss *SessionStore) detach(s *session, subs []*Subscription, lastMsgId uint64, ch chan Request) {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+	s.conn = nil
+	s.subs = subs
+	s.lastMsgId = lastMsgId
+	s.timer = time.AfterFunc(ss.grace, func() {
+		ss.mutex.Lock()
+		if ss.sessions[s.token] != s || s.conn != nil {
+			// resumed or ended in the meantime
+			ss.mutex.Unlock()
+			return
+		}
+		delete(ss.sessions, s.token)
+		subs := s.subs
+		s.subs = nil
+		ss.mutex.Unlock()
+
+		for _, sub := range subs {
+			if sub.frame != nil {
+				ch <- Request{Op: RequeueOp, Frame: sub.frame}
+			}
+		}
+		for _, sub := range subs {
+			ch <- Request{Op: ExpireOp, Sub: sub}
+		}
+	})
+	close(s.detached)
+}
+
+// Ends the session, which can no longer be resumed.
+func (ss *SessionStore) end(s *session) {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+	if ss.sessions[s.token] == s {
+		delete(ss.sessions, s.token)
+	}
+}
+
+// Restores the subscriptions of a resumed session on the connection.
+// Subscriptions without an unacknowledged frame are sent to the upper
+// layer, and the others wait for their frames to be acknowledged. The
+// upper layer is then told that the subscriptions kept for the detached
+// session have been resumed. The client can repeat the SUBSCRIBE frame
+// of each subscription until it sends a frame other than SUBSCRIBE or
+// UNSUBSCRIBE.
+func (c *Conn) restoreSession(s *session) {
+	c.session = s.id
+	c.lastMsgId = s.lastMsgId
+	c.replay = make(map[string]bool, len(s.subs))
+	for _, sub := range s.subs {
+		sub.conn = c
+		c.subs[sub.id] = sub
+		c.replay[sub.id] = true
+		if sub.frame == nil {
+			c.requestChannel <- Request{Op: SubscribeOp, Sub: sub}
+		} else {
+			c.subList.Add(sub)
+		}
+	}
+	for _, sub := range s.subs {
+		c.requestChannel <- Request{Op: ResumeOp, Sub: sub, Frame: sub.frame}
+	}
+	s.subs = nil
+}
+
+// Writes the unacknowledged frames of a resumed session to the
+// client again, as the client might not have received them.
+func (c *Conn) redeliver() error {
+	var err error
+	c.subList.ForEach(func(sub *Subscription, isLast bool) {
+		if err == nil {
+			err = c.write(sub.frame)
+		}
+	})
+	return err
+}
+
+// Returns copies of the subscriptions of the connection to keep while
+// its session is detached, with their unacknowledged frames in the order
+// in which they were delivered. Unacknowledged frames of subscriptions
+// that the client has unsubscribed are requeued.
+func (c *Conn) detachSubscriptions() []*Subscription {
+	unacked := make(map[string]*Subscription)
+	for sub := c.subList.Get(); sub != nil; sub = c.subList.Get() {
+		if c.subs[sub.id] == sub {
+			unacked[sub.id] = sub
+		} else {
+			c.requestChannel <- Request{Op: RequeueOp, Frame: sub.frame, Conn: c}
+		}
+	}
+
+	subs := make([]*Subscription, 0, len(c.subs))
+	for id, sub := range c.subs {
+		detached := newSubscription(nil, sub.dest, id, sub.ack)
+		detached.header = sub.header
+		if u, ok := unacked[id]; ok {
+			detached.frame = u.frame
+			detached.msgId = u.msgId
+		}
+		subs = append(subs, detached)
+	}
+	sort.Slice(subs, func(i, j int) bool {
+		return subs[i].msgId < subs[j].msgId
+	})
+	return subs
+}
@@ -0,0 +1,229 @@
+package client
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+)
+
+// TraceparentHeader is the header entry that carries the W3C trace
+// context of a message. When the server has a SpanExporter, it records
+// spans for the SEND frames that carry a sampled trace context, and
+// forwards an updated trace context on each MESSAGE frame, so that the
+// consumer's spans are children of the broker's delivery span.
+const TraceparentHeader = "traceparent"
+
+// Header entry that records the parent and the start time of the broker
+// span in progress for a message, as "<parent span id>;<unix nanoseconds>".
+// It is removed before the message is written to a client.
+const brokerSpanHeader = "x-broker-span"
+
+// Names of the spans recorded by the server.
+const (
+	SpanEnqueue = "enqueue" // Handling of a SEND frame, until it is passed to its destination
+	SpanQueue   = "queue"   // Time in the queue or topic, until the message is sent to a subscription
+	SpanDeliver = "deliver" // Delivery of a MESSAGE frame to the client of a subscription
+)
+
+// A Span records an operation performed by the server on a message
+// that carries a trace context.
+type Span struct {
+	Name         string    // SpanEnqueue, SpanQueue or SpanDeliver
+	TraceId      string    // 32 hex digits
+	SpanId       string    // 16 hex digits
+	ParentId     string    // 16 hex digits
+	Start        time.Time // Start of the operation
+	End          time.Time // End of the operation
+	Destination  string    // Destination of the message
+	Session      string    // Session of the producer (SpanEnqueue) or consumer (SpanDeliver)
+	Subscription string    // Subscription id of the consumer (SpanQueue and SpanDeliver)
+}
+
+// A SpanExporter receives the spans recorded by the server, for example
+// to send them to a tracing system. ExportSpan is called from many
+// go-routines, including the go-routine that processes requests for
+// all connections, so it must be safe for concurrent use and should
+// not block.
+type SpanExporter interface {
+	ExportSpan(span Span)
+}
+
+// W3C trace context, as carried by the traceparent header entry.
+type traceContext struct {
+	traceId string
+	spanId  string
+	flags   string
+}
+
+// Parses the value of a traceparent header entry. Returns false
+// if the value is not valid.
+func parseTraceparent(value string) (traceContext, bool) {
+	// version-traceid-parentid-flags, later versions can append fields
+	if len(value) < 55 || (len(value) > 55 && value[55] != '-') {
+		return traceContext{}, false
+	}
+	fields := strings.Split(value[:55], "-")
+	if len(fields) != 4 || fields[0] == "ff" || (fields[0] == "00" && len(value) != 55) {
+		return traceContext{}, false
+	}
+	tc := traceContext{traceId: fields[1], spanId: fields[2], flags: fields[3]}
+	if !isHex(fields[0], 2) || !isHex(tc.traceId, 32) || !isHex(tc.spanId, 16) || !isHex(tc.flags, 2) {
+		return traceContext{}, false
+	}
+	if isZero(tc.traceId) || isZero(tc.spanId) {
+		return traceContext{}, false
+	}
+	return tc, true
+}
+
+// Returns true if the trace context has the sampled flag set.
+func (tc traceContext) sampled() bool {
+	b, _ := hex.DecodeString(tc.flags)
+	return len(b) == 1 && b[0]&1 == 1
+}
+
+// Returns the value of a traceparent header entry for
+// the span with the given id in the same trace.
+func (tc traceContext) traceparent(spanId string) string {
+	return "00-" + tc.traceId + "-" + spanId + "-" + tc.flags
+}
+
+func isHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	for _, ch := range s {
+		if !(ch >= '0' && ch <= '9' || ch >= 'a' && ch <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func isZero(s string) bool {
+	return strings.Trim(s, "0") == ""
+}
+
+// Returns a random span id.
+func newSpanId() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// Sets the header entry that records the parent and start time of
+// the next broker span for a message.
+func setBrokerSpan(f *frame.Frame, parentId string, start time.Time) {
+	f.Header.Set(brokerSpanHeader, parentId+";"+strconv.FormatInt(start.UnixNano(), 10))
+}
+
+// Returns the parent and start time of the next broker span for
+// a message, and removes the header entry that records them.
+func takeBrokerSpan(f *frame.Frame) (parentId string, start time.Time, ok bool) {
+	value, ok := f.Header.Contains(brokerSpanHeader)
+	if !ok {
+		return "", time.Time{}, false
+	}
+	f.Header.Del(brokerSpanHeader)
+	i := strings.IndexByte(value, ';')
+	if i < 0 {
+		return "", time.Time{}, false
+	}
+	nanos, err := strconv.ParseInt(value[i+1:], 10, 64)
+	if err != nil || !isHex(value[:i], 16) {
+		return "", time.Time{}, false
+	}
+	return value[:i], time.Unix(0, nanos), true
+}
+
+// Returns the sampled trace context of a message, if
+// spans are recorded for the message.
+func tracedMessage(spans SpanExporter, f *frame.Frame) (traceContext, bool) {
+	if spans == nil {
+		return traceContext{}, false
+	}
+	tc, ok := parseTraceparent(f.Header.Get(TraceparentHeader))
+	if !ok || !tc.sampled() {
+		return traceContext{}, false
+	}
+	return tc, true
+}
+
+// Records the enqueue span for a SEND frame that is about to be passed
+// to its destination, and starts the span for its time in the queue.
+func (c *Conn) recordEnqueueSpan(f *frame.Frame, start time.Time) {
+	tc, ok := tracedMessage(c.spans, f)
+	if !ok {
+		return
+	}
+	span := Span{
+		Name:        SpanEnqueue,
+		TraceId:     tc.traceId,
+		SpanId:      newSpanId(),
+		ParentId:    tc.spanId,
+		Start:       start,
+		End:         time.Now(),
+		Destination: f.Header.Get(frame.Destination),
+		Session:     c.session,
+	}
+	f.Header.Set(TraceparentHeader, tc.traceparent(span.SpanId))
+	setBrokerSpan(f, span.SpanId, span.End)
+	c.spans.ExportSpan(span)
+}
+
+// Records the span for the time a message spent in its queue or topic,
+// when it is sent to a subscription, and starts the delivery span.
+func (s *Subscription) recordQueueSpan(f *frame.Frame) {
+	tc, ok := tracedMessage(s.conn.spans, f)
+	if !ok {
+		return
+	}
+	parentId, start, ok := takeBrokerSpan(f)
+	if !ok {
+		// requeued after delivery, so the delivery
+		// span has no queue span as its parent
+		setBrokerSpan(f, tc.spanId, time.Now())
+		return
+	}
+	span := Span{
+		Name:         SpanQueue,
+		TraceId:      tc.traceId,
+		SpanId:       newSpanId(),
+		ParentId:     parentId,
+		Start:        start,
+		End:          time.Now(),
+		Destination:  s.dest,
+		Subscription: s.id,
+	}
+	setBrokerSpan(f, span.SpanId, span.End)
+	s.conn.spans.ExportSpan(span)
+}
+
+// Starts the delivery span for a MESSAGE frame that is about to be
+// written to the client. Returns nil if no span is recorded.
+func (c *Conn) startDeliverSpan(f *frame.Frame) *Span {
+	tc, ok := tracedMessage(c.spans, f)
+	if !ok {
+		return nil
+	}
+	parentId, start, ok := takeBrokerSpan(f)
+	if !ok {
+		return nil
+	}
+	span := &Span{
+		Name:         SpanDeliver,
+		TraceId:      tc.traceId,
+		SpanId:       newSpanId(),
+		ParentId:     parentId,
+		Start:        start,
+		Destination:  f.Header.Get(frame.Destination),
+		Session:      c.session,
+		Subscription: f.Header.Get(frame.Subscription),
+	}
+	f.Header.Set(TraceparentHeader, tc.traceparent(span.SpanId))
+	return span
+}
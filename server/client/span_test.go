@@ -0,0 +1,62 @@
+package client
+
+import (
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	. "gopkg.in/check.v1"
+)
+
+type SpanSuite struct{}
+
+var _ = Suite(&SpanSuite{})
+
+func (s *SpanSuite) TestParseTraceparent(c *C) {
+	tc, ok := parseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	c.Assert(ok, Equals, true)
+	c.Check(tc, Equals, traceContext{
+		traceId: "4bf92f3577b34da6a3ce929d0e0e4736",
+		spanId:  "00f067aa0ba902b7",
+		flags:   "01",
+	})
+	c.Check(tc.sampled(), Equals, true)
+	c.Check(tc.traceparent("b7ad6b7169203331"), Equals,
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-b7ad6b7169203331-01")
+
+	tc, ok = parseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	c.Assert(ok, Equals, true)
+	c.Check(tc.sampled(), Equals, false)
+
+	// later versions can have more fields
+	_, ok = parseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra")
+	c.Check(ok, Equals, true)
+
+	for _, value := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736_00f067aa0ba902b7-01",
+	} {
+		_, ok = parseTraceparent(value)
+		c.Check(ok, Equals, false, Commentf("%q", value))
+	}
+}
+
+func (s *SpanSuite) TestBrokerSpanHeader(c *C) {
+	f := frame.New(frame.MESSAGE)
+	start := time.Unix(1700000000, 123)
+	setBrokerSpan(f, "00f067aa0ba902b7", start)
+
+	parentId, t, ok := takeBrokerSpan(f)
+	c.Check(ok, Equals, true)
+	c.Check(parentId, Equals, "00f067aa0ba902b7")
+	c.Check(t.Equal(start), Equals, true)
+	_, ok = f.Header.Contains(brokerSpanHeader)
+	c.Check(ok, Equals, false)
+
+	_, _, ok = takeBrokerSpan(f)
+	c.Check(ok, Equals, false)
+}
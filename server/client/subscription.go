@@ -12,6 +12,7 @@ type Subscription struct {
 	msgId   uint64            // message-id (or ack) for acknowledgement
 	subList *SubscriptionList // am I in a list
 	frame   *frame.Frame      // message allocated to subscription
+	header  *frame.Header     // header entries of the SUBSCRIBE frame
 }
 
 func newSubscription(c *Conn, dest string, id string, ack string) *Subscription {
@@ -23,6 +24,11 @@ func newSubscription(c *Conn, dest string, id string, ack string) *Subscription
 	}
 }
 
+// Conn returns the client connection of the subscription.
+func (s *Subscription) Conn() *Conn {
+	return s.conn
+}
+
 func (s *Subscription) Destination() string {
 	return s.dest
 }
@@ -35,6 +41,15 @@ func (s *Subscription) Id() string {
 	return s.id
 }
 
+// Header returns the header entries of the SUBSCRIBE frame
+// that created the subscription.
+func (s *Subscription) Header() *frame.Header {
+	if s.header == nil {
+		return frame.NewHeader()
+	}
+	return s.header
+}
+
 func (s *Subscription) IsAckedBy(msgId uint64) bool {
 	switch s.ack {
 	case frame.AckAuto:
@@ -58,6 +73,9 @@ func (s *Subscription) IsNackedBy(msgId uint64) bool {
 
 func (s *Subscription) SendQueueFrame(f *frame.Frame) {
 	s.setSubscriptionHeader(f)
+	if s.conn.spans != nil {
+		s.recordQueueSpan(f)
+	}
 	s.frame = f
 
 	// let the connection deal with the subscription
@@ -70,6 +88,9 @@ func (s *Subscription) SendQueueFrame(f *frame.Frame) {
 // frame is available.
 func (s *Subscription) SendTopicFrame(f *frame.Frame) {
 	s.setSubscriptionHeader(f)
+	if s.conn.spans != nil {
+		s.recordQueueSpan(f)
+	}
 
 	// topics are handled differently, they just go
 	// straight to the client without acknowledgement
@@ -27,6 +27,11 @@ func (sl *SubscriptionList) Add(sub *Subscription) {
 	sub.subList = sl
 }
 
+// Returns the number of subscriptions in the list.
+func (sl *SubscriptionList) Len() int {
+	return sl.subs.Len()
+}
+
 // Gets the first subscription in the list, or nil if there
 // are no subscriptions available. The subscription is removed
 // from the list.
@@ -59,6 +64,7 @@ func (sl *SubscriptionList) FindByIdAndRemove(id string) *Subscription {
 		sub := e.Value.(*Subscription)
 		if sub.id == id {
 			sl.subs.Remove(e)
+			sub.subList = nil
 			return sub
 		}
 	}
@@ -67,13 +73,15 @@ func (sl *SubscriptionList) FindByIdAndRemove(id string) *Subscription {
 
 // Finds all subscriptions in the subscription list that are acked by the
 // specified message-id (or ack) header. The subscription is removed from
-// the list and the callback function called for that subscription.
+// the list, so that it can be added again once it is sent another frame,
+// and the callback function called for that subscription.
 func (sl *SubscriptionList) Ack(msgId uint64, callback func(s *Subscription)) {
 	for e := sl.subs.Front(); e != nil; {
 		next := e.Next()
 		sub := e.Value.(*Subscription)
 		if sub.IsAckedBy(msgId) {
 			sl.subs.Remove(e)
+			sub.subList = nil
 			callback(sub)
 		}
 		e = next
@@ -82,7 +90,8 @@ func (sl *SubscriptionList) Ack(msgId uint64, callback func(s *Subscription)) {
 
 // Finds all subscriptions in the subscription list that are *nacked* by the
 // specified message-id (or ack) header. The subscription is removed from
-// the list and the callback function called for that subscription. Current
+// the list, so that it can be added again once it is sent another frame,
+// and the callback function called for that subscription. Current
 // understanding that all NACKs are individual, but not sure
 func (sl *SubscriptionList) Nack(msgId uint64, callback func(s *Subscription)) {
 	for e := sl.subs.Front(); e != nil; {
@@ -90,6 +99,7 @@ func (sl *SubscriptionList) Nack(msgId uint64, callback func(s *Subscription)) {
 		sub := e.Value.(*Subscription)
 		if sub.IsNackedBy(msgId) {
 			sl.subs.Remove(e)
+			sub.subList = nil
 			callback(sub)
 		}
 		e = next
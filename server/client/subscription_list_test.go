@@ -75,6 +75,8 @@ func (s *SubscriptionListSuite) TestAck(c *C) {
 	c.Assert(len(subs), Equals, 2)
 	c.Assert(subs[0], Equals, sub1)
 	c.Assert(subs[1], Equals, sub3)
+	c.Check(sub1.subList, IsNil)
+	c.Check(sub3.subList, IsNil)
 
 	c.Assert(sl.Get(), Equals, sub2)
 	c.Assert(sl.Get(), Equals, sub4)
@@ -105,6 +107,7 @@ func (s *SubscriptionListSuite) TestNack(c *C) {
 
 	c.Assert(len(subs), Equals, 1)
 	c.Assert(subs[0], Equals, sub3)
+	c.Check(sub3.subList, IsNil)
 
 	c.Assert(sl.Get(), Equals, sub1)
 	c.Assert(sl.Get(), Equals, sub2)
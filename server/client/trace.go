@@ -0,0 +1,15 @@
+package client
+
+import (
+	"github.com/go-stomp/stomp/v3/frame"
+)
+
+// A Tracer receives the frames read from and written to client
+// connections, for example to log them while debugging a client.
+type Tracer interface {
+	// TraceFrame is called on the processing go-routine of the connection
+	// for every frame read from the client (outgoing is false) or written
+	// to the client (outgoing is true). Heart-beats are not traced. The
+	// frame must not be modified or retained.
+	TraceFrame(c *Conn, f *frame.Frame, outgoing bool)
+}
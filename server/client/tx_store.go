@@ -2,36 +2,64 @@ package client
 
 import (
 	"container/list"
+	"sync/atomic"
+	"time"
 
 	"github.com/go-stomp/stomp/v3/frame"
 )
 
+// TransactionLimits contains the limits on the transactions of a client
+// connection. A value of zero means no limit. When a limit is exceeded,
+// the transaction is aborted and the client is sent an ERROR frame that
+// names the limit.
+type TransactionLimits struct {
+	MaxTransactions int           // Transactions in progress per connection
+	MaxFrames       int           // Frames per transaction
+	MaxBytes        int           // Approximate size of the frames per transaction, in bytes
+	MaxLifetime     time.Duration // Time from BEGIN to COMMIT or ABORT
+}
+
 type txStore struct {
-	transactions map[string]*list.List
+	transactions map[string]*transaction
+	limits       TransactionLimits
+	count        int32 // number of transactions, accessed atomically
+	bytes        int64 // size of all transactions, accessed atomically
+}
+
+type transaction struct {
+	frames *list.List
+	bytes  int       // size of the frames
+	began  time.Time // time of BEGIN
 }
 
 // Initializes a new store or clears out an existing store
 func (txs *txStore) Init() {
 	txs.transactions = nil
+	atomic.StoreInt32(&txs.count, 0)
+	atomic.StoreInt64(&txs.bytes, 0)
 }
 
 func (txs *txStore) Begin(tx string) error {
 	if txs.transactions == nil {
-		txs.transactions = make(map[string]*list.List)
+		txs.transactions = make(map[string]*transaction)
 	}
 
 	if _, ok := txs.transactions[tx]; ok {
 		return txAlreadyInProgress
 	}
 
-	txs.transactions[tx] = list.New()
+	if max := txs.limits.MaxTransactions; max > 0 && len(txs.transactions) >= max {
+		return txLimitExceeded("max-transactions")
+	}
+
+	txs.transactions[tx] = &transaction{frames: list.New(), began: time.Now()}
+	atomic.AddInt32(&txs.count, 1)
 	return nil
 }
 
 func (txs *txStore) Abort(tx string) error {
-	if list, ok := txs.transactions[tx]; ok {
-		list.Init()
-		delete(txs.transactions, tx)
+	if t, ok := txs.transactions[tx]; ok {
+		txs.remove(tx, t)
 		return nil
 	}
 	return txUnknown
@@ -42,24 +70,81 @@ func (txs *txStore) Abort(tx string) error {
 // function (commitFunc) in order for each request that is part of the
 // transaction.
 func (txs *txStore) Commit(tx string, commitFunc func(f *frame.Frame) error) error {
-	if list, ok := txs.transactions[tx]; ok {
-		for element := list.Front(); element != nil; element = list.Front() {
-			err := commitFunc(list.Remove(element).(*frame.Frame))
+	if t, ok := txs.transactions[tx]; ok {
+		if txs.expired(t, time.Now()) {
+			txs.remove(tx, t)
+			return txLimitExceeded("max-lifetime")
+		}
+		for element := t.frames.Front(); element != nil; element = t.frames.Front() {
+			err := commitFunc(t.frames.Remove(element).(*frame.Frame))
 			if err != nil {
 				return err
 			}
 		}
-		delete(txs.transactions, tx)
+		txs.remove(tx, t)
 		return nil
 	}
 	return txUnknown
 }
 
 func (txs *txStore) Add(tx string, f *frame.Frame) error {
-	if list, ok := txs.transactions[tx]; ok {
+	if t, ok := txs.transactions[tx]; ok {
 		f.Header.Del(frame.Transaction)
-		list.PushBack(f)
+		size := f.Size()
+
+		var limit string
+		switch {
+		case txs.limits.MaxFrames > 0 && t.frames.Len() >= txs.limits.MaxFrames:
+			limit = "max-frames"
+		case txs.limits.MaxBytes > 0 && t.bytes+size > txs.limits.MaxBytes:
+			limit = "max-bytes"
+		case txs.expired(t, time.Now()):
+			limit = "max-lifetime"
+		}
+		if limit != "" {
+			txs.remove(tx, t)
+			return txLimitExceeded(limit)
+		}
+
+		t.frames.PushBack(f)
+		t.bytes += size
+		atomic.AddInt64(&txs.bytes, int64(size))
 		return nil
 	}
 	return txUnknown
 }
+
+// Expired aborts the first transaction that has exceeded its maximum
+// lifetime, and returns the limit error. Returns nil if there is none.
+func (txs *txStore) Expired(now time.Time) error {
+	for tx, t := range txs.transactions {
+		if txs.expired(t, now) {
+			txs.remove(tx, t)
+			return txLimitExceeded("max-lifetime")
+		}
+	}
+	return nil
+}
+
+// Count returns the number of transactions in progress.
+// Safe to call from any go-routine.
+func (txs *txStore) Count() int {
+	return int(atomic.LoadInt32(&txs.count))
+}
+
+// Bytes returns the approximate size of the frames in all transactions
+// in progress. Safe to call from any go-routine.
+func (txs *txStore) Bytes() int64 {
+	return atomic.LoadInt64(&txs.bytes)
+}
+
+func (txs *txStore) expired(t *transaction, now time.Time) bool {
+	return txs.limits.MaxLifetime > 0 && now.Sub(t.began) > txs.limits.MaxLifetime
+}
+
+func (txs *txStore) remove(tx string, t *transaction) {
+	t.frames.Init()
+	delete(txs.transactions, tx)
+	atomic.AddInt32(&txs.count, -1)
+	atomic.AddInt64(&txs.bytes, -int64(t.bytes))
+}
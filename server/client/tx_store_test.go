@@ -1,6 +1,8 @@
 package client
 
 import (
+	"time"
+
 	"github.com/go-stomp/stomp/v3/frame"
 	. "gopkg.in/check.v1"
 )
@@ -79,3 +81,40 @@ func (s *TxStoreSuite) TestSuccessfulTx(c *C) {
 	})
 	c.Check(err, Equals, txUnknown)
 }
+
+func (s *TxStoreSuite) TestLimits(c *C) {
+	txs := txStore{limits: TransactionLimits{
+		MaxTransactions: 2,
+		MaxFrames:       2,
+		MaxBytes:        100,
+		MaxLifetime:     time.Hour,
+	}}
+
+	c.Assert(txs.Begin("tx1"), IsNil)
+	c.Assert(txs.Begin("tx2"), IsNil)
+	c.Check(txs.Begin("tx3"), Equals, txLimitExceeded("max-transactions"))
+	c.Check(txs.Count(), Equals, 2)
+
+	f := frame.New(frame.MESSAGE, frame.Destination, "/queue/1")
+	size := f.Size()
+	c.Assert(txs.Add("tx1", f), IsNil)
+	c.Assert(txs.Add("tx1", frame.New(frame.MESSAGE, frame.Destination, "/queue/1")), IsNil)
+	c.Check(txs.Bytes(), Equals, int64(2*size))
+
+	// the transaction is aborted when a limit is exceeded
+	err := txs.Add("tx1", frame.New(frame.MESSAGE, frame.Destination, "/queue/1"))
+	c.Check(err, Equals, txLimitExceeded("max-frames"))
+	c.Check(txs.Abort("tx1"), Equals, txUnknown)
+	c.Check(txs.Bytes(), Equals, int64(0))
+	c.Check(txs.Count(), Equals, 1)
+
+	f = frame.New(frame.MESSAGE, frame.Destination, "/queue/2")
+	f.Body = make([]byte, 100)
+	c.Check(txs.Add("tx2", f), Equals, txLimitExceeded("max-bytes"))
+	c.Check(txs.Count(), Equals, 0)
+
+	c.Assert(txs.Begin("tx4"), IsNil)
+	c.Check(txs.Expired(time.Now()), IsNil)
+	c.Check(txs.Expired(time.Now().Add(2*time.Hour)), Equals, txLimitExceeded("max-lifetime"))
+	c.Check(txs.Commit("tx4", nil), Equals, txUnknown)
+}
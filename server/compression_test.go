@@ -0,0 +1,79 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"sync/atomic"
+
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/frame"
+	. "gopkg.in/check.v1"
+)
+
+type CompressionSuite struct{}
+
+var _ = Suite(&CompressionSuite{})
+
+// countingConn counts the bytes written to a network connection.
+type countingConn struct {
+	net.Conn
+	written int64
+}
+
+func (cc *countingConn) Write(p []byte) (int, error) {
+	n, err := cc.Conn.Write(p)
+	atomic.AddInt64(&cc.written, int64(n))
+	return n, err
+}
+
+// exchange connects a client to the server listening on l, then sends
+// and receives count messages, and returns the number of bytes written
+// by the client and its negotiated compression.
+func exchange(c *C, l net.Listener, count int, opts ...func(*stomp.Conn) error) (int64, string) {
+	nc, err := net.Dial("tcp", l.Addr().String())
+	c.Assert(err, IsNil)
+	cc := &countingConn{Conn: nc}
+	conn, err := stomp.Connect(cc, opts...)
+	c.Assert(err, IsNil)
+	defer conn.Disconnect()
+
+	sub, err := conn.Subscribe("/queue/compressed", stomp.AckAuto)
+	c.Assert(err, IsNil)
+
+	for i := 0; i < count; i++ {
+		body := fmt.Sprintf(`{"id":%d,"status":"pending","region":"eu-west"}`, i)
+		err = conn.Send("/queue/compressed", "application/json", []byte(body))
+		c.Assert(err, IsNil)
+	}
+	for i := 0; i < count; i++ {
+		msg := <-sub.C
+		c.Assert(msg.Err, IsNil)
+		c.Check(string(msg.Body), Equals,
+			fmt.Sprintf(`{"id":%d,"status":"pending","region":"eu-west"}`, i))
+	}
+
+	return atomic.LoadInt64(&cc.written), conn.Capabilities().Compression
+}
+
+func (s *CompressionSuite) TestNegotiated(c *C) {
+	l := listenLocal(c)
+	defer l.Close()
+	go (&Server{Compression: true}).Serve(l)
+
+	plain, compression := exchange(c, l, 100)
+	c.Check(compression, Equals, "")
+
+	compressed, compression := exchange(c, l, 100, stomp.ConnOpt.Compression)
+	c.Check(compression, Equals, frame.CompressionDeflate)
+	c.Check(compressed < plain/2, Equals, true,
+		Commentf("compressed %d bytes, plain %d bytes", compressed, plain))
+}
+
+func (s *CompressionSuite) TestServerDeclines(c *C) {
+	l := listenLocal(c)
+	defer l.Close()
+	go (&Server{}).Serve(l)
+
+	_, compression := exchange(c, l, 10, stomp.ConnOpt.Compression)
+	c.Check(compression, Equals, "")
+}
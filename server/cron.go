@@ -0,0 +1,192 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A cron expression, with five fields: minute, hour, day of month,
+// month and day of week. Each field is a set of allowed values.
+type cronExpr struct {
+	minute, hour, dom, month, dow uint64
+	domStar, dowStar              bool // day fields are unrestricted
+}
+
+// Range and names of the values of a cron field.
+type cronField struct {
+	name     string
+	min, max int
+	names    []string // names of the values, starting at min
+}
+
+var cronFields = []cronField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12, names: []string{
+		"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}},
+	{name: "day of week", min: 0, max: 7, names: []string{
+		"sun", "mon", "tue", "wed", "thu", "fri", "sat"}},
+}
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Parses a cron expression. Each of the five fields is "*" or a
+// comma-separated list of values or ranges ("1-5"), optionally with
+// a step ("*/15", "0-30/10"). Months and days of the week can be
+// given by name ("jan", "mon"). Sunday is day 0 or 7. The descriptors
+// @yearly, @monthly, @weekly, @daily and @hourly are also accepted.
+func parseCron(expr string) (*cronExpr, error) {
+	if descriptor, ok := cronDescriptors[strings.ToLower(strings.TrimSpace(expr))]; ok {
+		expr = descriptor
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("cron expression %q must have %d fields", expr, len(cronFields))
+	}
+
+	var sets [5]uint64
+	for i, field := range fields {
+		set, err := cronFields[i].parse(field)
+		if err != nil {
+			return nil, fmt.Errorf("cron expression %q: %v", expr, err)
+		}
+		sets[i] = set
+	}
+
+	c := &cronExpr{
+		minute:  sets[0],
+		hour:    sets[1],
+		dom:     sets[2],
+		month:   sets[3],
+		dow:     sets[4],
+		domStar: strings.HasPrefix(fields[2], "*"),
+		dowStar: strings.HasPrefix(fields[4], "*"),
+	}
+	if c.dow&(1<<7) != 0 {
+		// day 7 is Sunday
+		c.dow |= 1
+	}
+	return c, nil
+}
+
+// Parses a field of a cron expression into a set of values.
+func (cf cronField) parse(field string) (uint64, error) {
+	var set uint64
+	for _, item := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.IndexByte(item, '/'); i >= 0 {
+			n, err := strconv.Atoi(item[i+1:])
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("invalid step in %s field: %q", cf.name, item)
+			}
+			step = n
+			item = item[:i]
+		}
+
+		var low, high int
+		if item == "*" {
+			low, high = cf.min, cf.max
+		} else {
+			bounds := strings.SplitN(item, "-", 2)
+			var err error
+			if low, err = cf.value(bounds[0]); err != nil {
+				return 0, err
+			}
+			high = low
+			if len(bounds) == 2 {
+				if high, err = cf.value(bounds[1]); err != nil {
+					return 0, err
+				}
+			} else if step > 1 {
+				// "n/step" means from n to the maximum
+				high = cf.max
+			}
+			if high < low {
+				return 0, fmt.Errorf("invalid range in %s field: %q", cf.name, item)
+			}
+		}
+
+		for v := low; v <= high; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+// Parses a value of a cron field, which is a number or a name.
+func (cf cronField) value(s string) (int, error) {
+	for i, name := range cf.names {
+		if strings.EqualFold(s, name) {
+			return cf.min + i, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < cf.min || v > cf.max {
+		return 0, fmt.Errorf("invalid value in %s field: %q", cf.name, s)
+	}
+	return v, nil
+}
+
+// Returns the first time after t that matches the cron expression,
+// in the location of t. Returns the zero time if there is no such
+// time within five years, for example for "0 0 30 2 *".
+func (c *cronExpr) next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !c.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			next := time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			if !next.After(t) {
+				// the hour is repeated at the end of daylight saving time
+				next = t.Truncate(time.Hour).Add(time.Hour)
+			}
+			t = next
+			continue
+		}
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// Returns true if the day of t matches the day of month and day
+// of week fields. As in cron, if both fields are restricted, a day
+// matches if it matches either field.
+func (c *cronExpr) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	switch {
+	case c.domStar && c.dowStar:
+		return true
+	case c.domStar:
+		return dow
+	case c.dowStar:
+		return dom
+	default:
+		return dom || dow
+	}
+}
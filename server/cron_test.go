@@ -0,0 +1,72 @@
+package server
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type CronSuite struct{}
+
+var _ = Suite(&CronSuite{})
+
+func (s *CronSuite) TestNext(c *C) {
+	start := time.Date(2024, time.January, 31, 10, 30, 15, 0, time.UTC) // a Wednesday
+	for _, test := range []struct {
+		expr string
+		next time.Time
+	}{
+		{"* * * * *", time.Date(2024, 1, 31, 10, 31, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, 1, 31, 10, 45, 0, 0, time.UTC)},
+		{"0 2 * * *", time.Date(2024, 2, 1, 2, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2024, 1, 31, 11, 0, 0, 0, time.UTC)},
+		{"0 9 * * mon-fri", time.Date(2024, 2, 1, 9, 0, 0, 0, time.UTC)},
+		{"0 9 * * sun", time.Date(2024, 2, 4, 9, 0, 0, 0, time.UTC)},
+		{"0 9 * * 7", time.Date(2024, 2, 4, 9, 0, 0, 0, time.UTC)},
+		{"0 0 29 feb *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 31 * *", time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC)},
+		{"30 10,22 * * *", time.Date(2024, 1, 31, 22, 30, 0, 0, time.UTC)},
+		{"5-10/5 * * * *", time.Date(2024, 1, 31, 11, 5, 0, 0, time.UTC)},
+		// either day field matches when both are restricted
+		{"0 0 15 * fri", time.Date(2024, 2, 2, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	} {
+		cron, err := parseCron(test.expr)
+		c.Assert(err, IsNil, Commentf("%s", test.expr))
+		c.Check(cron.next(start), Equals, test.next, Commentf("%s", test.expr))
+	}
+}
+
+func (s *CronSuite) TestDaylightSaving(c *C) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		c.Skip("time zone database not available")
+	}
+	cron, err := parseCron("30 2 * * *")
+	c.Assert(err, IsNil)
+
+	// 02:30 does not exist on the day that daylight saving time starts
+	start := time.Date(2024, time.March, 9, 12, 0, 0, 0, loc)
+	c.Check(cron.next(start), Equals, time.Date(2024, time.March, 11, 2, 30, 0, 0, loc))
+}
+
+func (s *CronSuite) TestInvalid(c *C) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"10-5 * * * *",
+		"* * * foo *",
+		"@never",
+	} {
+		_, err := parseCron(expr)
+		c.Check(err, NotNil, Commentf("%q", expr))
+	}
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/go-stomp/stomp/v3/server/queue"
+	. "gopkg.in/check.v1"
+)
+
+type DispatchSuite struct{}
+
+var _ = Suite(&DispatchSuite{})
+
+// lastReady is a dispatch policy that chooses the subscription
+// that became ready last, and counts the choices that it makes.
+type lastReady struct {
+	mutex   sync.Mutex
+	choices int
+}
+
+func (p *lastReady) Choose(f *frame.Frame, consumers []queue.Consumer) int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.choices++
+	return len(consumers) - 1
+}
+
+func (p *lastReady) Choices() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.choices
+}
+
+// Sends n messages to the destination, which are stored in the queue.
+func sendBacklog(c *C, conn *stomp.Conn, destination string, n int) {
+	for i := 0; i < n; i++ {
+		err := conn.Send(destination, "text/plain", []byte(fmt.Sprintf("job %d", i)),
+			stomp.SendOpt.Receipt)
+		c.Assert(err, IsNil)
+	}
+}
+
+// Subscribes a consumer on its own connection for each conns,
+// in turn, so that they become ready in that order.
+func subscribeConsumers(c *C, server *Server, destination string, conns []*stomp.Conn) []*stomp.Subscription {
+	subs := make([]*stomp.Subscription, len(conns))
+	for i, conn := range conns {
+		sub, err := conn.Subscribe(destination, stomp.AckClientIndividual)
+		c.Assert(err, IsNil)
+		subs[i] = sub
+		waitQueueSubscriptions(c, server, destination, i+1)
+	}
+	return subs
+}
+
+func (s *DispatchSuite) TestBacklog(c *C) {
+	policy := &lastReady{}
+	server := &Server{DispatchPolicy: func(destination string) queue.DispatchPolicy {
+		return policy
+	}}
+	l := listenLocal(c)
+	defer l.Close()
+	go server.Serve(l)
+	conn := dialBroker(c, l)
+	defer conn.Disconnect()
+
+	var conns []*stomp.Conn
+	for i := 0; i < 3; i++ {
+		conns = append(conns, dialBroker(c, l))
+	}
+	defer func() {
+		for _, conn := range conns {
+			conn.Disconnect()
+		}
+	}()
+
+	// the backlog is sent to the consumers as they subscribe
+	sendBacklog(c, conn, "/queue/backlog", 3)
+	for i, conn := range conns {
+		sub, err := conn.Subscribe("/queue/backlog", stomp.AckClientIndividual)
+		c.Assert(err, IsNil)
+		msg := receive(c, sub)
+		c.Check(string(msg.Body), Equals, fmt.Sprintf("job %d", i))
+		expectNone(c, sub)
+	}
+
+	// a backlog built while the queue is paused is sent to the ready
+	// consumers in the order chosen by the policy
+	c.Assert(server.PauseDestination("/queue/paused"), IsNil)
+	sendBacklog(c, conn, "/queue/paused", 3)
+	subs := subscribeConsumers(c, server, "/queue/paused", conns)
+	c.Assert(server.ResumeDestination("/queue/paused"), IsNil)
+	for i, sub := range subs {
+		msg := receive(c, sub)
+		c.Check(string(msg.Body), Equals, fmt.Sprintf("job %d", len(subs)-1-i))
+		expectNone(c, sub)
+	}
+	c.Check(policy.Choices(), Equals, 2)
+}
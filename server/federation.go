@@ -0,0 +1,627 @@
+package server
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/go-stomp/stomp/v3/server/client"
+	"github.com/go-stomp/stomp/v3/server/topic"
+)
+
+// Header entries used by federation links between brokers. They are
+// only accepted from clients that are trusted as peer brokers.
+const (
+	// FederationBrokerHeader identifies the broker on whose behalf a
+	// federation link connects to, and subscribes at, a peer broker.
+	FederationBrokerHeader = client.FederationBrokerHeader
+
+	// FederationPathHeader lists the identifiers of the brokers that a
+	// message has been forwarded from, separated by commas. A message
+	// is never forwarded to a broker that is already in its path.
+	FederationPathHeader = client.FederationPathHeader
+)
+
+// Default federation parameters.
+const (
+	// Default maximum number of links a message can travel.
+	DefaultFederationMaxHops = 1
+
+	// Default time to wait before reconnecting a failed link.
+	DefaultFederationReconnectInterval = 5 * time.Second
+
+	// Default number of messages a link transfers at the same time for
+	// each queue. Override by setting Federation.QueuePrefetch.
+	DefaultFederationQueuePrefetch = 10
+
+	// Default time for which a link keeps its subscriptions to a queue
+	// after the last local consumer of the queue has gone. Override by
+	// setting Federation.QueueIdleTimeout.
+	DefaultFederationQueueIdleTimeout = 30 * time.Second
+)
+
+var (
+	errMissingBrokerId       = errors.New("federation requires a broker id")
+	errMissingFederationAuth = errors.New("federation requires a login and an authenticator")
+	errLinkClosed            = errors.New("federation link closed")
+	errFederationLoop        = errors.New("message has already passed through this broker")
+)
+
+// Federation configures links between a Server and its peer brokers.
+//
+// Each broker connects to each of its peers as a STOMP client. When a
+// client subscribes to a topic, the broker subscribes to the same topic
+// at every peer, so that messages sent to the topic at any peer are
+// forwarded to it. When the last subscriber unsubscribes, the broker
+// unsubscribes from its peers. Only topics with remote demand cross
+// a link. The links reconnect when they fail, and are closed when the
+// listener passed to Serve is closed.
+//
+// Wildcard subscriptions, such as "/topic/prices.*" (see the topic
+// package), are advertised to peers as they are, so that the peers
+// forward every message sent to a matching topic. A topic that is
+// matched by a wildcard destination that is also advertised is left
+// out, so that the peer forwards each message once.
+//
+// A client is trusted as a peer broker if it identifies itself with
+// the FederationBrokerHeader entry in its CONNECT frame, and logs in
+// with Login. The federation header entries are removed from the SEND
+// and SUBSCRIBE frames of other clients, so that they cannot pass as
+// peer brokers or defeat the MaxHops limit. As any client can present
+// the login, Serve returns an error if Login is empty or the Server has
+// no Authenticator, which should only accept Login with Passcode.
+//
+// If Queues is set, a broker also pulls messages from the queues of its
+// peers. While a queue has local subscriptions, the broker subscribes to
+// the queue with the same name at every peer, with QueuePrefetch
+// subscriptions for each peer, which limit the number of messages in
+// transfer on the link. The subscriptions are kept until the queue has
+// had no local subscriptions for QueueIdleTimeout. A peer only sends
+// a message to these subscriptions when none of its own subscriptions
+// is ready. Each message is acknowledged at the peer after
+// it has been enqueued locally, so a message is never lost when a link
+// fails, but it can be received twice. A message pulled from a peer
+// is not pulled again by a broker in its path, and it travels no more
+// than MaxHops links.
+type Federation struct {
+	// Unique identifier for this broker. Required.
+	BrokerId string
+
+	// TCP addresses of the peer brokers.
+	Peers []string
+
+	// Maximum number of links a message can travel. A value of one,
+	// the default, is appropriate when every broker is a peer of every
+	// other broker. Larger values allow messages to travel through
+	// intermediate brokers, and demand is advertised through them.
+	MaxHops int
+
+	// Time to wait before reconnecting a link that has failed.
+	// If zero, DefaultFederationReconnectInterval is used.
+	ReconnectInterval time.Duration
+
+	// Login and passcode presented to peer brokers. Every broker in a
+	// federation uses the same login, and only clients that log in with
+	// it are trusted as peer brokers. Login is required.
+	Login, Passcode string
+
+	// Pull messages from the queues of peer brokers
+	// when local subscriptions are ready for them.
+	Queues bool
+
+	// Maximum number of messages that a link transfers at the same time
+	// for each queue. If zero, DefaultFederationQueuePrefetch is used.
+	// Messages transferred at the same time can be enqueued in a different
+	// order, so a value of one keeps the order of the peer's queue.
+	QueuePrefetch int
+
+	// Time for which a link keeps its subscriptions to a queue after the
+	// last local subscription to the queue has gone. If zero,
+	// DefaultFederationQueueIdleTimeout is used.
+	QueueIdleTimeout time.Duration
+}
+
+func (fc *Federation) maxHops() int {
+	if fc.MaxHops <= 0 {
+		return DefaultFederationMaxHops
+	}
+	return fc.MaxHops
+}
+
+func (fc *Federation) queuePrefetch() int {
+	if fc.QueuePrefetch <= 0 {
+		return DefaultFederationQueuePrefetch
+	}
+	return fc.QueuePrefetch
+}
+
+func (fc *Federation) queueIdleTimeout() time.Duration {
+	if fc.QueueIdleTimeout <= 0 {
+		return DefaultFederationQueueIdleTimeout
+	}
+	return fc.QueueIdleTimeout
+}
+
+func (fc *Federation) reconnectInterval() time.Duration {
+	if fc.ReconnectInterval <= 0 {
+		return DefaultFederationReconnectInterval
+	}
+	return fc.ReconnectInterval
+}
+
+// federator keeps track of topic and queue demand and maintains the
+// links to peer brokers. Demand is updated by the request processor
+// go-routine and read by the link go-routines.
+type federator struct {
+	config  *Federation
+	ch      chan client.Request        // for forwarding messages to the request processor
+	enqueue func(f *frame.Frame) error // enqueues a message pulled from a queue, and waits until it is stored
+	log     stomp.Logger
+	links   []*federationLink
+
+	mutex       sync.Mutex
+	demand      map[string]*topicDemand // keyed by topic destination
+	queueDemand map[string]bool         // queues with local subscriptions
+	queueIdle   map[string]*time.Timer  // queues without local subscriptions, until they time out
+}
+
+// topicDemand counts the subscriptions to a topic.
+type topicDemand struct {
+	local int            // subscriptions from clients
+	peers map[string]int // subscriptions from peer brokers, keyed by broker id
+}
+
+func newFederator(config *Federation, ch chan client.Request, log stomp.Logger) *federator {
+	fed := &federator{
+		config: config,
+		ch:     ch,
+		log:    log,
+		demand: make(map[string]*topicDemand),
+
+		queueDemand: make(map[string]bool),
+		queueIdle:   make(map[string]*time.Timer),
+	}
+	for _, addr := range config.Peers {
+		fed.links = append(fed.links, newFederationLink(fed, addr))
+	}
+	return fed
+}
+
+// Start the links, which are closed when done is closed.
+func (fed *federator) Start(done <-chan struct{}) {
+	for _, link := range fed.links {
+		go link.run(done)
+	}
+}
+
+// Subscribe records a subscription to a topic. The broker is the
+// identifier of the peer broker that made the subscription, or an empty
+// string for a subscription from a client.
+func (fed *federator) Subscribe(destination, broker string) {
+	fed.mutex.Lock()
+	d, ok := fed.demand[destination]
+	if !ok {
+		d = &topicDemand{peers: make(map[string]int)}
+		fed.demand[destination] = d
+	}
+	if broker == "" {
+		d.local++
+	} else {
+		d.peers[broker]++
+	}
+	fed.mutex.Unlock()
+	fed.notifyLinks()
+}
+
+// Unsubscribe removes a subscription recorded by Subscribe.
+func (fed *federator) Unsubscribe(destination, broker string) {
+	fed.mutex.Lock()
+	if d, ok := fed.demand[destination]; ok {
+		if broker == "" {
+			d.local--
+		} else if d.peers[broker]--; d.peers[broker] <= 0 {
+			delete(d.peers, broker)
+		}
+		if d.local <= 0 && len(d.peers) == 0 {
+			delete(fed.demand, destination)
+		}
+	}
+	fed.mutex.Unlock()
+	fed.notifyLinks()
+}
+
+// Topics returns the topics that should be subscribed to at the peer
+// broker identified by peer. This is every topic with a client
+// subscription, plus topics subscribed to by other peers if messages
+// are permitted to travel more than one link, less the topics that are
+// matched by a wildcard destination among them.
+func (fed *federator) Topics(peer string) map[string]bool {
+	fed.mutex.Lock()
+	defer fed.mutex.Unlock()
+	topics := make(map[string]bool)
+	for destination, d := range fed.demand {
+		if d.local > 0 {
+			topics[destination] = true
+			continue
+		}
+		if fed.config.maxHops() > 1 {
+			for broker := range d.peers {
+				if broker != peer {
+					topics[destination] = true
+					break
+				}
+			}
+		}
+	}
+
+	// the peer would send a message twice if the link subscribed to a
+	// topic as well as to a wildcard destination that matches it
+	for destination := range topics {
+		for pattern := range topics {
+			if pattern != destination && topic.IsWildcard(pattern) &&
+				topic.Match(pattern, destination) && !topic.Match(destination, pattern) {
+				delete(topics, destination)
+				break
+			}
+		}
+	}
+	return topics
+}
+
+// SetQueueDemand records whether a queue has local subscriptions. When
+// the last local subscription has gone, the demand for the queue remains
+// until the idle timeout, so that the links keep their subscriptions to
+// the queue while its consumers come and go.
+func (fed *federator) SetQueueDemand(destination string, consumers bool) {
+	fed.mutex.Lock()
+	defer fed.mutex.Unlock()
+	if consumers {
+		if timer, ok := fed.queueIdle[destination]; ok {
+			timer.Stop()
+			delete(fed.queueIdle, destination)
+		}
+		if !fed.queueDemand[destination] {
+			fed.queueDemand[destination] = true
+			fed.notifyLinks()
+		}
+		return
+	}
+
+	if !fed.queueDemand[destination] {
+		return
+	}
+	delete(fed.queueDemand, destination)
+	var timer *time.Timer
+	timer = time.AfterFunc(fed.config.queueIdleTimeout(), func() {
+		fed.mutex.Lock()
+		defer fed.mutex.Unlock()
+		if fed.queueIdle[destination] == timer {
+			delete(fed.queueIdle, destination)
+			fed.notifyLinks()
+		}
+	})
+	fed.queueIdle[destination] = timer
+}
+
+// DeleteQueueDemand removes the demand for a queue that has
+// been deleted, without waiting for the idle timeout.
+func (fed *federator) DeleteQueueDemand(destination string) {
+	fed.mutex.Lock()
+	defer fed.mutex.Unlock()
+	_, idle := fed.queueIdle[destination]
+	if !fed.queueDemand[destination] && !idle {
+		return
+	}
+	if idle {
+		fed.queueIdle[destination].Stop()
+		delete(fed.queueIdle, destination)
+	}
+	delete(fed.queueDemand, destination)
+	fed.notifyLinks()
+}
+
+// Queues returns the number of subscriptions that each link
+// should have to each queue at its peer broker.
+func (fed *federator) Queues() map[string]int {
+	fed.mutex.Lock()
+	defer fed.mutex.Unlock()
+	queues := make(map[string]int)
+	for destination := range fed.queueDemand {
+		queues[destination] = fed.config.queuePrefetch()
+	}
+	for destination := range fed.queueIdle {
+		queues[destination] = fed.config.queuePrefetch()
+	}
+	return queues
+}
+
+func (fed *federator) notifyLinks() {
+	for _, link := range fed.links {
+		link.notify()
+	}
+}
+
+// Forward a message received from the peer broker to the local
+// request processor. The peer is appended to the message's path.
+func (fed *federator) forward(peer string, msg *stomp.Message) {
+	if f := fed.federatedFrame(peer, msg); f != nil {
+		fed.ch <- client.Request{Op: client.EnqueueOp, Frame: f}
+	}
+}
+
+// Transfer a message pulled from a queue at the peer broker to the
+// local queue, and wait until it has been stored by the queue storage,
+// or sent to a local subscription. Returns an error if the message has
+// already passed through this broker, so that the peer keeps it.
+func (fed *federator) transfer(peer string, msg *stomp.Message) error {
+	f := fed.federatedFrame(peer, msg)
+	if f == nil {
+		return errFederationLoop
+	}
+	return fed.enqueue(f)
+}
+
+// Returns the frame for a message received from the peer broker,
+// with the peer appended to the message's path. Returns nil if
+// the message has already passed through this broker.
+func (fed *federator) federatedFrame(peer string, msg *stomp.Message) *frame.Frame {
+	path := parseFederationPath(msg.Header.Get(FederationPathHeader))
+	if containsBroker(path, fed.config.BrokerId) {
+		// should not happen, because the peer does not send
+		// messages to a broker in the path
+		return nil
+	}
+	if peer != "" && !containsBroker(path, peer) {
+		path = append(path, peer)
+	}
+
+	f := frame.New(frame.MESSAGE)
+	for i := 0; i < msg.Header.Len(); i++ {
+		key, value := msg.Header.GetAt(i)
+		switch key {
+		case frame.Subscription, frame.MessageId, frame.Ack, FederationPathHeader:
+			// allocated again when delivered locally
+			continue
+		}
+		f.Header.Add(key, value)
+	}
+	f.Header.Set(FederationPathHeader, strings.Join(path, ","))
+	f.Body = msg.Body
+	return f
+}
+
+// federatedSubscription wraps the subscription of a peer broker
+// to a topic, and prevents messages from being forwarded back to a
+// broker they have already passed through, or beyond the maximum
+// number of hops.
+type federatedSubscription struct {
+	sub     *client.Subscription
+	broker  string
+	maxHops int
+}
+
+func (fs *federatedSubscription) SendTopicFrame(f *frame.Frame) {
+	if !federationAccepts(f, fs.broker, fs.maxHops) {
+		return
+	}
+	fs.sub.SendTopicFrame(f)
+}
+
+// Returns true if the frame can be forwarded to the broker.
+func federationAccepts(f *frame.Frame, broker string, maxHops int) bool {
+	path := parseFederationPath(f.Header.Get(FederationPathHeader))
+	return len(path) < maxHops && !containsBroker(path, broker)
+}
+
+// federatedQueues identifies the subscriptions of peer brokers to
+// queues, and prevents messages from being pulled by a broker they
+// have already passed through, or beyond the maximum number of hops.
+type federatedQueues struct {
+	maxHops int
+}
+
+func (fq federatedQueues) IsRemote(sub *client.Subscription) bool {
+	_, ok := sub.Header().Contains(FederationBrokerHeader)
+	return ok
+}
+
+func (fq federatedQueues) Accepts(sub *client.Subscription, f *frame.Frame) bool {
+	return federationAccepts(f, sub.Header().Get(FederationBrokerHeader), fq.maxHops)
+}
+
+// A federationLink is a connection to a peer broker. It subscribes to
+// the topics with demand, forwards the messages it receives to the local
+// broker, and reconnects whenever the connection fails.
+type federationLink struct {
+	fed      *federator
+	addr     string
+	notifyCh chan struct{}
+}
+
+func newFederationLink(fed *federator, addr string) *federationLink {
+	return &federationLink{
+		fed:      fed,
+		addr:     addr,
+		notifyCh: make(chan struct{}, 1),
+	}
+}
+
+// notify the link that demand has changed, never blocks
+func (link *federationLink) notify() {
+	select {
+	case link.notifyCh <- struct{}{}:
+	default:
+	}
+}
+
+func (link *federationLink) run(done <-chan struct{}) {
+	config := link.fed.config
+	for {
+		var peer string
+		conn, err := stomp.Dial("tcp", link.addr,
+			stomp.ConnOpt.Login(config.Login, config.Passcode),
+			stomp.ConnOpt.Header(FederationBrokerHeader, config.BrokerId),
+			stomp.ConnOpt.Logger(link.fed.log),
+			stomp.ConnOpt.ResponseHeaders(func(h *frame.Header) {
+				peer = h.Get(client.BrokerIdHeader)
+			}))
+		if err != nil {
+			link.fed.log.Warningf("federation: cannot connect to %s: %v", link.addr, err)
+		} else {
+			link.fed.log.Infof("federation: connected to %s (%s)", link.addr, peer)
+			err = link.serve(conn, peer, done)
+			if err == errLinkClosed {
+				conn.Disconnect()
+				return
+			}
+			link.fed.log.Warningf("federation: link to %s failed: %v", link.addr, err)
+			conn.MustDisconnect()
+		}
+		select {
+		case <-time.After(config.reconnectInterval()):
+		case <-done:
+			return
+		}
+	}
+}
+
+// serve keeps the subscriptions on conn in step with the demand
+// for topics and queues, until the connection fails, or done is
+// closed, in which case errLinkClosed is returned.
+func (link *federationLink) serve(conn *stomp.Conn, peer string, done <-chan struct{}) error {
+	subs := make(map[string]*stomp.Subscription)
+	qsubs := make(map[string][]*stomp.Subscription)
+	errCh := make(chan error, 1)
+
+	defer func() {
+		for _, sub := range subs {
+			go sub.Unsubscribe()
+		}
+		for _, list := range qsubs {
+			for _, sub := range list {
+				go sub.Unsubscribe()
+			}
+		}
+	}()
+
+	for {
+		topics := link.fed.Topics(peer)
+		for destination, sub := range subs {
+			if !topics[destination] {
+				delete(subs, destination)
+				go sub.Unsubscribe()
+			}
+		}
+		for destination := range topics {
+			if _, ok := subs[destination]; ok {
+				continue
+			}
+			sub, err := conn.Subscribe(destination, stomp.AckAuto,
+				stomp.SubscribeOpt.Header(FederationBrokerHeader, link.fed.config.BrokerId))
+			if err != nil {
+				return err
+			}
+			subs[destination] = sub
+			go link.receive(sub, peer, errCh)
+		}
+
+		if link.fed.config.Queues {
+			if err := link.pullQueues(conn, peer, qsubs, errCh); err != nil {
+				return err
+			}
+		}
+
+		select {
+		case <-link.notifyCh:
+		case err := <-errCh:
+			return err
+		case <-done:
+			return errLinkClosed
+		}
+	}
+}
+
+func (link *federationLink) receive(sub *stomp.Subscription, peer string, errCh chan error) {
+	for msg := range sub.C {
+		if msg.Err != nil {
+			select {
+			case errCh <- msg.Err:
+			default:
+			}
+			return
+		}
+		link.fed.forward(peer, msg)
+	}
+}
+
+// pullQueues keeps the number of subscriptions on conn to each queue
+// in step with the demand for the queue.
+func (link *federationLink) pullQueues(conn *stomp.Conn, peer string, qsubs map[string][]*stomp.Subscription, errCh chan error) error {
+	queues := link.fed.Queues()
+	for destination, list := range qsubs {
+		for len(list) > queues[destination] {
+			sub := list[len(list)-1]
+			list = list[:len(list)-1]
+			go sub.Unsubscribe()
+		}
+		if len(list) == 0 {
+			delete(qsubs, destination)
+		} else {
+			qsubs[destination] = list
+		}
+	}
+	for destination, n := range queues {
+		for len(qsubs[destination]) < n {
+			sub, err := conn.Subscribe(destination, stomp.AckClientIndividual,
+				stomp.SubscribeOpt.Header(FederationBrokerHeader, link.fed.config.BrokerId))
+			if err != nil {
+				return err
+			}
+			qsubs[destination] = append(qsubs[destination], sub)
+			go link.pull(conn, sub, peer, errCh)
+		}
+	}
+	return nil
+}
+
+// pull transfers the messages received by a subscription to a queue
+// at the peer broker to the local queue. Each message is acknowledged
+// only after the local queue storage has stored it, or it has been sent
+// to a subscription, so that the peer sends it again if the link fails
+// first. A message that cannot be enqueued is returned to the peer.
+func (link *federationLink) pull(conn *stomp.Conn, sub *stomp.Subscription, peer string, errCh chan error) {
+	for msg := range sub.C {
+		if msg.Err != nil {
+			select {
+			case errCh <- msg.Err:
+			default:
+			}
+			return
+		}
+		if err := link.fed.transfer(peer, msg); err != nil {
+			link.fed.log.Warningf("federation: cannot enqueue message from %s: %v", link.addr, err)
+			conn.Nack(msg)
+			continue
+		}
+		conn.Ack(msg)
+	}
+}
+
+func parseFederationPath(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
+
+func containsBroker(path []string, broker string) bool {
+	for _, b := range path {
+		if b == broker {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,394 @@
+package server
+
+import (
+	"net"
+	"time"
+
+	"github.com/go-stomp/stomp/v3"
+	. "gopkg.in/check.v1"
+)
+
+type FederationSuite struct{}
+
+var _ = Suite(&FederationSuite{})
+
+func listenLocal(c *C) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	return l
+}
+
+// startBrokers starts one federated broker per listener, with peers
+// as specified by the adjacency list (indexes into listeners).
+func startBrokers(c *C, maxHops int, peers map[int][]int) []net.Listener {
+	listeners := make([]net.Listener, len(peers))
+	for i := range listeners {
+		listeners[i] = listenLocal(c)
+	}
+	for i, l := range listeners {
+		fed := &Federation{
+			BrokerId:          string(rune('A' + i)),
+			MaxHops:           maxHops,
+			ReconnectInterval: 10 * time.Millisecond,
+		}
+		for _, p := range peers[i] {
+			fed.Peers = append(fed.Peers, listeners[p].Addr().String())
+		}
+		go federatedServer(fed).Serve(l)
+	}
+	return listeners
+}
+
+// The login and passcode of the federation links in the tests.
+const (
+	peerLogin    = "peer"
+	peerPasscode = "secret"
+)
+
+// peerAuthenticator accepts the federation login only with its
+// passcode, and any other login.
+type peerAuthenticator struct{}
+
+func (peerAuthenticator) Authenticate(login, passcode string) bool {
+	return login != peerLogin || passcode == peerPasscode
+}
+
+// federatedServer returns a server for the federation, which trusts
+// the links of its peers.
+func federatedServer(fed *Federation) *Server {
+	fed.Login, fed.Passcode = peerLogin, peerPasscode
+	return &Server{Federation: fed, Authenticator: peerAuthenticator{}}
+}
+
+func dialBroker(c *C, l net.Listener) *stomp.Conn {
+	conn, err := stomp.Dial("tcp", l.Addr().String())
+	c.Assert(err, IsNil)
+	return conn
+}
+
+// publishUntilReceived sends messages to destination on the sender until
+// every subscription has received one, which indicates that the federation
+// links have subscribed. Any other messages in flight are then discarded.
+func publishUntilReceived(c *C, sender *stomp.Conn, destination string, subs ...*stomp.Subscription) {
+	deadline := time.After(5 * time.Second)
+	received := make(map[*stomp.Subscription]bool)
+	for len(received) < len(subs) {
+		err := sender.Send(destination, "text/plain", []byte("warm-up"))
+		c.Assert(err, IsNil)
+		time.Sleep(20 * time.Millisecond)
+		for _, sub := range subs {
+			select {
+			case msg := <-sub.C:
+				c.Assert(msg.Err, IsNil)
+				received[sub] = true
+			case <-deadline:
+				c.Fatal("timed out waiting for federation link")
+			default:
+			}
+		}
+	}
+
+	for _, sub := range subs {
+		for drained := false; !drained; {
+			select {
+			case <-sub.C:
+			case <-time.After(100 * time.Millisecond):
+				drained = true
+			}
+		}
+	}
+}
+
+// expectOnce asserts that exactly one message with the body is received.
+func expectOnce(c *C, sub *stomp.Subscription, body string) {
+	select {
+	case msg := <-sub.C:
+		c.Assert(msg.Err, IsNil)
+		c.Assert(string(msg.Body), Equals, body)
+	case <-time.After(5 * time.Second):
+		c.Fatal("timed out waiting for message")
+	}
+	select {
+	case msg := <-sub.C:
+		c.Fatalf("unexpected duplicate message: %s", msg.Body)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func (s *FederationSuite) TestFullMesh(c *C) {
+	l := startBrokers(c, 1, map[int][]int{
+		0: {1, 2},
+		1: {0, 2},
+		2: {0, 1},
+	})
+	defer func() {
+		for _, listener := range l {
+			listener.Close()
+		}
+	}()
+
+	a := dialBroker(c, l[0])
+	defer a.Disconnect()
+	b := dialBroker(c, l[1])
+	defer b.Disconnect()
+	cc := dialBroker(c, l[2])
+	defer cc.Disconnect()
+
+	subB, err := b.Subscribe("/topic/mesh", stomp.AckAuto)
+	c.Assert(err, IsNil)
+	subC, err := cc.Subscribe("/topic/mesh", stomp.AckAuto)
+	c.Assert(err, IsNil)
+
+	publishUntilReceived(c, a, "/topic/mesh", subB, subC)
+
+	err = a.Send("/topic/mesh", "text/plain", []byte("from A"))
+	c.Assert(err, IsNil)
+	expectOnce(c, subB, "from A")
+	expectOnce(c, subC, "from A")
+
+	// a message published at a broker with a local subscriber
+	// is delivered locally and to the other broker, once each
+	err = b.Send("/topic/mesh", "text/plain", []byte("from B"))
+	c.Assert(err, IsNil)
+	expectOnce(c, subB, "from B")
+	expectOnce(c, subC, "from B")
+}
+
+func (s *FederationSuite) TestChain(c *C) {
+	// A <-> B <-> C, so messages from A travel two links to reach C
+	l := startBrokers(c, 2, map[int][]int{
+		0: {1},
+		1: {0, 2},
+		2: {1},
+	})
+	defer func() {
+		for _, listener := range l {
+			listener.Close()
+		}
+	}()
+
+	a := dialBroker(c, l[0])
+	defer a.Disconnect()
+	cc := dialBroker(c, l[2])
+	defer cc.Disconnect()
+
+	subC, err := cc.Subscribe("/topic/chain", stomp.AckAuto)
+	c.Assert(err, IsNil)
+
+	publishUntilReceived(c, a, "/topic/chain", subC)
+
+	err = a.Send("/topic/chain", "text/plain", []byte("from A"))
+	c.Assert(err, IsNil)
+	expectOnce(c, subC, "from A")
+}
+
+func (s *FederationSuite) TestWildcard(c *C) {
+	l := startBrokers(c, 1, map[int][]int{
+		0: {1},
+		1: {0},
+	})
+	defer func() {
+		for _, listener := range l {
+			listener.Close()
+		}
+	}()
+
+	a := dialBroker(c, l[0])
+	defer a.Disconnect()
+	b := dialBroker(c, l[1])
+	defer b.Disconnect()
+
+	prices, err := b.Subscribe("/topic/prices.*", stomp.AckAuto)
+	c.Assert(err, IsNil)
+	gold, err := b.Subscribe("/topic/prices.gold", stomp.AckAuto)
+	c.Assert(err, IsNil)
+
+	publishUntilReceived(c, a, "/topic/prices.gold", prices, gold)
+
+	// each subscription receives a message once, although both of
+	// them match it
+	err = a.Send("/topic/prices.gold", "text/plain", []byte("gold"))
+	c.Assert(err, IsNil)
+	expectOnce(c, prices, "gold")
+	expectOnce(c, gold, "gold")
+
+	// other topics that match the wildcard are forwarded
+	err = a.Send("/topic/prices.silver", "text/plain", []byte("silver"))
+	c.Assert(err, IsNil)
+	expectOnce(c, prices, "silver")
+	expectNone(c, gold)
+
+	// and topics that do not match are not
+	err = a.Send("/topic/news.gold", "text/plain", []byte("news"))
+	c.Assert(err, IsNil)
+	expectNone(c, prices)
+}
+
+func (s *FederationSuite) TestReconnect(c *C) {
+	// reserve an address for the second broker, but do not listen yet
+	reserved := listenLocal(c)
+	addrB := reserved.Addr().String()
+	reserved.Close()
+
+	la := listenLocal(c)
+	defer la.Close()
+	go federatedServer(&Federation{
+		BrokerId:          "A",
+		Peers:             []string{addrB},
+		ReconnectInterval: 10 * time.Millisecond,
+	}).Serve(la)
+
+	a := dialBroker(c, la)
+	defer a.Disconnect()
+	subA, err := a.Subscribe("/topic/reconnect", stomp.AckAuto)
+	c.Assert(err, IsNil)
+
+	// let the link fail to connect a few times
+	time.Sleep(50 * time.Millisecond)
+
+	lb, err := net.Listen("tcp", addrB)
+	c.Assert(err, IsNil)
+	defer lb.Close()
+	go federatedServer(&Federation{BrokerId: "B"}).Serve(lb)
+
+	b := dialBroker(c, lb)
+	defer b.Disconnect()
+	publishUntilReceived(c, b, "/topic/reconnect", subA)
+}
+
+func (s *FederationSuite) TestLinksClosed(c *C) {
+	lb := listenLocal(c)
+	defer lb.Close()
+	b := federatedServer(&Federation{BrokerId: "B"})
+	go b.Serve(lb)
+	client := dialBroker(c, lb)
+	defer client.Disconnect()
+
+	la := listenLocal(c)
+	go federatedServer(&Federation{
+		BrokerId:          "A",
+		Peers:             []string{lb.Addr().String()},
+		ReconnectInterval: 10 * time.Millisecond,
+	}).Serve(la)
+
+	waitConnections := func(n int) {
+		for i := 0; i < 500; i++ {
+			stats, err := b.Stats()
+			c.Assert(err, IsNil)
+			if stats.Connections == n {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		c.Fatalf("timed out waiting for %d connections", n)
+	}
+
+	// the link from A is closed with its listener, and does not reconnect
+	waitConnections(2)
+	la.Close()
+	waitConnections(1)
+	time.Sleep(50 * time.Millisecond)
+	waitConnections(1)
+}
+
+func (s *FederationSuite) TestUntrustedHeaders(c *C) {
+	l := listenLocal(c)
+	defer l.Close()
+	go federatedServer(&Federation{BrokerId: "A"}).Serve(l)
+
+	receive := func(sub *stomp.Subscription) *stomp.Message {
+		select {
+		case msg := <-sub.C:
+			c.Assert(msg.Err, IsNil)
+			return msg
+		case <-time.After(5 * time.Second):
+			c.Fatal("timed out waiting for message")
+		}
+		return nil
+	}
+
+	// a client cannot subscribe on behalf of broker B, so this is an
+	// ordinary subscription that receives messages that came from B
+	client := dialBroker(c, l)
+	defer client.Disconnect()
+	sub, err := client.Subscribe("/topic/untrusted", stomp.AckAuto,
+		stomp.SubscribeOpt.Header(FederationBrokerHeader, "B"))
+	c.Assert(err, IsNil)
+	c.Assert(client.Send("/topic/untrusted", "text/plain", []byte("subscribed")), IsNil)
+	receive(sub)
+
+	// a client cannot forward a message that has passed through other brokers
+	err = client.Send("/topic/untrusted", "text/plain", []byte("from client"),
+		stomp.SendOpt.Header(FederationPathHeader, "C"))
+	c.Assert(err, IsNil)
+	msg := receive(sub)
+	c.Check(string(msg.Body), Equals, "from client")
+	_, ok := msg.Header.Contains(FederationPathHeader)
+	c.Check(ok, Equals, false)
+
+	// and neither can a client that logs in as a peer without the passcode
+	_, err = stomp.Dial("tcp", l.Addr().String(),
+		stomp.ConnOpt.Login(peerLogin, ""),
+		stomp.ConnOpt.Header(FederationBrokerHeader, "C"))
+	c.Check(err, NotNil)
+
+	// a peer broker can
+	peer, err := stomp.Dial("tcp", l.Addr().String(),
+		stomp.ConnOpt.Login(peerLogin, peerPasscode),
+		stomp.ConnOpt.Header(FederationBrokerHeader, "C"))
+	c.Assert(err, IsNil)
+	defer peer.Disconnect()
+	err = peer.Send("/topic/untrusted", "text/plain", []byte("from peer"),
+		stomp.SendOpt.Header(FederationPathHeader, "B"))
+	c.Assert(err, IsNil)
+	msg = receive(sub)
+	c.Check(string(msg.Body), Equals, "from peer")
+	c.Check(msg.Header.Get(FederationPathHeader), Equals, "B")
+}
+
+func (s *FederationSuite) TestDemand(c *C) {
+	fed := newFederator(&Federation{BrokerId: "A", MaxHops: 2}, nil, nil)
+
+	fed.Subscribe("/topic/local", "")
+	fed.Subscribe("/topic/remote", "B")
+	c.Check(fed.Topics("B"), DeepEquals, map[string]bool{"/topic/local": true})
+	c.Check(fed.Topics("C"), DeepEquals, map[string]bool{"/topic/local": true, "/topic/remote": true})
+
+	fed.Unsubscribe("/topic/local", "")
+	fed.Unsubscribe("/topic/remote", "B")
+	c.Check(fed.Topics("C"), DeepEquals, map[string]bool{})
+
+	// a topic matched by a wildcard destination is not subscribed twice
+	fed.Subscribe("/topic/prices.gold", "")
+	fed.Subscribe("/topic/prices.*", "")
+	fed.Subscribe("/topic/news", "")
+	c.Check(fed.Topics("B"), DeepEquals, map[string]bool{"/topic/prices.*": true, "/topic/news": true})
+
+	// demand from peers is not propagated with a single hop
+	fed = newFederator(&Federation{BrokerId: "A"}, nil, nil)
+	fed.Subscribe("/topic/remote", "B")
+	c.Check(fed.Topics("C"), DeepEquals, map[string]bool{})
+}
+
+func (s *FederationSuite) TestMissingBrokerId(c *C) {
+	l := listenLocal(c)
+	defer l.Close()
+	err := (&Server{Federation: &Federation{}}).Serve(l)
+	c.Assert(err, Equals, errMissingBrokerId)
+}
+
+func (s *FederationSuite) TestMissingAuthentication(c *C) {
+	l := listenLocal(c)
+	defer l.Close()
+
+	// anonymous clients would be trusted as peers without a login
+	err := (&Server{
+		Federation:    &Federation{BrokerId: "A"},
+		Authenticator: peerAuthenticator{},
+	}).Serve(l)
+	c.Check(err, Equals, errMissingFederationAuth)
+
+	// and any client could present the login without an authenticator
+	err = (&Server{Federation: &Federation{BrokerId: "A", Login: peerLogin}}).Serve(l)
+	c.Check(err, Equals, errMissingFederationAuth)
+}
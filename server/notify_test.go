@@ -0,0 +1,111 @@
+package server
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/server/client"
+	"github.com/go-stomp/stomp/v3/server/queue"
+	. "gopkg.in/check.v1"
+)
+
+type NotifySuite struct{}
+
+var _ = Suite(&NotifySuite{})
+
+func receive(c *C, sub *stomp.Subscription) *stomp.Message {
+	select {
+	case msg := <-sub.C:
+		c.Assert(msg.Err, IsNil)
+		return msg
+	case <-time.After(5 * time.Second):
+		c.Fatal("timed out waiting for message")
+	}
+	return nil
+}
+
+func (s *NotifySuite) TestAck(c *C) {
+	server := &Server{}
+	conn := startServer(c, server)
+	defer conn.Disconnect()
+
+	notifications, err := conn.Subscribe("/queue/notifications", stomp.AckAuto)
+	c.Assert(err, IsNil)
+	sub, err := conn.Subscribe("/queue/work", stomp.AckClientIndividual)
+	c.Assert(err, IsNil)
+
+	err = conn.Send("/queue/work", "text/plain", []byte("1"),
+		stomp.SendOpt.Header(client.NotifyOnAckHeader, "/queue/notifications"),
+		stomp.SendOpt.Header(client.CorrelationIdHeader, "42"))
+	c.Assert(err, IsNil)
+	msg := receive(c, sub)
+	c.Assert(conn.Ack(msg), IsNil)
+
+	n := receive(c, notifications)
+	c.Check(n.Header.Get(client.AckOutcomeHeader), Equals, client.AckOutcomeAck)
+	c.Check(n.Header.Get(client.AckedMessageIdHeader), Equals, msg.Header.Get("message-id"))
+	c.Check(n.Header.Get(client.AckedDestinationHeader), Equals, "/queue/work")
+	c.Check(n.Header.Get(client.AckSessionHeader), Equals, conn.Session())
+	c.Check(n.Header.Get(client.CorrelationIdHeader), Equals, "42")
+	_, err = strconv.ParseInt(n.Header.Get(client.AckTimestampHeader), 10, 64)
+	c.Check(err, IsNil)
+}
+
+func (s *NotifySuite) TestNackLimit(c *C) {
+	server := &Server{NackLimit: 2}
+	conn := startServer(c, server)
+	defer conn.Disconnect()
+
+	notifications, err := conn.Subscribe("/queue/notifications", stomp.AckAuto)
+	c.Assert(err, IsNil)
+	sub, err := conn.Subscribe("/queue/work", stomp.AckClientIndividual)
+	c.Assert(err, IsNil)
+
+	// the producer cannot provide the NACK count
+	err = conn.Send("/queue/work", "text/plain", []byte("1"),
+		stomp.SendOpt.Header(client.NotifyOnAckHeader, "/queue/notifications"),
+		stomp.SendOpt.Header(client.NackCountHeader, "5"))
+	c.Assert(err, IsNil)
+	msg := receive(c, sub)
+	_, ok := msg.Header.Contains(client.NackCountHeader)
+	c.Check(ok, Equals, false)
+
+	// the message is requeued after the first NACK
+	c.Assert(conn.Nack(msg), IsNil)
+	msg = receive(c, sub)
+	c.Check(msg.Header.Get(client.NackCountHeader), Equals, "1")
+	expectNone(c, notifications)
+
+	// and discarded after the second
+	c.Assert(conn.Nack(msg), IsNil)
+	n := receive(c, notifications)
+	c.Check(n.Header.Get(client.AckOutcomeHeader), Equals, client.AckOutcomeNack)
+	expectNone(c, sub)
+}
+
+func (s *NotifySuite) TestExpired(c *C) {
+	server := &Server{}
+	conn := startServer(c, server)
+	defer conn.Disconnect()
+
+	notifications, err := conn.Subscribe("/queue/notifications", stomp.AckAuto)
+	c.Assert(err, IsNil)
+
+	expires := time.Now().Add(50*time.Millisecond).UnixNano() / int64(time.Millisecond)
+	err = conn.Send("/queue/work", "text/plain", []byte("1"),
+		stomp.SendOpt.Receipt,
+		stomp.SendOpt.Header(client.NotifyOnAckHeader, "/queue/notifications"),
+		stomp.SendOpt.Header(queue.MessageExpiresHeader, strconv.FormatInt(expires, 10)))
+	c.Assert(err, IsNil)
+	time.Sleep(100 * time.Millisecond)
+
+	// the expired message is discarded when the queue dequeues it
+	sub, err := conn.Subscribe("/queue/work", stomp.AckAuto)
+	c.Assert(err, IsNil)
+	n := receive(c, notifications)
+	c.Check(n.Header.Get(client.AckOutcomeHeader), Equals, client.AckOutcomeExpired)
+	_, ok := n.Header.Contains(client.AckSessionHeader)
+	c.Check(ok, Equals, false)
+	expectNone(c, sub)
+}
@@ -0,0 +1,159 @@
+package server
+
+import (
+	"time"
+
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/server/queue"
+	. "gopkg.in/check.v1"
+)
+
+type PauseSuite struct{}
+
+var _ = Suite(&PauseSuite{})
+
+// pauseStorage is an in-memory queue storage that records
+// the paused state of destinations.
+type pauseStorage struct {
+	queue.Storage
+	paused map[string]bool
+}
+
+func (ps *pauseStorage) PausedDestinations() ([]string, error) {
+	var destinations []string
+	for destination := range ps.paused {
+		destinations = append(destinations, destination)
+	}
+	return destinations, nil
+}
+
+func (ps *pauseStorage) SetPaused(destination string, paused bool) error {
+	if paused {
+		ps.paused[destination] = true
+	} else {
+		delete(ps.paused, destination)
+	}
+	return nil
+}
+
+// startServer starts serving s, and waits until it is serving.
+func startServer(c *C, s *Server) *stomp.Conn {
+	l := listenLocal(c)
+	go s.Serve(l)
+	conn := dialBroker(c, l)
+	for {
+		if _, err := s.processor(); err == nil {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return conn
+}
+
+func expectNone(c *C, sub *stomp.Subscription) {
+	select {
+	case msg := <-sub.C:
+		c.Fatalf("unexpected message: %s", msg.Body)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func (s *PauseSuite) TestNotServing(c *C) {
+	server := &Server{}
+	c.Check(server.PauseDestination("/queue/a"), Equals, errNotServing)
+	c.Check(server.ResumeDestination("/queue/a"), Equals, errNotServing)
+	_, err := server.Stats()
+	c.Check(err, Equals, errNotServing)
+}
+
+func (s *PauseSuite) TestPauseQueue(c *C) {
+	server := &Server{}
+	conn := startServer(c, server)
+	defer conn.Disconnect()
+
+	sub, err := conn.Subscribe("/queue/paused", stomp.AckAuto)
+	c.Assert(err, IsNil)
+	c.Assert(server.PauseDestination("/queue/paused"), IsNil)
+
+	for _, body := range []string{"1", "2"} {
+		err = conn.Send("/queue/paused", "text/plain", []byte(body),
+			stomp.SendOpt.Receipt)
+		c.Assert(err, IsNil)
+	}
+	expectNone(c, sub)
+
+	stats, err := server.Stats()
+	c.Assert(err, IsNil)
+	c.Check(stats.Queues, DeepEquals, []DestinationStats{
+		{Name: "/queue/paused", Paused: true, Subscriptions: 1, Pending: 2},
+	})
+
+	// the subscription is still active
+	c.Assert(server.ResumeDestination("/queue/paused"), IsNil)
+	for _, body := range []string{"1", "2"} {
+		select {
+		case msg := <-sub.C:
+			c.Assert(msg.Err, IsNil)
+			c.Check(string(msg.Body), Equals, body)
+		case <-time.After(5 * time.Second):
+			c.Fatal("timed out waiting for message")
+		}
+	}
+
+	stats, err = server.Stats()
+	c.Assert(err, IsNil)
+	c.Check(stats.Queues[0].Paused, Equals, false)
+	c.Check(stats.Queues[0].Pending, Equals, 0)
+}
+
+func (s *PauseSuite) TestPauseTopic(c *C) {
+	server := &Server{PausedTopicBuffer: 1}
+	conn := startServer(c, server)
+	defer conn.Disconnect()
+
+	sub, err := conn.Subscribe("/topic/paused", stomp.AckAuto)
+	c.Assert(err, IsNil)
+	c.Assert(server.PauseDestination("/topic/paused"), IsNil)
+
+	for _, body := range []string{"1", "2"} {
+		err = conn.Send("/topic/paused", "text/plain", []byte(body),
+			stomp.SendOpt.Receipt)
+		c.Assert(err, IsNil)
+	}
+	expectNone(c, sub)
+
+	stats, err := server.Stats()
+	c.Assert(err, IsNil)
+	c.Check(stats.Topics, DeepEquals, []DestinationStats{
+		{Name: "/topic/paused", Paused: true, Subscriptions: 1, Pending: 1, Dropped: 1},
+	})
+
+	c.Assert(server.ResumeDestination("/topic/paused"), IsNil)
+	expectOnce(c, sub, "1")
+}
+
+func (s *PauseSuite) TestRestorePaused(c *C) {
+	storage := &pauseStorage{
+		Storage: queue.NewMemoryQueueStorage(),
+		paused:  make(map[string]bool),
+	}
+	server := &Server{QueueStorage: storage}
+	conn := startServer(c, server)
+	c.Assert(server.PauseDestination("/queue/durable"), IsNil)
+	c.Check(storage.paused, DeepEquals, map[string]bool{"/queue/durable": true})
+	conn.Disconnect()
+
+	// a new server with the same storage starts with the queue paused
+	server = &Server{QueueStorage: storage}
+	conn = startServer(c, server)
+	defer conn.Disconnect()
+
+	stats, err := server.Stats()
+	c.Assert(err, IsNil)
+	c.Assert(stats.Queues, HasLen, 1)
+	c.Check(stats.Queues[0].Name, Equals, "/queue/durable")
+	c.Check(stats.Queues[0].Paused, Equals, true)
+
+	c.Assert(server.ResumeDestination("/queue/durable"), IsNil)
+	c.Check(storage.paused, DeepEquals, map[string]bool{})
+}
@@ -13,18 +13,33 @@ import (
 )
 
 type requestProcessor struct {
-	server *Server
-	ch     chan client.Request
-	tm     *topic.Manager
-	qm     *queue.Manager
-	stop   bool // has stop been requested
+	server   *Server
+	ch       chan client.Request
+	ctl      chan func()   // functions to run on the processor go-routine
+	done     chan struct{} // closed when the listener has been closed
+	tm       *topic.Manager
+	qm       *queue.Manager
+	fed      *federator                                      // nil if not federated
+	fedSubs  map[*client.Subscription]*federatedSubscription // topic subscriptions from peer brokers
+	conns    map[*client.Conn]bool                           // connected clients
+	sessions *client.SessionStore                            // nil if sessions cannot be resumed
+	stop     bool                                            // has stop been requested
+
+	autoDeleted int            // queues deleted when their last subscription was unsubscribed
+	expired     int            // queues deleted when they expired
+	schedules   []*scheduleRun // recurring publications
+
+	notifications []*frame.Frame // notifications for expired frames, not yet published
 }
 
 func newRequestProcessor(server *Server) *requestProcessor {
 	proc := &requestProcessor{
 		server: server,
 		ch:     make(chan client.Request, 128),
+		ctl:    make(chan func()),
+		done:   make(chan struct{}),
 		tm:     topic.NewManager(),
+		conns:  make(map[*client.Conn]bool),
 	}
 
 	if server.QueueStorage == nil {
@@ -32,67 +47,349 @@ func newRequestProcessor(server *Server) *requestProcessor {
 	} else {
 		proc.qm = queue.NewManager(server.QueueStorage)
 	}
+	if server.DispatchPolicy != nil {
+		proc.qm.SetDispatchPolicy(server.DispatchPolicy)
+	}
+	proc.qm.SetExpiredFunc(proc.expiredFrame)
+
+	if server.SessionGracePeriod > 0 {
+		proc.sessions = client.NewSessionStore(server.SessionGracePeriod)
+	}
+
+	if server.Federation != nil {
+		proc.fed = newFederator(server.Federation, proc.ch, server.Log)
+		proc.fed.enqueue = proc.enqueueFederated
+		proc.fedSubs = make(map[*client.Subscription]*federatedSubscription)
+		proc.qm.SetRemote(federatedQueues{maxHops: server.Federation.maxHops()})
+	}
 
 	return proc
 }
 
 func (proc *requestProcessor) Serve(l net.Listener) error {
+	if err := proc.restorePaused(); err != nil {
+		return err
+	}
+
 	go proc.Listen(l)
 
+	if proc.fed != nil {
+		proc.fed.Start(proc.done)
+	}
+
+	expiry := time.NewTicker(queueExpiryInterval)
+	defer expiry.Stop()
+
+	sched := newScheduler(proc.server.ProducerWeight)
+
+	// timer for the next run of the recurring publications
+	proc.startSchedules(time.Now())
+	timer := proc.scheduleTimer(time.Now())
+	runSchedules := func(now time.Time) {
+		proc.runSchedules(now, sched)
+		timer = proc.scheduleTimer(time.Now())
+	}
+
 	for {
-		r := <-proc.ch
-		switch r.Op {
-		case client.SubscribeOp:
-			if isQueueDestination(r.Sub.Destination()) {
-				queue := proc.qm.Find(r.Sub.Destination())
-				// todo error handling
-				queue.Subscribe(r.Sub)
-			} else {
-				topic := proc.tm.Find(r.Sub.Destination())
-				topic.Subscribe(r.Sub)
+		var timerChannel <-chan time.Time
+		if timer != nil {
+			timerChannel = timer.C
+		}
+
+		if sched.Empty() {
+			// wait for a request
+			select {
+			case r := <-proc.ch:
+				sched.Add(r)
+			case fn := <-proc.ctl:
+				fn()
+				continue
+			case now := <-expiry.C:
+				proc.expireQueues(now)
+				continue
+			case now := <-timerChannel:
+				runSchedules(now)
 			}
+		}
 
-		case client.UnsubscribeOp:
-			if isQueueDestination(r.Sub.Destination()) {
-				queue := proc.qm.Find(r.Sub.Destination())
-				// todo error handling
-				queue.Unsubscribe(r.Sub)
-			} else {
-				topic := proc.tm.Find(r.Sub.Destination())
-				topic.Unsubscribe(r.Sub)
+		// collect all waiting requests, so that they can be scheduled
+	collect:
+		for {
+			select {
+			case r := <-proc.ch:
+				sched.Add(r)
+			case fn := <-proc.ctl:
+				fn()
+			case now := <-expiry.C:
+				proc.expireQueues(now)
+			case now := <-timerChannel:
+				runSchedules(now)
+				break collect
+			default:
+				break collect
 			}
+		}
 
-		case client.EnqueueOp:
-			destination, ok := r.Frame.Header.Contains(frame.Destination)
-			if !ok {
-				// should not happen, already checked in lower layer
-				panic("missing destination")
+		if r, ok := sched.Next(); ok {
+			if r.Op == client.EnqueueOp && r.Conn != nil {
+				r.Conn.ReleaseCredit()
 			}
+			proc.handleRequest(r)
+		}
+	}
+	// this is no longer required for go 1.1
+	panic("not reached")
+}
+
+func (proc *requestProcessor) handleRequest(r client.Request) {
+	switch r.Op {
+	case client.SubscribeOp:
+		if isQueueDestination(r.Sub.Destination()) {
+			queue := proc.qm.Find(r.Sub.Destination())
+			proc.setQueueAttributes(queue, r.Sub.Header())
+			// todo error handling
+			queue.Subscribe(r.Sub)
+			proc.updateQueueDemand(queue)
+		} else {
+			proc.subscribeTopic(r.Sub)
+		}
 
-			if isQueueDestination(destination) {
-				queue := proc.qm.Find(destination)
-				queue.Enqueue(r.Frame)
+	case client.UnsubscribeOp:
+		if isQueueDestination(r.Sub.Destination()) {
+			queue := proc.qm.Find(r.Sub.Destination())
+			consumers := queue.Consumers()
+			// todo error handling
+			queue.Unsubscribe(r.Sub)
+			if consumers > 0 && queue.Consumers() == 0 && queue.Attributes().AutoDelete {
+				proc.deleteQueue(queue.Destination(), QueueAutoDeleted)
 			} else {
-				topic := proc.tm.Find(destination)
-				topic.Enqueue(r.Frame)
+				proc.updateQueueDemand(queue)
 			}
+		} else {
+			proc.unsubscribeTopic(r.Sub)
+		}
+
+	case client.DetachOp:
+		if isQueueDestination(r.Sub.Destination()) {
+			queue := proc.qm.Find(r.Sub.Destination())
+			queue.Detach(r.Sub)
+			proc.updateQueueDemand(queue)
+		} else {
+			proc.unsubscribeTopic(r.Sub)
+		}
 
-		case client.RequeueOp:
-			destination, ok := r.Frame.Header.Contains(frame.Destination)
-			if !ok {
-				// should not happen, already checked in lower layer
-				panic("missing destination")
+	case client.ResumeOp:
+		// topic subscriptions are subscribed again with SubscribeOp
+		if isQueueDestination(r.Sub.Destination()) {
+			queue := proc.qm.Find(r.Sub.Destination())
+			queue.Reattach(r.Sub, r.Frame != nil)
+			proc.updateQueueDemand(queue)
+		}
+
+	case client.ExpireOp:
+		// the queue might have been deleted in the meantime
+		if isQueueDestination(r.Sub.Destination()) {
+			if queue := proc.qm.Lookup(r.Sub.Destination()); queue != nil {
+				consumers := queue.Consumers()
+				queue.Release()
+				if consumers > 0 && queue.Consumers() == 0 && queue.Attributes().AutoDelete {
+					proc.deleteQueue(queue.Destination(), QueueAutoDeleted)
+				} else {
+					proc.updateQueueDemand(queue)
+				}
 			}
+		}
+
+	case client.EnqueueOp:
+		destination, ok := r.Frame.Header.Contains(frame.Destination)
+		if !ok {
+			// should not happen, already checked in lower layer
+			panic("missing destination")
+		}
 
-			// only requeue to queues, should never happen for topics
-			if isQueueDestination(destination) {
-				queue := proc.qm.Find(destination)
+		if isQueueDestination(destination) {
+			queue := proc.qm.Find(destination)
+			queue.Enqueue(r.Frame)
+			proc.updateQueueDemand(queue)
+		} else {
+			// the topic and any wildcard topics that match it each
+			// receive a copy, except the last, which has the frame
+			topics := proc.tm.Matching(destination)
+			for i, topic := range topics {
+				if i == len(topics)-1 {
+					topic.Enqueue(r.Frame)
+				} else {
+					topic.Enqueue(r.Frame.Clone())
+				}
+			}
+		}
+
+	case client.RequeueOp:
+		destination, ok := r.Frame.Header.Contains(frame.Destination)
+		if !ok {
+			// should not happen, already checked in lower layer
+			panic("missing destination")
+		}
+
+		// only requeue to queues, should never happen for topics
+		if isQueueDestination(destination) {
+			// the frame is discarded if its queue has been deleted
+			if queue := proc.qm.Lookup(destination); queue != nil {
 				queue.Requeue(r.Frame)
+				proc.updateQueueDemand(queue)
 			}
 		}
+
+	case client.ConnectedOp:
+		proc.conns[r.Conn] = true
+
+	case client.DisconnectedOp:
+		delete(proc.conns, r.Conn)
+	}
+
+	proc.publishNotifications()
+}
+
+// Called when a queue discards an expired frame.
+func (proc *requestProcessor) expiredFrame(f *frame.Frame) {
+	if n := client.Notification(f, client.AckOutcomeExpired, ""); n != nil {
+		proc.notifications = append(proc.notifications, n)
+	}
+}
+
+// Publish the notifications for the expired frames
+// discarded while handling a request.
+func (proc *requestProcessor) publishNotifications() {
+	for len(proc.notifications) > 0 {
+		n := proc.notifications[0]
+		proc.notifications = proc.notifications[1:]
+		proc.handleRequest(client.Request{Op: client.EnqueueOp, Frame: n})
 	}
-	// this is no longer required for go 1.1
-	panic("not reached")
+}
+
+// Run fn on the processor go-routine, and wait for it to complete.
+func (proc *requestProcessor) control(fn func() error) error {
+	ch := make(chan error, 1)
+	proc.ctl <- func() {
+		err := fn()
+		proc.publishNotifications()
+		ch <- err
+	}
+	return <-ch
+}
+
+func (proc *requestProcessor) pause(destination string) error {
+	proc.pauseDestination(destination)
+	if ps, ok := proc.server.QueueStorage.(PauseStorage); ok {
+		return ps.SetPaused(destination, true)
+	}
+	return nil
+}
+
+func (proc *requestProcessor) resume(destination string) error {
+	if isQueueDestination(destination) {
+		queue := proc.qm.Find(destination)
+		err := queue.Resume()
+		proc.updateQueueDemand(queue)
+		if err != nil {
+			return err
+		}
+	} else {
+		proc.tm.Find(destination).Resume()
+	}
+	if ps, ok := proc.server.QueueStorage.(PauseStorage); ok {
+		return ps.SetPaused(destination, false)
+	}
+	return nil
+}
+
+// Pause the destinations recorded as paused by the queue storage.
+func (proc *requestProcessor) restorePaused() error {
+	ps, ok := proc.server.QueueStorage.(PauseStorage)
+	if !ok {
+		return nil
+	}
+	destinations, err := ps.PausedDestinations()
+	if err != nil {
+		return err
+	}
+	for _, destination := range destinations {
+		proc.pauseDestination(destination)
+	}
+	return nil
+}
+
+func (proc *requestProcessor) pauseDestination(destination string) {
+	if isQueueDestination(destination) {
+		queue := proc.qm.Find(destination)
+		queue.Pause()
+		proc.updateQueueDemand(queue)
+	} else {
+		proc.tm.Find(destination).Pause(proc.server.PausedTopicBuffer)
+	}
+}
+
+// Subscribe to a topic, recording the demand for the topic if
+// the broker is federated.
+func (proc *requestProcessor) subscribeTopic(sub *client.Subscription) {
+	topic := proc.tm.Find(sub.Destination())
+	if proc.fed == nil {
+		topic.Subscribe(sub)
+		return
+	}
+
+	broker := sub.Header().Get(FederationBrokerHeader)
+	if broker == "" {
+		topic.Subscribe(sub)
+	} else {
+		fs := &federatedSubscription{
+			sub:     sub,
+			broker:  broker,
+			maxHops: proc.server.Federation.maxHops(),
+		}
+		proc.fedSubs[sub] = fs
+		topic.Subscribe(fs)
+	}
+	proc.fed.Subscribe(sub.Destination(), broker)
+}
+
+func (proc *requestProcessor) unsubscribeTopic(sub *client.Subscription) {
+	topic := proc.tm.Find(sub.Destination())
+	if proc.fed == nil {
+		topic.Unsubscribe(sub)
+		return
+	}
+
+	if fs, ok := proc.fedSubs[sub]; ok {
+		delete(proc.fedSubs, sub)
+		topic.Unsubscribe(fs)
+		proc.fed.Unsubscribe(sub.Destination(), fs.broker)
+	} else {
+		topic.Unsubscribe(sub)
+		proc.fed.Unsubscribe(sub.Destination(), "")
+	}
+}
+
+// Record whether the queue has local subscriptions, if the
+// broker pulls messages from peers.
+func (proc *requestProcessor) updateQueueDemand(queue *queue.Queue) {
+	if proc.fed == nil || !proc.server.Federation.Queues {
+		return
+	}
+	proc.fed.SetQueueDemand(queue.Destination(), !queue.Paused() && queue.LocalConsumers() > 0)
+}
+
+// Enqueue a message pulled from a queue at a peer broker. Runs on the
+// processor go-routine, and returns once the message has been stored
+// by the queue storage, or sent to a subscription, so that the link
+// only acknowledges the message at the peer after that.
+func (proc *requestProcessor) enqueueFederated(f *frame.Frame) error {
+	return proc.control(func() error {
+		queue := proc.qm.Find(f.Header.Get(frame.Destination))
+		err := queue.Enqueue(f)
+		proc.updateQueueDemand(queue)
+		return err
+	})
 }
 
 func isQueueDestination(dest string) bool {
@@ -100,7 +397,9 @@ func isQueueDestination(dest string) bool {
 }
 
 func (proc *requestProcessor) Listen(l net.Listener) {
+	defer close(proc.done)
 	config := newConfig(proc.server)
+	config.sessions = proc.sessions
 	timeout := time.Duration(0) // how long to sleep on accept failure
 	for {
 		rw, err := l.Accept()
@@ -130,7 +429,8 @@ func (proc *requestProcessor) Listen(l net.Listener) {
 }
 
 type config struct {
-	server *Server
+	server   *Server
+	sessions *client.SessionStore
 }
 
 func newConfig(s *Server) *config {
@@ -156,3 +456,40 @@ func (c *config) Authenticate(login, passcode string) bool {
 func (c *config) Logger() stomp.Logger {
 	return c.server.Log
 }
+
+func (c *config) Compression() bool {
+	return c.server.Compression
+}
+
+func (c *config) Tracer() client.Tracer {
+	return &c.server.tracer
+}
+
+func (c *config) TransactionLimits() client.TransactionLimits {
+	return c.server.TransactionLimits
+}
+
+func (c *config) SpanExporter() client.SpanExporter {
+	return c.server.SpanExporter
+}
+
+func (c *config) NackLimit() int {
+	return c.server.NackLimit
+}
+
+func (c *config) Sessions() *client.SessionStore {
+	return c.sessions
+}
+
+func (c *config) BrokerId() string {
+	if c.server.Federation != nil {
+		return c.server.Federation.BrokerId
+	}
+	return ""
+}
+
+func (c *config) FederationPeer(login string) bool {
+	// the login is only trusted if the client has been authenticated
+	fed := c.server.Federation
+	return fed != nil && fed.Login != "" && c.server.Authenticator != nil && login == fed.Login
+}
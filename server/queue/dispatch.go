@@ -0,0 +1,131 @@
+package queue
+
+import (
+	"math/rand"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/go-stomp/stomp/v3/server/client"
+)
+
+// Consumer is a subscription that is ready to receive a frame
+// from a queue, as presented to a DispatchPolicy.
+type Consumer struct {
+	Sub *client.Subscription
+
+	// Number of frames from the queue that have been sent to the
+	// connection of the subscription and not yet acknowledged.
+	InFlight int
+}
+
+// DispatchPolicy chooses which of the subscriptions that are ready to
+// receive a frame is sent the next frame from a queue.
+type DispatchPolicy interface {
+	// Choose returns the index of the consumer that is sent the
+	// frame f. Consumers are in the order that they became ready,
+	// and there is always at least one consumer.
+	Choose(f *frame.Frame, consumers []Consumer) int
+}
+
+// FirstReady sends each frame to the subscription that has been
+// ready for the longest time. This is the default policy.
+var FirstReady DispatchPolicy = firstReady{}
+
+// LeastOutstanding sends each frame to the subscription whose connection
+// has the fewest frames in flight. Ties are broken in favour of the
+// subscription that has been ready for the longest time.
+var LeastOutstanding DispatchPolicy = leastOutstanding{}
+
+// Random sends each frame to a randomly chosen subscription.
+var Random DispatchPolicy = Weighted(nil)
+
+type firstReady struct{}
+
+func (firstReady) Choose(f *frame.Frame, consumers []Consumer) int {
+	return 0
+}
+
+type leastOutstanding struct{}
+
+func (leastOutstanding) Choose(f *frame.Frame, consumers []Consumer) int {
+	chosen := 0
+	for i, c := range consumers {
+		if c.InFlight < consumers[chosen].InFlight {
+			chosen = i
+		}
+	}
+	return chosen
+}
+
+// Weighted returns a policy that sends each frame to a randomly chosen
+// subscription, in proportion to the weight of the subscription. A
+// subscription with a weight less than one is only chosen when no other
+// subscription has a positive weight. If weight is nil, every subscription
+// has a weight of one.
+func Weighted(weight func(sub *client.Subscription) int) DispatchPolicy {
+	return weighted{weight: weight}
+}
+
+type weighted struct {
+	weight func(sub *client.Subscription) int
+}
+
+func (w weighted) Choose(f *frame.Frame, consumers []Consumer) int {
+	if w.weight == nil {
+		return rand.Intn(len(consumers))
+	}
+
+	weights := make([]int, len(consumers))
+	total := 0
+	for i, c := range consumers {
+		if weight := w.weight(c.Sub); weight > 0 {
+			weights[i] = weight
+			total += weight
+		}
+	}
+	if total == 0 {
+		return rand.Intn(len(consumers))
+	}
+
+	n := rand.Intn(total)
+	for i, weight := range weights {
+		if n < weight {
+			return i
+		}
+		n -= weight
+	}
+
+	// should not get here
+	return len(consumers) - 1
+}
+
+// Preferred returns a policy that sends each frame to one of the
+// subscriptions for which prefer returns true, such as subscriptions
+// from clients in the same location as the server. If there are none,
+// the frame is sent to one of the other subscriptions. In either case,
+// next chooses among the candidates. If next is nil, FirstReady is used.
+func Preferred(prefer func(sub *client.Subscription) bool, next DispatchPolicy) DispatchPolicy {
+	if next == nil {
+		next = FirstReady
+	}
+	return preferred{prefer: prefer, next: next}
+}
+
+type preferred struct {
+	prefer func(sub *client.Subscription) bool
+	next   DispatchPolicy
+}
+
+func (p preferred) Choose(f *frame.Frame, consumers []Consumer) int {
+	var candidates []Consumer
+	var indexes []int
+	for i, c := range consumers {
+		if p.prefer(c.Sub) {
+			candidates = append(candidates, c)
+			indexes = append(indexes, i)
+		}
+	}
+	if len(candidates) == 0 {
+		return p.next.Choose(f, consumers)
+	}
+	return indexes[p.next.Choose(f, candidates)]
+}
@@ -0,0 +1,86 @@
+package queue
+
+import (
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/go-stomp/stomp/v3/server/client"
+	. "gopkg.in/check.v1"
+)
+
+type DispatchSuite struct{}
+
+var _ = Suite(&DispatchSuite{})
+
+func newConsumers(inFlight ...int) []Consumer {
+	consumers := make([]Consumer, len(inFlight))
+	for i, n := range inFlight {
+		consumers[i] = Consumer{Sub: &client.Subscription{}, InFlight: n}
+	}
+	return consumers
+}
+
+func (s *DispatchSuite) TestFirstReady(c *C) {
+	f := frame.New(frame.MESSAGE)
+	c.Check(FirstReady.Choose(f, newConsumers(3, 0, 1)), Equals, 0)
+}
+
+func (s *DispatchSuite) TestLeastOutstanding(c *C) {
+	f := frame.New(frame.MESSAGE)
+	c.Check(LeastOutstanding.Choose(f, newConsumers(3, 0, 1)), Equals, 1)
+	c.Check(LeastOutstanding.Choose(f, newConsumers(2, 1, 1)), Equals, 1)
+	c.Check(LeastOutstanding.Choose(f, newConsumers(0)), Equals, 0)
+}
+
+func (s *DispatchSuite) TestRandom(c *C) {
+	f := frame.New(frame.MESSAGE)
+	consumers := newConsumers(0, 0, 0)
+	chosen := make(map[int]bool)
+	for i := 0; i < 1000; i++ {
+		n := Random.Choose(f, consumers)
+		c.Assert(n >= 0 && n < len(consumers), Equals, true)
+		chosen[n] = true
+	}
+	c.Check(chosen, HasLen, 3)
+}
+
+func (s *DispatchSuite) TestWeighted(c *C) {
+	f := frame.New(frame.MESSAGE)
+	consumers := newConsumers(0, 0, 0)
+	weights := map[*client.Subscription]int{
+		consumers[0].Sub: 0,
+		consumers[1].Sub: 1,
+		consumers[2].Sub: 3,
+	}
+	policy := Weighted(func(sub *client.Subscription) int {
+		return weights[sub]
+	})
+
+	counts := make([]int, len(consumers))
+	for i := 0; i < 4000; i++ {
+		counts[policy.Choose(f, consumers)]++
+	}
+	c.Check(counts[0], Equals, 0)
+	c.Check(counts[1] > 700 && counts[1] < 1300, Equals, true)
+	c.Check(counts[2] > 2700 && counts[2] < 3300, Equals, true)
+
+	// a subscription without weight is chosen if there is no other
+	c.Check(policy.Choose(f, consumers[:1]), Equals, 0)
+}
+
+func (s *DispatchSuite) TestPreferred(c *C) {
+	f := frame.New(frame.MESSAGE)
+	consumers := newConsumers(4, 2, 3, 1)
+	local := map[*client.Subscription]bool{
+		consumers[0].Sub: true,
+		consumers[2].Sub: true,
+	}
+	prefer := func(sub *client.Subscription) bool {
+		return local[sub]
+	}
+
+	c.Check(Preferred(prefer, nil).Choose(f, consumers), Equals, 0)
+	c.Check(Preferred(prefer, LeastOutstanding).Choose(f, consumers), Equals, 2)
+
+	// no preferred subscription is ready
+	c.Check(Preferred(prefer, LeastOutstanding).Choose(f, consumers[1:2]), Equals, 0)
+	c.Check(Preferred(prefer, LeastOutstanding).Choose(f, consumers[3:]), Equals, 0)
+}
@@ -1,9 +1,18 @@
 package queue
 
+import (
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+)
+
 // Queue manager.
 type Manager struct {
-	qstore Storage // handles queue storage
-	queues map[string]*Queue
+	qstore  Storage // handles queue storage
+	queues  map[string]*Queue
+	policy  func(destination string) DispatchPolicy // may be nil
+	expired func(f *frame.Frame)                    // may be nil
+	remote  Remote                                  // may be nil
 }
 
 // Create a queue manager with the specified queue storage mechanism
@@ -17,7 +26,74 @@ func (qm *Manager) Find(destination string) *Queue {
 	q, ok := qm.queues[destination]
 	if !ok {
 		q = newQueue(destination, qm.qstore)
+		if qm.policy != nil {
+			q.SetDispatchPolicy(qm.policy(destination))
+		}
+		q.expired = qm.expired
+		q.remote = qm.remote
 		qm.queues[destination] = q
 	}
 	return q
 }
+
+// Lookup returns the queue for the given destination,
+// or nil if it has not been created.
+func (qm *Manager) Lookup(destination string) *Queue {
+	return qm.queues[destination]
+}
+
+// Delete the queue for the given destination, and discard the frames
+// in its storage. Returns the number of frames discarded.
+func (qm *Manager) Delete(destination string) (int, error) {
+	delete(qm.queues, destination)
+	discarded := 0
+	for {
+		f, err := qm.qstore.Dequeue(destination)
+		if err != nil || f == nil {
+			return discarded, err
+		}
+		discarded++
+	}
+}
+
+// Expired returns the queues that have expired at time now.
+func (qm *Manager) Expired(now time.Time) []*Queue {
+	var expired []*Queue
+	for _, q := range qm.queues {
+		if q.Expired(now) {
+			expired = append(expired, q)
+		}
+	}
+	return expired
+}
+
+// Queues returns all of the queues that have been created.
+func (qm *Manager) Queues() []*Queue {
+	queues := make([]*Queue, 0, len(qm.queues))
+	for _, q := range qm.queues {
+		queues = append(queues, q)
+	}
+	return queues
+}
+
+// SetDispatchPolicy sets the function that returns the dispatch policy
+// of each queue when it is created. The function can return nil for
+// queues that use the default policy.
+func (qm *Manager) SetDispatchPolicy(policy func(destination string) DispatchPolicy) {
+	qm.policy = policy
+}
+
+// SetExpiredFunc sets the function that is called with each expired
+// frame that a queue discards.
+func (qm *Manager) SetExpiredFunc(expired func(f *frame.Frame)) {
+	qm.expired = expired
+}
+
+// SetRemote sets the Remote that identifies the subscriptions
+// to each queue that forward frames to other brokers.
+func (qm *Manager) SetRemote(remote Remote) {
+	qm.remote = remote
+	for _, q := range qm.queues {
+		q.remote = remote
+	}
+}
@@ -0,0 +1,273 @@
+package queue
+
+import (
+	"container/list"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+
+	"github.com/go-stomp/stomp/v3/frame"
+)
+
+// Default number of bytes written to a page file
+// before a queue starts a new page file.
+const defaultPageFileSize = 4 * 1024 * 1024
+
+// Paging implementation of the QueueStorage interface. Frames are kept
+// in memory until a queue exceeds its memory watermark, or all queues
+// together exceed the global memory watermark. Beyond the watermark,
+// frames are spilled to temporary files for the queue, and paged back
+// into memory in order as the frames in memory are dequeued.
+//
+// A queue starts a new page file whenever its current page file has grown
+// beyond a fixed size, and removes each page file once all of its frames
+// have been read, so the disk space used by a queue that never drains
+// completely is bounded by the frames it holds. Only the current page
+// file is kept open for writing.
+//
+// Requeued frames are always kept in memory at the head of the queue,
+// so that they are delivered before any other frames in the queue.
+type PagingQueueStorage struct {
+	dir             string // directory for temporary files
+	queueWatermark  int    // maximum bytes in memory per queue, zero for no limit
+	globalWatermark int    // maximum bytes in memory for all queues, zero for no limit
+	pageFileSize    int64  // bytes written to a page file before the next one is started
+	queues          map[string]*pagedQueue
+	memory          int // bytes in memory for all queues
+}
+
+// A pagedQueue contains the frames in memory, followed by the
+// frames in its page files.
+type pagedQueue struct {
+	frames *list.List // frames in memory
+	memory int        // bytes in memory
+	files  *list.List // page files, frames are read from the first and appended to the last
+	paged  int        // number of frames in the page files not yet read
+}
+
+// A pageFile contains some of the frames paged by a queue.
+type pageFile struct {
+	name   string        // name of the page file
+	file   *os.File      // page file opened for writing, nil once the next page file is started
+	writer *frame.Writer // appends frames to the page file
+	input  *os.File      // page file opened for reading
+	reader *frame.Reader // reads frames from the start of the page file
+	size   int64         // number of bytes written
+	unread int           // number of frames not yet read
+}
+
+// NewPagingQueueStorage creates a queue storage that spills frames to
+// temporary files in dir once the queue watermark or global watermark,
+// in bytes, is exceeded. If dir is empty, the default directory for
+// temporary files is used. A watermark of zero means no limit.
+func NewPagingQueueStorage(dir string, queueWatermark, globalWatermark int) Storage {
+	return &PagingQueueStorage{
+		dir:             dir,
+		queueWatermark:  queueWatermark,
+		globalWatermark: globalWatermark,
+		pageFileSize:    defaultPageFileSize,
+		queues:          make(map[string]*pagedQueue),
+	}
+}
+
+func (m *PagingQueueStorage) queue(queue string) *pagedQueue {
+	q, ok := m.queues[queue]
+	if !ok {
+		q = &pagedQueue{frames: list.New(), files: list.New()}
+		m.queues[queue] = q
+	}
+	return q
+}
+
+// Returns true if a frame of the given size fits in memory.
+func (m *PagingQueueStorage) fits(q *pagedQueue, size int) bool {
+	if m.queueWatermark > 0 && q.memory+size > m.queueWatermark {
+		return false
+	}
+	if m.globalWatermark > 0 && m.memory+size > m.globalWatermark {
+		return false
+	}
+	return true
+}
+
+// Pushes a frame to the end of the queue. If any frames in the queue
+// have already been paged, the frame is paged too, so that the order
+// of the frames is preserved.
+func (m *PagingQueueStorage) Enqueue(queue string, f *frame.Frame) error {
+	q := m.queue(queue)
+	size := f.Size()
+	if q.paged == 0 && m.fits(q, size) {
+		q.frames.PushBack(f)
+		q.memory += size
+		m.memory += size
+		return nil
+	}
+	return m.page(q, f)
+}
+
+// Pushes a frame to the head of the queue. The frame is kept in memory,
+// even if the watermark is exceeded.
+func (m *PagingQueueStorage) Requeue(queue string, f *frame.Frame) error {
+	q := m.queue(queue)
+	size := f.Size()
+	q.frames.PushFront(f)
+	q.memory += size
+	m.memory += size
+	return nil
+}
+
+// Removes a frame from the head of the queue.
+// Returns nil if no frame is available.
+func (m *PagingQueueStorage) Dequeue(queue string) (*frame.Frame, error) {
+	q, ok := m.queues[queue]
+	if !ok {
+		return nil, nil
+	}
+
+	if q.frames.Len() == 0 && q.paged > 0 {
+		if err := m.pageIn(q); err != nil {
+			return nil, err
+		}
+	}
+
+	element := q.frames.Front()
+	if element == nil {
+		return nil, nil
+	}
+
+	f := q.frames.Remove(element).(*frame.Frame)
+	size := f.Size()
+	q.memory -= size
+	m.memory -= size
+	return f, nil
+}
+
+// Appends a frame to the last page file of the queue, starting
+// a new page file if necessary.
+func (m *PagingQueueStorage) page(q *pagedQueue, f *frame.Frame) error {
+	var pf *pageFile
+	if e := q.files.Back(); e != nil {
+		pf = e.Value.(*pageFile)
+	}
+	if pf == nil || pf.size >= m.pageFileSize {
+		if pf != nil {
+			// no more frames are written to the page file
+			pf.closeWriter()
+		}
+		var err error
+		if pf, err = m.newPageFile(); err != nil {
+			return err
+		}
+		q.files.PushBack(pf)
+	}
+
+	// the body is read back using the content length,
+	// because it might contain null bytes
+	if _, ok := f.Header.Contains(frame.ContentLength); !ok {
+		f.Header.Set(frame.ContentLength, strconv.Itoa(len(f.Body)))
+	}
+
+	if err := pf.writer.Write(f); err != nil {
+		return err
+	}
+	size, err := pf.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	pf.size = size
+	pf.unread++
+	q.paged++
+	return nil
+}
+
+func (m *PagingQueueStorage) newPageFile() (*pageFile, error) {
+	file, err := ioutil.TempFile(m.dir, "stomp-queue-")
+	if err != nil {
+		return nil, err
+	}
+	input, err := os.Open(file.Name())
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+	return &pageFile{
+		name:   file.Name(),
+		file:   file,
+		writer: frame.NewWriter(file),
+		input:  input,
+		reader: frame.NewReader(input),
+	}, nil
+}
+
+// Reads frames from the page files of the queue into memory, until the
+// watermark is reached or there are no more frames in the files. At least
+// one frame is read. Each page file is removed after its last frame is read.
+func (m *PagingQueueStorage) pageIn(q *pagedQueue) error {
+	for q.paged > 0 {
+		e := q.files.Front()
+		pf := e.Value.(*pageFile)
+		f, err := pf.reader.Read()
+		if err != nil {
+			return err
+		}
+		if f == nil {
+			// page files do not contain heart-beats
+			continue
+		}
+		size := f.Size()
+		q.frames.PushBack(f)
+		q.memory += size
+		m.memory += size
+		q.paged--
+
+		if pf.unread--; pf.unread == 0 {
+			pf.remove()
+			q.files.Remove(e)
+		}
+
+		if !m.fits(q, 0) {
+			break
+		}
+	}
+	return nil
+}
+
+func (q *pagedQueue) removeFiles() {
+	for e := q.files.Front(); e != nil; e = e.Next() {
+		e.Value.(*pageFile).remove()
+	}
+	q.files.Init()
+	q.paged = 0
+}
+
+func (pf *pageFile) closeWriter() {
+	if pf.file != nil {
+		pf.file.Close()
+		pf.file = nil
+		pf.writer = nil
+	}
+}
+
+func (pf *pageFile) remove() {
+	pf.input.Close()
+	pf.closeWriter()
+	os.Remove(pf.name)
+}
+
+// Called at server startup. Allows the queue storage
+// to perform any initialization.
+func (m *PagingQueueStorage) Start() {
+	m.queues = make(map[string]*pagedQueue)
+	m.memory = 0
+}
+
+// Called prior to server shutdown. Removes any page files.
+func (m *PagingQueueStorage) Stop() {
+	for _, q := range m.queues {
+		q.removeFiles()
+	}
+	m.queues = nil
+	m.memory = 0
+}
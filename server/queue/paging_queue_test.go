@@ -0,0 +1,145 @@
+package queue
+
+import (
+	"io/ioutil"
+	"strconv"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	. "gopkg.in/check.v1"
+)
+
+type PagingQueueSuite struct{}
+
+var _ = Suite(&PagingQueueSuite{})
+
+func newMessage(destination string, n int) *frame.Frame {
+	f := frame.New(frame.MESSAGE,
+		frame.Destination, destination,
+		frame.MessageId, "msg-"+strconv.Itoa(n))
+	f.Body = []byte("body\x00" + strconv.Itoa(n))
+	return f
+}
+
+func checkDequeue(c *C, s Storage, queue string, n int) {
+	f, err := s.Dequeue(queue)
+	c.Assert(err, IsNil)
+	c.Assert(f, NotNil)
+	c.Check(f.Header.Get(frame.MessageId), Equals, "msg-"+strconv.Itoa(n))
+	c.Check(string(f.Body), Equals, "body\x00"+strconv.Itoa(n))
+}
+
+func pageFiles(c *C, dir string) int {
+	files, err := ioutil.ReadDir(dir)
+	c.Assert(err, IsNil)
+	return len(files)
+}
+
+func (s *PagingQueueSuite) TestOrder(c *C) {
+	dir := c.MkDir()
+	size := newMessage("/queue/test", 0).Size()
+	pq := NewPagingQueueStorage(dir, size*3, 0)
+
+	for i := 0; i < 10; i++ {
+		c.Assert(pq.Enqueue("/queue/test", newMessage("/queue/test", i)), IsNil)
+	}
+	c.Check(pageFiles(c, dir), Equals, 1)
+
+	for i := 0; i < 5; i++ {
+		checkDequeue(c, pq, "/queue/test", i)
+	}
+
+	// frames enqueued while frames are paged are appended to the page file
+	c.Assert(pq.Enqueue("/queue/test", newMessage("/queue/test", 10)), IsNil)
+
+	for i := 5; i <= 10; i++ {
+		checkDequeue(c, pq, "/queue/test", i)
+	}
+	f, err := pq.Dequeue("/queue/test")
+	c.Check(err, IsNil)
+	c.Check(f, IsNil)
+
+	// the page file is removed once it has been read
+	c.Check(pageFiles(c, dir), Equals, 0)
+}
+
+func (s *PagingQueueSuite) TestRotate(c *C) {
+	dir := c.MkDir()
+	size := newMessage("/queue/test", 0).Size()
+	pq := NewPagingQueueStorage(dir, size, 0)
+	pq.(*PagingQueueStorage).pageFileSize = 1 // one frame per page file
+
+	for i := 0; i < 5; i++ {
+		c.Assert(pq.Enqueue("/queue/test", newMessage("/queue/test", i)), IsNil)
+	}
+	c.Check(pageFiles(c, dir), Equals, 4)
+
+	// only the last page file is kept open for writing
+	files := pq.(*PagingQueueStorage).queues["/queue/test"].files
+	for e := files.Front(); e != nil; e = e.Next() {
+		c.Check(e.Value.(*pageFile).file == nil, Equals, e.Next() != nil)
+	}
+
+	// the page files that have been read are removed,
+	// even though the queue never drains completely
+	for i := 0; i < 100; i++ {
+		c.Assert(pq.Enqueue("/queue/test", newMessage("/queue/test", i+5)), IsNil)
+		checkDequeue(c, pq, "/queue/test", i)
+		c.Assert(pageFiles(c, dir) <= 5, Equals, true)
+	}
+
+	for i := 100; i < 105; i++ {
+		checkDequeue(c, pq, "/queue/test", i)
+	}
+	c.Check(pageFiles(c, dir), Equals, 0)
+}
+
+func (s *PagingQueueSuite) TestRequeue(c *C) {
+	dir := c.MkDir()
+	size := newMessage("/queue/test", 0).Size()
+	pq := NewPagingQueueStorage(dir, size, 0)
+
+	for i := 1; i <= 3; i++ {
+		c.Assert(pq.Enqueue("/queue/test", newMessage("/queue/test", i)), IsNil)
+	}
+	checkDequeue(c, pq, "/queue/test", 1)
+	checkDequeue(c, pq, "/queue/test", 2)
+
+	// a requeued frame goes to the head of the queue,
+	// ahead of the frames that have been paged
+	c.Assert(pq.Requeue("/queue/test", newMessage("/queue/test", 2)), IsNil)
+	c.Assert(pq.Requeue("/queue/test", newMessage("/queue/test", 1)), IsNil)
+	c.Assert(pq.Enqueue("/queue/test", newMessage("/queue/test", 4)), IsNil)
+
+	for i := 1; i <= 4; i++ {
+		checkDequeue(c, pq, "/queue/test", i)
+	}
+}
+
+func (s *PagingQueueSuite) TestGlobalWatermark(c *C) {
+	dir := c.MkDir()
+	size := newMessage("/queue/a", 0).Size()
+	pq := NewPagingQueueStorage(dir, 0, size*2)
+
+	c.Assert(pq.Enqueue("/queue/a", newMessage("/queue/a", 1)), IsNil)
+	c.Assert(pq.Enqueue("/queue/b", newMessage("/queue/b", 1)), IsNil)
+	c.Check(pageFiles(c, dir), Equals, 0)
+
+	// both queues are now paged, because memory is shared
+	c.Assert(pq.Enqueue("/queue/a", newMessage("/queue/a", 2)), IsNil)
+	c.Assert(pq.Enqueue("/queue/b", newMessage("/queue/b", 2)), IsNil)
+	c.Check(pageFiles(c, dir), Equals, 2)
+
+	checkDequeue(c, pq, "/queue/a", 1)
+	checkDequeue(c, pq, "/queue/a", 2)
+	checkDequeue(c, pq, "/queue/b", 1)
+	checkDequeue(c, pq, "/queue/b", 2)
+	c.Check(pageFiles(c, dir), Equals, 0)
+
+	// Stop removes page files
+	c.Assert(pq.Enqueue("/queue/a", newMessage("/queue/a", 3)), IsNil)
+	c.Assert(pq.Enqueue("/queue/a", newMessage("/queue/a", 4)), IsNil)
+	c.Assert(pq.Enqueue("/queue/a", newMessage("/queue/a", 5)), IsNil)
+	c.Check(pageFiles(c, dir), Equals, 1)
+	pq.Stop()
+	c.Check(pageFiles(c, dir), Equals, 0)
+}
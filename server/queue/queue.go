@@ -4,15 +4,48 @@ Package queue provides implementations of server-side queues.
 package queue
 
 import (
+	"strconv"
+	"time"
+
 	"github.com/go-stomp/stomp/v3/frame"
 	"github.com/go-stomp/stomp/v3/server/client"
 )
 
+// MessageExpiresHeader is the header entry that contains the time at
+// which a message expires, in milliseconds since the Unix epoch. A value
+// of zero means that the message does not expire. Expired messages are
+// discarded instead of being sent to a subscription.
+const MessageExpiresHeader = "expires"
+
+// Attributes control the lifetime of a queue.
+type Attributes struct {
+	// Delete the queue, and discard its frames, when
+	// its last subscription is unsubscribed.
+	AutoDelete bool
+
+	// Delete the queue after it has had no subscriptions and no
+	// activity for this long. If zero, the queue does not expire.
+	Expires time.Duration
+}
+
 // Queue for storing message frames.
 type Queue struct {
 	destination string
 	qstore      Storage
 	subs        *client.SubscriptionList
+	policy      DispatchPolicy       // nil if frames are sent to the first ready subscription
+	remote      Remote               // nil if there are no remote subscriptions
+	expired     func(f *frame.Frame) // called for expired frames, may be nil
+	attrs       Attributes
+	paused      bool      // frames are not dispatched while paused
+	detached    int       // subscriptions kept for detached sessions
+	pending     int       // number of frames added to storage by this queue
+	lastUsed    time.Time // time of the last activity on the queue
+
+	// subscriptions that have been sent a frame and have not been
+	// re-added, and the number of them for each connection
+	dispatched map[*client.Subscription]bool
+	inFlight   map[*client.Conn]int
 }
 
 // Create a new queue -- called from the queue manager only.
@@ -21,48 +54,92 @@ func newQueue(destination string, qstore Storage) *Queue {
 		destination: destination,
 		qstore:      qstore,
 		subs:        client.NewSubscriptionList(),
+		dispatched:  make(map[*client.Subscription]bool),
+		inFlight:    make(map[*client.Conn]int),
+		lastUsed:    time.Now(),
 	}
 }
 
+// Destination returns the destination of the queue.
+func (q *Queue) Destination() string {
+	return q.destination
+}
+
 // Add a subscription to a queue. The subscription is removed
 // whenever a frame is sent to the subscription and needs to
 // be re-added when the subscription decides that the message
 // has been received by the client.
 func (q *Queue) Subscribe(sub *client.Subscription) error {
-	// see if there is a frame available for this subscription
-	f, err := q.qstore.Dequeue(sub.Destination())
-	if err != nil {
-		return err
-	}
-	if f == nil {
-		// no frame available, so add to the subscription list
-		q.subs.Add(sub)
-	} else {
-		// a frame is available, so send straight away without
-		// adding the subscription to the list
-		sub.SendQueueFrame(f)
+	q.lastUsed = time.Now()
+	q.returned(sub)
+
+	q.subs.Add(sub)
+	if q.paused {
+		// no frames are dispatched until the queue is resumed
+		return nil
 	}
-	return nil
+
+	// frames waiting in the queue are sent to the ready subscriptions
+	// as chosen by the dispatch policy, the same as new frames
+	return q.dispatch()
 }
 
 // Unsubscribe a subscription.
 func (q *Queue) Unsubscribe(sub *client.Subscription) {
+	q.lastUsed = time.Now()
+	q.returned(sub)
 	q.subs.Remove(sub)
 }
 
+// Detach a subscription whose session has been detached from its
+// connection. The subscription is unsubscribed, but is still counted
+// as a consumer until Reattach or Release is called for it.
+func (q *Queue) Detach(sub *client.Subscription) {
+	q.Unsubscribe(sub)
+	q.detached++
+}
+
+// Reattach a subscription of a resumed session, which was detached.
+// If busy is true, the subscription has a frame that has not been
+// acknowledged, and is ready again once it is re-added.
+func (q *Queue) Reattach(sub *client.Subscription, busy bool) {
+	q.lastUsed = time.Now()
+	q.Release()
+	if busy && !q.dispatched[sub] {
+		q.dispatched[sub] = true
+		q.inFlight[sub.Conn()]++
+	}
+}
+
+// Release a subscription that was detached, and is
+// no longer counted as a consumer.
+func (q *Queue) Release() {
+	if q.detached > 0 {
+		q.detached--
+	}
+}
+
 // Send a message to the queue. If a subscription is available
 // to receive the message, it is sent to the subscription without
 // making it to the queue. Otherwise, the message is queued until
 // a message is available.
 func (q *Queue) Enqueue(f *frame.Frame) error {
+	q.lastUsed = time.Now()
+	if q.discardExpired(f, q.lastUsed) {
+		return nil
+	}
+
 	// find a subscription ready to receive the frame
-	sub := q.subs.Get()
+	sub := q.get(f)
 	if sub == nil {
 		// no subscription available, add to the queue
-		return q.qstore.Enqueue(q.destination, f)
+		if err := q.qstore.Enqueue(q.destination, f); err != nil {
+			return err
+		}
+		q.pending++
 	} else {
 		// subscription is available, send it now without adding to queue
-		sub.SendQueueFrame(f)
+		q.send(sub, f)
 	}
 	return nil
 }
@@ -73,14 +150,229 @@ func (q *Queue) Enqueue(f *frame.Frame) error {
 // making it to the queue. Otherwise, the message is queued until
 // a message is available.
 func (q *Queue) Requeue(f *frame.Frame) error {
+	q.lastUsed = time.Now()
+	if q.discardExpired(f, q.lastUsed) {
+		return nil
+	}
+
 	// find a subscription ready to receive the frame
-	sub := q.subs.Get()
+	sub := q.get(f)
 	if sub == nil {
 		// no subscription available, add to the queue
-		return q.qstore.Requeue(q.destination, f)
+		if err := q.qstore.Requeue(q.destination, f); err != nil {
+			return err
+		}
+		q.pending++
 	} else {
 		// subscription is available, send it now without adding to queue
-		sub.SendQueueFrame(f)
+		q.send(sub, f)
 	}
 	return nil
 }
+
+// Pause stops the queue from dispatching frames to its subscriptions.
+// Frames sent to the queue while it is paused are stored until the
+// queue is resumed.
+func (q *Queue) Pause() {
+	q.paused = true
+}
+
+// Resume dispatches the frames stored while the queue was paused
+// to any available subscriptions.
+func (q *Queue) Resume() error {
+	q.paused = false
+	return q.dispatch()
+}
+
+// Sends the frames in the queue storage to the ready subscriptions,
+// until either there are no more frames or no subscription is ready.
+func (q *Queue) dispatch() error {
+	for q.subs.Len() > 0 {
+		f, err := q.dequeue()
+		if err != nil || f == nil {
+			return err
+		}
+		sub := q.get(f)
+		if sub == nil {
+			// only remote subscriptions that do not accept the frame
+			if err := q.qstore.Requeue(q.destination, f); err != nil {
+				return err
+			}
+			q.pending++
+			return nil
+		}
+		q.send(sub, f)
+	}
+	return nil
+}
+
+// SetDispatchPolicy sets the policy that chooses the subscription
+// that is sent each frame. If policy is nil, FirstReady is used.
+func (q *Queue) SetDispatchPolicy(policy DispatchPolicy) {
+	q.policy = policy
+}
+
+// SetAttributes sets the attributes that control the lifetime of the queue.
+func (q *Queue) SetAttributes(attrs Attributes) {
+	q.attrs = attrs
+	q.lastUsed = time.Now()
+}
+
+// Attributes returns the attributes that control the lifetime of the queue.
+func (q *Queue) Attributes() Attributes {
+	return q.attrs
+}
+
+// Consumers returns the number of subscriptions to the queue, including
+// subscriptions that have been sent a frame and are not yet ready to
+// receive another, and subscriptions of detached sessions.
+func (q *Queue) Consumers() int {
+	return q.subs.Len() + len(q.dispatched) + q.detached
+}
+
+// Expired returns true if the queue has expired at time now, which
+// is when it has had no consumers and no activity for the duration
+// of its Expires attribute.
+func (q *Queue) Expired(now time.Time) bool {
+	return q.attrs.Expires > 0 && q.Consumers() == 0 && now.Sub(q.lastUsed) >= q.attrs.Expires
+}
+
+// Paused returns true if the queue is paused.
+func (q *Queue) Paused() bool {
+	return q.paused
+}
+
+// Subscriptions returns the number of subscriptions that
+// are ready to receive a frame.
+func (q *Queue) Subscriptions() int {
+	return q.subs.Len()
+}
+
+// LocalConsumers returns the number of subscriptions to the queue,
+// including subscriptions that are not ready to receive a frame,
+// but not counting remote subscriptions.
+func (q *Queue) LocalConsumers() int {
+	if q.remote == nil {
+		return q.Consumers()
+	}
+	n := q.detached
+	q.subs.ForEach(func(sub *client.Subscription, isLast bool) {
+		if !q.remote.IsRemote(sub) {
+			n++
+		}
+	})
+	for sub := range q.dispatched {
+		if !q.remote.IsRemote(sub) {
+			n++
+		}
+	}
+	return n
+}
+
+// Pending returns the number of frames waiting in the queue. Frames
+// that were in the queue storage before the server started are not
+// counted until they have been dequeued.
+func (q *Queue) Pending() int {
+	return q.pending
+}
+
+// Returns the subscription that is ready to receive the frame f,
+// or nil if there is none or the queue is paused. The subscription
+// is removed from the subscription list.
+func (q *Queue) get(f *frame.Frame) *client.Subscription {
+	if q.paused {
+		return nil
+	}
+	if q.remote == nil && (q.policy == nil || q.subs.Len() < 2) {
+		return q.subs.Get()
+	}
+
+	// remote subscriptions are only chosen when no local
+	// subscription is ready, and if they accept the frame
+	consumers := make([]Consumer, 0, q.subs.Len())
+	var remotes []Consumer
+	q.subs.ForEach(func(sub *client.Subscription, isLast bool) {
+		c := Consumer{Sub: sub, InFlight: q.inFlight[sub.Conn()]}
+		if q.remote == nil || !q.remote.IsRemote(sub) {
+			consumers = append(consumers, c)
+		} else if q.remote.Accepts(sub, f) {
+			remotes = append(remotes, c)
+		}
+	})
+	if len(consumers) == 0 {
+		consumers = remotes
+	}
+	if len(consumers) == 0 {
+		return nil
+	}
+
+	i := 0
+	if q.policy != nil && len(consumers) > 1 {
+		i = q.policy.Choose(f, consumers)
+	}
+	if i < 0 || i >= len(consumers) {
+		// should not happen, the policy is in error
+		i = 0
+	}
+	sub := consumers[i].Sub
+	q.subs.Remove(sub)
+	return sub
+}
+
+// Sends a frame to a subscription, which is in flight
+// until the subscription is re-added or unsubscribed.
+func (q *Queue) send(sub *client.Subscription, f *frame.Frame) {
+	if !q.dispatched[sub] {
+		q.dispatched[sub] = true
+		q.inFlight[sub.Conn()]++
+	}
+	sub.SendQueueFrame(f)
+}
+
+// Called when a subscription is re-added or unsubscribed, which
+// means that any frame sent to it is no longer in flight.
+func (q *Queue) returned(sub *client.Subscription) {
+	if !q.dispatched[sub] {
+		return
+	}
+	delete(q.dispatched, sub)
+	conn := sub.Conn()
+	if q.inFlight[conn]--; q.inFlight[conn] <= 0 {
+		delete(q.inFlight, conn)
+	}
+}
+
+// Removes a frame from the queue storage,
+// discarding any expired frames.
+func (q *Queue) dequeue() (*frame.Frame, error) {
+	for {
+		f, err := q.qstore.Dequeue(q.destination)
+		if f == nil || err != nil {
+			return f, err
+		}
+		q.lastUsed = time.Now()
+		if q.pending > 0 {
+			q.pending--
+		}
+		if !q.discardExpired(f, q.lastUsed) {
+			return f, nil
+		}
+	}
+}
+
+// Returns true if the frame has expired at time now, in which
+// case it is passed to the expired function to be discarded.
+func (q *Queue) discardExpired(f *frame.Frame, now time.Time) bool {
+	value, ok := f.Header.Contains(MessageExpiresHeader)
+	if !ok {
+		return false
+	}
+	ms, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || ms <= 0 || now.UnixNano()/int64(time.Millisecond) < ms {
+		return false
+	}
+	if q.expired != nil {
+		q.expired(f)
+	}
+	return true
+}
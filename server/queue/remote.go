@@ -0,0 +1,20 @@
+package queue
+
+import (
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/go-stomp/stomp/v3/server/client"
+)
+
+// Remote identifies the subscriptions to a queue that forward frames to
+// other brokers, such as the subscriptions of federation links. A frame
+// is only sent to a remote subscription when no local subscription is
+// ready to receive it, and the remote subscription accepts it.
+type Remote interface {
+	// IsRemote returns true if the subscription
+	// forwards frames to another broker.
+	IsRemote(sub *client.Subscription) bool
+
+	// Accepts returns true if the frame f can be
+	// sent to the remote subscription.
+	Accepts(sub *client.Subscription, f *frame.Frame) bool
+}
@@ -0,0 +1,410 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/go-stomp/stomp/v3/server/client"
+	"github.com/go-stomp/stomp/v3/server/queue"
+	. "gopkg.in/check.v1"
+)
+
+// startQueueBrokers starts two brokers, A and B, that pull messages
+// from each other's queues, and returns their servers and listeners.
+func startQueueBrokers(c *C, prefetch int) ([]*Server, []net.Listener) {
+	listeners := []net.Listener{listenLocal(c), listenLocal(c)}
+	servers := make([]*Server, len(listeners))
+	for i, l := range listeners {
+		servers[i] = federatedServer(&Federation{
+			BrokerId:          string(rune('A' + i)),
+			Peers:             []string{listeners[1-i].Addr().String()},
+			ReconnectInterval: 10 * time.Millisecond,
+			Queues:            true,
+			QueuePrefetch:     prefetch,
+		})
+		go servers[i].Serve(l)
+	}
+	return servers, listeners
+}
+
+// Waits until the queue at the server has n subscriptions ready.
+func waitQueueSubscriptions(c *C, s *Server, destination string, n int) {
+	for i := 0; i < 500; i++ {
+		stats, err := s.Stats()
+		c.Assert(err, IsNil)
+		for _, q := range stats.Queues {
+			if q.Name == destination && q.Subscriptions == n {
+				return
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Fatalf("timed out waiting for %d subscriptions to %s", n, destination)
+}
+
+func (s *FederationSuite) TestQueuePull(c *C) {
+	// with one message in transfer at a time, the order is kept
+	_, l := startQueueBrokers(c, 1)
+	defer l[0].Close()
+	defer l[1].Close()
+
+	a := dialBroker(c, l[0])
+	defer a.Disconnect()
+	b := dialBroker(c, l[1])
+	defer b.Disconnect()
+
+	// messages wait at B, which has no consumers
+	for i := 0; i < 5; i++ {
+		err := b.Send("/queue/work", "text/plain", []byte(fmt.Sprintf("job %d", i)))
+		c.Assert(err, IsNil)
+	}
+
+	sub, err := a.Subscribe("/queue/work", stomp.AckClientIndividual)
+	c.Assert(err, IsNil)
+	for i := 0; i < 5; i++ {
+		msg := receive(c, sub)
+		c.Check(string(msg.Body), Equals, fmt.Sprintf("job %d", i))
+		c.Check(msg.Header.Get(FederationPathHeader), Equals, "B")
+		c.Assert(a.Ack(msg), IsNil)
+	}
+
+	// and later messages are pulled while A has a consumer
+	err = b.Send("/queue/work", "text/plain", []byte("later"))
+	c.Assert(err, IsNil)
+	msg := receive(c, sub)
+	c.Check(string(msg.Body), Equals, "later")
+	c.Assert(a.Ack(msg), IsNil)
+	expectNone(c, sub)
+}
+
+func (s *FederationSuite) TestQueuePrefersLocalConsumers(c *C) {
+	srv, l := startQueueBrokers(c, 1)
+	defer l[0].Close()
+	defer l[1].Close()
+
+	a := dialBroker(c, l[0])
+	defer a.Disconnect()
+	b := dialBroker(c, l[1])
+	defer b.Disconnect()
+
+	subA, err := a.Subscribe("/queue/local", stomp.AckAuto)
+	c.Assert(err, IsNil)
+	subB, err := b.Subscribe("/queue/local", stomp.AckAuto)
+	c.Assert(err, IsNil)
+
+	// the consumer at B, and the link from A, are ready at B
+	waitQueueSubscriptions(c, srv[1], "/queue/local", 2)
+	for i := 0; i < 5; i++ {
+		err := b.Send("/queue/local", "text/plain", []byte("local"))
+		c.Assert(err, IsNil)
+		expectOnce(c, subB, "local")
+	}
+	expectNone(c, subA)
+}
+
+func (s *FederationSuite) TestQueueNoLoop(c *C) {
+	_, l := startQueueBrokers(c, 0)
+	defer l[0].Close()
+	defer l[1].Close()
+
+	a := dialBroker(c, l[0])
+	defer a.Disconnect()
+	b := dialBroker(c, l[1])
+	defer b.Disconnect()
+
+	subA, err := a.Subscribe("/queue/loop", stomp.AckClientIndividual)
+	c.Assert(err, IsNil)
+	err = b.Send("/queue/loop", "text/plain", []byte("first"))
+	c.Assert(err, IsNil)
+	first := receive(c, subA)
+	c.Check(string(first.Body), Equals, "first")
+
+	// the consumer at A is busy, so the second message either stays
+	// at B, or waits at A, from where B does not pull it back
+	err = b.Send("/queue/loop", "text/plain", []byte("second"))
+	c.Assert(err, IsNil)
+	time.Sleep(100 * time.Millisecond)
+	subB, err := b.Subscribe("/queue/loop", stomp.AckAuto)
+	c.Assert(err, IsNil)
+	c.Assert(a.Ack(first), IsNil)
+
+	received := 0
+	for _, sub := range []*stomp.Subscription{subA, subB} {
+		select {
+		case msg := <-sub.C:
+			c.Assert(msg.Err, IsNil)
+			c.Check(string(msg.Body), Equals, "second")
+			c.Check(msg.Header.Get(FederationPathHeader), Not(Equals), "B,A")
+			received++
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+	c.Check(received, Equals, 1)
+}
+
+func (s *FederationSuite) TestQueuePrefetch(c *C) {
+	srv, l := startQueueBrokers(c, 2)
+	defer l[0].Close()
+	defer l[1].Close()
+
+	a := dialBroker(c, l[0])
+	defer a.Disconnect()
+	for i := 0; i < 5; i++ {
+		_, err := a.Subscribe("/queue/prefetch", stomp.AckAuto)
+		c.Assert(err, IsNil)
+	}
+
+	// the link from A subscribes no more than the prefetch at B
+	waitQueueSubscriptions(c, srv[1], "/queue/prefetch", 2)
+	time.Sleep(50 * time.Millisecond)
+	waitQueueSubscriptions(c, srv[1], "/queue/prefetch", 2)
+}
+
+func (s *FederationSuite) TestQueueDemand(c *C) {
+	fed := newFederator(&Federation{BrokerId: "A", QueuePrefetch: 3, QueueIdleTimeout: 20 * time.Millisecond}, nil, nil)
+
+	fed.SetQueueDemand("/queue/one", true)
+	fed.SetQueueDemand("/queue/two", true)
+	fed.SetQueueDemand("/queue/none", false)
+	c.Check(fed.Queues(), DeepEquals, map[string]int{"/queue/one": 3, "/queue/two": 3})
+
+	// the demand remains while consumers come and go
+	fed.SetQueueDemand("/queue/one", false)
+	c.Check(fed.Queues(), DeepEquals, map[string]int{"/queue/one": 3, "/queue/two": 3})
+	fed.SetQueueDemand("/queue/one", true)
+	time.Sleep(50 * time.Millisecond)
+	c.Check(fed.Queues(), DeepEquals, map[string]int{"/queue/one": 3, "/queue/two": 3})
+
+	// and ends after the idle timeout
+	fed.SetQueueDemand("/queue/one", false)
+	for i := 0; i < 100 && len(fed.Queues()) > 1; i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	c.Check(fed.Queues(), DeepEquals, map[string]int{"/queue/two": 3})
+
+	fed.DeleteQueueDemand("/queue/two")
+	c.Check(fed.Queues(), HasLen, 0)
+}
+
+// servePeerQueue serves the link from a broker on l, as a peer broker
+// with n messages in the queue. Each subscription to the queue is sent
+// a message, and another once it has acknowledged it. Once all of the
+// messages have been acknowledged, the number of SUBSCRIBE frames for
+// the queue is sent to subscribes.
+func servePeerQueue(c *C, l net.Listener, destination string, n int, subscribes chan<- int) {
+	conn, err := l.Accept()
+	c.Assert(err, IsNil)
+	defer conn.Close()
+	reader, writer := frame.NewReader(conn), frame.NewWriter(conn)
+
+	f, err := reader.Read()
+	c.Assert(err, IsNil)
+	c.Assert(f.Command, Equals, frame.CONNECT)
+	err = writer.Write(frame.New(frame.CONNECTED, frame.Version, "1.2", client.BrokerIdHeader, "B"))
+	c.Assert(err, IsNil)
+
+	sent, acked, subscribed := 0, 0, 0
+	send := func(id string) {
+		if sent == n {
+			return
+		}
+		m := frame.New(frame.MESSAGE,
+			frame.Subscription, id,
+			frame.MessageId, strconv.Itoa(sent),
+			frame.Ack, id+"/"+strconv.Itoa(sent),
+			frame.Destination, destination)
+		m.Body = []byte(fmt.Sprintf("job %d", sent))
+		sent++
+		c.Check(writer.Write(m), IsNil)
+	}
+
+	for {
+		f, err := reader.Read()
+		if err != nil {
+			return
+		}
+		if f == nil {
+			// heart-beat
+			continue
+		}
+		switch f.Command {
+		case frame.SUBSCRIBE:
+			if f.Header.Get(frame.Destination) == destination {
+				subscribed++
+				send(f.Header.Get(frame.Id))
+			}
+		case frame.ACK:
+			acked++
+			send(strings.SplitN(f.Header.Get(frame.Id), "/", 2)[0])
+			if acked == n {
+				subscribes <- subscribed
+			}
+		}
+		if receipt, ok := f.Header.Contains(frame.Receipt); ok {
+			c.Check(writer.Write(frame.New(frame.RECEIPT, frame.ReceiptId, receipt)), IsNil)
+		}
+	}
+}
+
+func (s *FederationSuite) TestQueueSubscriptionsAreKept(c *C) {
+	const count = 20
+	peer := listenLocal(c)
+	defer peer.Close()
+	subscribes := make(chan int, 1)
+	go servePeerQueue(c, peer, "/queue/kept", count, subscribes)
+
+	l := listenLocal(c)
+	defer l.Close()
+	go federatedServer(&Federation{
+		BrokerId:          "A",
+		Peers:             []string{peer.Addr().String()},
+		ReconnectInterval: 10 * time.Millisecond,
+		Queues:            true,
+		QueuePrefetch:     2,
+	}).Serve(l)
+
+	a := dialBroker(c, l)
+	defer a.Disconnect()
+	sub, err := a.Subscribe("/queue/kept", stomp.AckClientIndividual)
+	c.Assert(err, IsNil)
+
+	// the consumer is busy with each message until it acknowledges
+	// it, but the link keeps its subscriptions at the peer
+	for i := 0; i < count; i++ {
+		msg := receive(c, sub)
+		c.Check(msg.Header.Get(FederationPathHeader), Equals, "B")
+		c.Assert(a.Ack(msg), IsNil)
+	}
+	select {
+	case n := <-subscribes:
+		c.Check(n, Equals, 2)
+	case <-time.After(5 * time.Second):
+		c.Fatal("timed out waiting for messages to be acknowledged")
+	}
+}
+
+// blockingStorage is a queue storage that blocks the first message
+// enqueued until it is released.
+type blockingStorage struct {
+	queue.Storage
+	enqueuing chan struct{} // receives when the first message is enqueued
+	release   chan struct{} // closed to complete the enqueue
+	once      sync.Once
+}
+
+func (s *blockingStorage) Enqueue(destination string, f *frame.Frame) error {
+	s.once.Do(func() {
+		s.enqueuing <- struct{}{}
+		<-s.release
+	})
+	return s.Storage.Enqueue(destination, f)
+}
+
+// servePeerAcks serves the link from a broker on l, as a peer broker
+// that sends a message to each of the first n subscriptions to the
+// queue, and the ack id of each ACK frame to acks.
+func servePeerAcks(c *C, l net.Listener, destination string, n int, acks chan<- string) {
+	conn, err := l.Accept()
+	c.Assert(err, IsNil)
+	defer conn.Close()
+	reader, writer := frame.NewReader(conn), frame.NewWriter(conn)
+
+	f, err := reader.Read()
+	c.Assert(err, IsNil)
+	c.Assert(f.Command, Equals, frame.CONNECT)
+	err = writer.Write(frame.New(frame.CONNECTED, frame.Version, "1.2", client.BrokerIdHeader, "B"))
+	c.Assert(err, IsNil)
+
+	for sent := 0; ; {
+		f, err := reader.Read()
+		if err != nil {
+			return
+		}
+		if f == nil {
+			// heart-beat
+			continue
+		}
+		switch f.Command {
+		case frame.SUBSCRIBE:
+			if f.Header.Get(frame.Destination) == destination && sent < n {
+				m := frame.New(frame.MESSAGE,
+					frame.Subscription, f.Header.Get(frame.Id),
+					frame.MessageId, strconv.Itoa(sent),
+					frame.Ack, strconv.Itoa(sent),
+					frame.Destination, destination)
+				m.Body = []byte(fmt.Sprintf("job %d", sent))
+				sent++
+				c.Check(writer.Write(m), IsNil)
+			}
+		case frame.ACK:
+			acks <- f.Header.Get(frame.Id)
+		}
+		if receipt, ok := f.Header.Contains(frame.Receipt); ok {
+			c.Check(writer.Write(frame.New(frame.RECEIPT, frame.ReceiptId, receipt)), IsNil)
+		}
+	}
+}
+
+func (s *FederationSuite) TestQueueAckAfterEnqueue(c *C) {
+	peer := listenLocal(c)
+	defer peer.Close()
+	acks := make(chan string, 2)
+	go servePeerAcks(c, peer, "/queue/stored", 2, acks)
+
+	storage := &blockingStorage{
+		Storage:   queue.NewMemoryQueueStorage(),
+		enqueuing: make(chan struct{}),
+		release:   make(chan struct{}),
+	}
+	srv := federatedServer(&Federation{
+		BrokerId:          "A",
+		Peers:             []string{peer.Addr().String()},
+		ReconnectInterval: 10 * time.Millisecond,
+		Queues:            true,
+		QueuePrefetch:     2,
+	})
+	srv.QueueStorage = storage
+	l := listenLocal(c)
+	defer l.Close()
+	go srv.Serve(l)
+
+	a := dialBroker(c, l)
+	defer a.Disconnect()
+	sub, err := a.Subscribe("/queue/stored", stomp.AckClientIndividual)
+	c.Assert(err, IsNil)
+
+	// the first message is sent to the consumer, which is then busy,
+	// so the second message is stored
+	first := receive(c, sub)
+	select {
+	case <-storage.enqueuing:
+	case <-time.After(5 * time.Second):
+		c.Fatal("timed out waiting for the message to be stored")
+	}
+	select {
+	case id := <-acks:
+		c.Check("job "+id, Equals, string(first.Body))
+	case <-time.After(5 * time.Second):
+		c.Fatal("timed out waiting for ACK")
+	}
+
+	// the second message is not acknowledged until it has been stored
+	select {
+	case id := <-acks:
+		c.Fatalf("unexpected ACK %s before the message was stored", id)
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(storage.release)
+	select {
+	case id := <-acks:
+		c.Check("job "+id, Not(Equals), string(first.Body))
+	case <-time.After(5 * time.Second):
+		c.Fatal("timed out waiting for ACK")
+	}
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/go-stomp/stomp/v3/server/queue"
+)
+
+// SUBSCRIBE header entries that set the attributes of a queue.
+const (
+	// AutoDeleteHeader with a value of "true" deletes the queue, and
+	// discards its messages, when its last subscription is unsubscribed.
+	AutoDeleteHeader = "auto-delete"
+
+	// ExpiresHeader deletes the queue after it has had no subscriptions
+	// and no activity for the given number of milliseconds.
+	ExpiresHeader = "x-expires"
+)
+
+// Reasons that the server deletes a queue.
+const (
+	QueueAutoDeleted = "auto-delete" // The last subscription was unsubscribed
+	QueueExpired     = "expired"     // No subscriptions or activity for the expiry period
+)
+
+// Interval between checks for queues that have expired.
+var queueExpiryInterval = time.Second
+
+var errNotQueue = errors.New("not a queue destination")
+
+// QueueDeletedEvent describes a queue that the server has
+// deleted because of its attributes.
+type QueueDeletedEvent struct {
+	Name      string // Destination name
+	Reason    string // QueueAutoDeleted or QueueExpired
+	Discarded int    // Messages discarded with the queue
+}
+
+// DeclareQueue creates the queue with the given name if it does not
+// exist, and sets the attributes that control its lifetime.
+func (s *Server) DeclareQueue(name string, attrs queue.Attributes) error {
+	if !isQueueDestination(name) {
+		return errNotQueue
+	}
+	proc, err := s.processor()
+	if err != nil {
+		return err
+	}
+	return proc.control(func() error {
+		proc.qm.Find(name).SetAttributes(attrs)
+		return nil
+	})
+}
+
+// Set the attributes of a queue from the header entries of a
+// SUBSCRIBE frame. Attributes without a header entry are unchanged.
+func (proc *requestProcessor) setQueueAttributes(q *queue.Queue, header *frame.Header) {
+	attrs := q.Attributes()
+	changed := false
+	if value, ok := header.Contains(AutoDeleteHeader); ok {
+		attrs.AutoDelete = value == "true"
+		changed = true
+	}
+	if value, ok := header.Contains(ExpiresHeader); ok {
+		ms, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			proc.server.Log.Warningf("stomp: invalid %s header for %s: %q", ExpiresHeader, q.Destination(), value)
+		} else {
+			attrs.Expires = time.Duration(ms) * time.Millisecond
+			changed = true
+		}
+	}
+	if changed {
+		q.SetAttributes(attrs)
+	}
+}
+
+// Delete the queues that have expired.
+func (proc *requestProcessor) expireQueues(now time.Time) {
+	for _, q := range proc.qm.Expired(now) {
+		proc.deleteQueue(q.Destination(), QueueExpired)
+	}
+}
+
+// Delete a queue, discarding its messages, and report the deletion.
+func (proc *requestProcessor) deleteQueue(destination string, reason string) {
+	discarded, err := proc.qm.Delete(destination)
+	if err != nil {
+		proc.server.Log.Errorf("stomp: failed to discard messages of %s: %v", destination, err)
+	}
+	if proc.fed != nil {
+		proc.fed.DeleteQueueDemand(destination)
+	}
+	if ps, ok := proc.server.QueueStorage.(PauseStorage); ok {
+		if err := ps.SetPaused(destination, false); err != nil {
+			proc.server.Log.Errorf("stomp: failed to clear paused state of %s: %v", destination, err)
+		}
+	}
+
+	switch reason {
+	case QueueAutoDeleted:
+		proc.autoDeleted++
+	case QueueExpired:
+		proc.expired++
+	}
+	proc.server.Log.Infof("stomp: deleted queue %s (%s), discarded %d messages", destination, reason, discarded)
+
+	if proc.server.OnQueueDeleted != nil {
+		proc.server.OnQueueDeleted(QueueDeletedEvent{
+			Name:      destination,
+			Reason:    reason,
+			Discarded: discarded,
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"time"
+
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/server/queue"
+	. "gopkg.in/check.v1"
+)
+
+type QueueLifetimeSuite struct{}
+
+var _ = Suite(&QueueLifetimeSuite{})
+
+func expectDeleted(c *C, events chan QueueDeletedEvent) QueueDeletedEvent {
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(5 * time.Second):
+		c.Fatal("timed out waiting for queue to be deleted")
+	}
+	return QueueDeletedEvent{}
+}
+
+func (s *QueueLifetimeSuite) TestAutoDelete(c *C) {
+	events := make(chan QueueDeletedEvent, 1)
+	server := &Server{OnQueueDeleted: func(event QueueDeletedEvent) {
+		events <- event
+	}}
+	conn := startServer(c, server)
+	defer conn.Disconnect()
+
+	sub, err := conn.Subscribe("/queue/auto", stomp.AckClient,
+		stomp.SubscribeOpt.Header(AutoDeleteHeader, "true"))
+	c.Assert(err, IsNil)
+	for _, body := range []string{"1", "2"} {
+		err = conn.Send("/queue/auto", "text/plain", []byte(body),
+			stomp.SendOpt.Receipt)
+		c.Assert(err, IsNil)
+	}
+	expectOnce(c, sub, "1")
+
+	c.Assert(sub.Unsubscribe(), IsNil)
+	c.Check(expectDeleted(c, events), Equals, QueueDeletedEvent{
+		Name:      "/queue/auto",
+		Reason:    QueueAutoDeleted,
+		Discarded: 1,
+	})
+
+	stats, err := server.Stats()
+	c.Assert(err, IsNil)
+	c.Check(stats.Queues, HasLen, 0)
+	c.Check(stats.AutoDeleted, Equals, 1)
+}
+
+func (s *QueueLifetimeSuite) TestExpires(c *C) {
+	events := make(chan QueueDeletedEvent, 1)
+	server := &Server{OnQueueDeleted: func(event QueueDeletedEvent) {
+		events <- event
+	}}
+	conn := startServer(c, server)
+	defer conn.Disconnect()
+
+	c.Check(server.DeclareQueue("/topic/expires", queue.Attributes{}), Equals, errNotQueue)
+	c.Assert(server.DeclareQueue("/queue/expires", queue.Attributes{
+		Expires: 50 * time.Millisecond,
+	}), IsNil)
+	err := conn.Send("/queue/expires", "text/plain", []byte("1"),
+		stomp.SendOpt.Receipt)
+	c.Assert(err, IsNil)
+
+	c.Check(expectDeleted(c, events), Equals, QueueDeletedEvent{
+		Name:      "/queue/expires",
+		Reason:    QueueExpired,
+		Discarded: 1,
+	})
+
+	stats, err := server.Stats()
+	c.Assert(err, IsNil)
+	c.Check(stats.Queues, HasLen, 0)
+	c.Check(stats.Expired, Equals, 1)
+}
+
+func (s *QueueLifetimeSuite) TestSubscriptionPreventsExpiry(c *C) {
+	events := make(chan QueueDeletedEvent, 1)
+	server := &Server{OnQueueDeleted: func(event QueueDeletedEvent) {
+		events <- event
+	}}
+	conn := startServer(c, server)
+	defer conn.Disconnect()
+
+	sub, err := conn.Subscribe("/queue/subscribed", stomp.AckAuto,
+		stomp.SubscribeOpt.Header(ExpiresHeader, "50"))
+	c.Assert(err, IsNil)
+
+	select {
+	case event := <-events:
+		c.Fatalf("unexpected deletion: %v", event)
+	case <-time.After(2 * queueExpiryInterval):
+	}
+
+	c.Assert(sub.Unsubscribe(), IsNil)
+	c.Check(expectDeleted(c, events).Reason, Equals, QueueExpired)
+}
@@ -28,3 +28,19 @@ type QueueStorage interface {
 	// to perform any cleanup, such as flushing to disk.
 	Stop()
 }
+
+// PauseStorage is an optional interface implemented by a QueueStorage
+// that persists the paused state of destinations. When the queue storage
+// implements PauseStorage, destinations that were paused when the server
+// stopped are paused again when the server starts.
+//
+// Durable queue storages should implement PauseStorage. The in-memory and
+// paging queue storages do not, because they lose their messages when the
+// server stops, so pauses are lost on restart when they are used.
+type PauseStorage interface {
+	// PausedDestinations returns the destinations that are paused.
+	PausedDestinations() ([]string, error)
+
+	// SetPaused records whether the destination is paused.
+	SetPaused(destination string, paused bool) error
+}
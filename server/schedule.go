@@ -0,0 +1,288 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/go-stomp/stomp/v3/server/client"
+)
+
+// Header entries of the messages published by a Schedule.
+const (
+	// ScheduleHeader contains the name of the schedule.
+	ScheduleHeader = "schedule"
+
+	// ScheduledTimeHeader contains the fire time of the run that
+	// published the message, in RFC 3339 format.
+	ScheduledTimeHeader = "scheduled-time"
+)
+
+// MissedRunPolicy determines what a Schedule does when one or more of
+// its fire times have passed before it could run, for example because
+// the server was busy or the computer was suspended.
+type MissedRunPolicy int
+
+const (
+	// Publish one message for the most recent fire time, and skip the
+	// earlier fire times. This is the default policy.
+	MissedRunFireOnce MissedRunPolicy = iota
+
+	// Publish one message for each fire time, up to MaxMissedRuns.
+	MissedRunFireAll
+
+	// Skip every fire time that is more than a second late.
+	MissedRunSkip
+)
+
+// Maximum number of messages published for missed fire times
+// by a Schedule with the MissedRunFireAll policy.
+const MaxMissedRuns = 100
+
+// Fire times that are no later than this are not missed
+// by a Schedule with the MissedRunSkip policy.
+const missedRunTolerance = time.Second
+
+// A Schedule publishes a message to a destination at recurring times,
+// which are given by either a cron expression or a fixed interval.
+//
+// The Header cannot contain the destination, ScheduleHeader or
+// ScheduledTimeHeader entries, which are set by the schedule.
+type Schedule struct {
+	Name        string            // Identifies the schedule, required
+	Cron        string            // Cron expression for the fire times, see below
+	Interval    time.Duration     // Interval between fire times, from when the server starts serving
+	Location    *time.Location    // Time zone of the cron expression, time.Local if nil
+	Destination string            // Destination of the messages, required
+	Header      map[string]string // Additional header entries of the messages, see below
+	Body        []byte            // Body of the messages
+	Jitter      time.Duration     // Each run is delayed by a random duration up to Jitter
+	MissedRuns  MissedRunPolicy   // What to do when fire times are missed
+}
+
+// ScheduleStats contains statistics for a Schedule.
+type ScheduleStats struct {
+	Name        string    // Name of the schedule
+	Destination string    // Destination of the messages
+	Next        time.Time // Time of the next run, including jitter
+	Last        time.Time // Fire time of the last run, zero if it has not run
+	Published   uint64    // Messages published
+	Missed      uint64    // Fire times skipped
+}
+
+var (
+	errScheduleName        = errors.New("schedule requires a name")
+	errScheduleDestination = errors.New("schedule requires a destination")
+	errScheduleTimes       = errors.New("schedule requires either a cron expression or an interval")
+)
+
+// Validate checks that the schedule is complete, and that its cron
+// expression is valid. The Cron field has five fields: minute, hour, day of month,
+// month and day of week. Each field is "*" or a comma-separated list of
+// values or ranges ("1-5"), optionally with a step ("*/15"). Months and
+// days of the week can be given by name. The descriptors @yearly,
+// @monthly, @weekly, @daily and @hourly are also accepted.
+func (s *Schedule) Validate() error {
+	_, err := s.parse()
+	return err
+}
+
+// Validates the schedule, and returns its parsed cron
+// expression, or nil if it is an interval schedule.
+func (s *Schedule) parse() (*cronExpr, error) {
+	if s.Name == "" {
+		return nil, errScheduleName
+	}
+	if s.Destination == "" {
+		return nil, errScheduleDestination
+	}
+	if (s.Cron == "") == (s.Interval <= 0) {
+		return nil, errScheduleTimes
+	}
+	for _, key := range []string{frame.Destination, ScheduleHeader, ScheduledTimeHeader} {
+		if _, ok := s.Header[key]; ok {
+			return nil, fmt.Errorf("schedule %s: header %s is set by the schedule", s.Name, key)
+		}
+	}
+	if s.Cron == "" {
+		return nil, nil
+	}
+	cron, err := parseCron(s.Cron)
+	if err != nil {
+		return nil, fmt.Errorf("schedule %s: %v", s.Name, err)
+	}
+	return cron, nil
+}
+
+// NextFireTimes returns up to n fire times of the schedule after the
+// given time, without jitter. The fire times of an interval schedule
+// are counted from the given time.
+func (s *Schedule) NextFireTimes(after time.Time, n int) ([]time.Time, error) {
+	run, err := newScheduleRun(s)
+	if err != nil {
+		return nil, err
+	}
+	var times []time.Time
+	for t := after; len(times) < n; {
+		t = run.nextAfter(t)
+		if t.IsZero() {
+			break
+		}
+		times = append(times, t)
+	}
+	return times, nil
+}
+
+// The state of a Schedule in the request processor.
+type scheduleRun struct {
+	schedule  *Schedule
+	cron      *cronExpr // parsed Cron of the schedule, nil for interval schedules
+	next      time.Time // next fire time, zero if there is none
+	fireAt    time.Time // next fire time, including jitter
+	last      time.Time // last fire time
+	published uint64
+	missed    uint64
+}
+
+// Validates the schedule, and returns the state for running it,
+// with its cron expression parsed once.
+func newScheduleRun(s *Schedule) (*scheduleRun, error) {
+	cron, err := s.parse()
+	if err != nil {
+		return nil, err
+	}
+	return &scheduleRun{schedule: s, cron: cron}, nil
+}
+
+// Returns the first fire time of the schedule after t,
+// or the zero time if there is none.
+func (run *scheduleRun) nextAfter(t time.Time) time.Time {
+	if run.cron == nil {
+		return t.Add(run.schedule.Interval)
+	}
+	loc := run.schedule.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	return run.cron.next(t.In(loc))
+}
+
+// Start running the schedules at time now.
+func (proc *requestProcessor) startSchedules(now time.Time) {
+	for _, run := range proc.schedules {
+		run.setNext(run.nextAfter(now))
+	}
+}
+
+// Sets the next fire time, and the time of the next run.
+func (run *scheduleRun) setNext(next time.Time) {
+	run.next = next
+	run.fireAt = next
+	if !run.next.IsZero() && run.schedule.Jitter > 0 {
+		run.fireAt = run.next.Add(time.Duration(rand.Int63n(int64(run.schedule.Jitter))))
+	}
+}
+
+// Returns a timer for the earliest run of the schedules, or
+// nil if there are none.
+func (proc *requestProcessor) scheduleTimer(now time.Time) *time.Timer {
+	var earliest time.Time
+	for _, run := range proc.schedules {
+		if !run.fireAt.IsZero() && (earliest.IsZero() || run.fireAt.Before(earliest)) {
+			earliest = run.fireAt
+		}
+	}
+	if earliest.IsZero() {
+		return nil
+	}
+	return time.NewTimer(earliest.Sub(now))
+}
+
+// Runs the schedules that are due at time now, adding the
+// messages that they publish to the request scheduler.
+func (proc *requestProcessor) runSchedules(now time.Time, sched *scheduler) {
+	for _, run := range proc.schedules {
+		if run.fireAt.IsZero() || now.Before(run.fireAt) {
+			continue
+		}
+
+		// the fire times that have passed, of which all
+		// but the most recent have been missed
+		var times []time.Time
+		var t time.Time
+		passed := 0
+		for t = run.next; !t.IsZero() && !t.After(now); t = run.nextAfter(t) {
+			passed++
+			times = append(times, t)
+			if len(times) > MaxMissedRuns {
+				times = times[1:]
+			}
+		}
+
+		switch run.schedule.MissedRuns {
+		case MissedRunFireAll:
+			// publish for every fire time, up to MaxMissedRuns
+		case MissedRunSkip:
+			// publish for the most recent fire time, if it is on time
+			latest := times[len(times)-1]
+			late := now.Sub(latest)
+			if passed == 1 {
+				late = now.Sub(run.fireAt)
+			}
+			times = nil
+			if late <= missedRunTolerance {
+				times = append(times, latest)
+			}
+		default:
+			times = times[len(times)-1:]
+		}
+		run.missed += uint64(passed - len(times))
+
+		for _, t := range times {
+			sched.Add(client.Request{Op: client.EnqueueOp, Frame: run.schedule.message(t)})
+			run.published++
+			run.last = t
+		}
+		run.setNext(t)
+	}
+}
+
+// Returns the message published by the schedule for a fire time.
+func (s *Schedule) message(t time.Time) *frame.Frame {
+	f := frame.New(frame.MESSAGE,
+		frame.Destination, s.Destination,
+		ScheduleHeader, s.Name,
+		ScheduledTimeHeader, t.Format(time.RFC3339))
+
+	keys := make([]string, 0, len(s.Header))
+	for key := range s.Header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		f.Header.Set(key, s.Header[key])
+	}
+	if len(s.Body) > 0 {
+		f.Body = append([]byte(nil), s.Body...)
+	}
+	return f
+}
+
+// Returns the statistics for the schedules.
+func (proc *requestProcessor) scheduleStats() []ScheduleStats {
+	var stats []ScheduleStats
+	for _, run := range proc.schedules {
+		stats = append(stats, ScheduleStats{
+			Name:        run.schedule.Name,
+			Destination: run.schedule.Destination,
+			Next:        run.fireAt,
+			Last:        run.last,
+			Published:   run.published,
+			Missed:      run.missed,
+		})
+	}
+	return stats
+}
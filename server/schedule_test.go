@@ -0,0 +1,165 @@
+package server
+
+import (
+	"time"
+
+	"github.com/go-stomp/stomp/v3"
+	. "gopkg.in/check.v1"
+)
+
+type ScheduleSuite struct{}
+
+var _ = Suite(&ScheduleSuite{})
+
+func (s *ScheduleSuite) TestValidate(c *C) {
+	c.Check((&Schedule{Destination: "/topic/a", Interval: time.Second}).Validate(), Equals, errScheduleName)
+	c.Check((&Schedule{Name: "a", Interval: time.Second}).Validate(), Equals, errScheduleDestination)
+	c.Check((&Schedule{Name: "a", Destination: "/topic/a"}).Validate(), Equals, errScheduleTimes)
+	c.Check((&Schedule{Name: "a", Destination: "/topic/a", Cron: "* * * * *", Interval: time.Second}).Validate(),
+		Equals, errScheduleTimes)
+	c.Check((&Schedule{Name: "a", Destination: "/topic/a", Cron: "* * *"}).Validate(), NotNil)
+	c.Check((&Schedule{Name: "a", Destination: "/topic/a", Interval: time.Second,
+		Header: map[string]string{"destination": "/topic/b"}}).Validate(),
+		ErrorMatches, "schedule a: header destination is set by the schedule")
+	c.Check((&Schedule{Name: "a", Destination: "/topic/a", Interval: time.Second,
+		Header: map[string]string{ScheduledTimeHeader: "now"}}).Validate(), NotNil)
+
+	err := (&Server{Schedules: []*Schedule{{Name: "a"}}}).Serve(listenLocal(c))
+	c.Check(err, Equals, errScheduleDestination)
+}
+
+func (s *ScheduleSuite) TestNextFireTimes(c *C) {
+	schedule := &Schedule{
+		Name:        "nightly",
+		Cron:        "0 2 * * *",
+		Location:    time.UTC,
+		Destination: "/topic/nightly",
+	}
+	times, err := schedule.NextFireTimes(time.Date(2024, 1, 31, 10, 0, 0, 0, time.UTC), 2)
+	c.Assert(err, IsNil)
+	c.Check(times, DeepEquals, []time.Time{
+		time.Date(2024, 2, 1, 2, 0, 0, 0, time.UTC),
+		time.Date(2024, 2, 2, 2, 0, 0, 0, time.UTC),
+	})
+}
+
+func (s *ScheduleSuite) TestNextFireTimesConcurrent(c *C) {
+	// the schedule is only read, so that it can be used while the
+	// server that runs it is serving
+	schedule := &Schedule{
+		Name:        "hourly",
+		Cron:        "@hourly",
+		Location:    time.UTC,
+		Destination: "/topic/hourly",
+	}
+	after := time.Date(2024, 1, 31, 10, 30, 0, 0, time.UTC)
+	done := make(chan []time.Time)
+	for i := 0; i < 4; i++ {
+		go func() {
+			times, err := schedule.NextFireTimes(after, 1)
+			c.Check(err, IsNil)
+			done <- times
+		}()
+	}
+	for i := 0; i < 4; i++ {
+		c.Check(<-done, DeepEquals, []time.Time{time.Date(2024, 1, 31, 11, 0, 0, 0, time.UTC)})
+	}
+}
+
+// Runs the schedule at the given times, and returns
+// the fire times of the messages published.
+func runSchedule(c *C, schedule *Schedule, start time.Time, times ...time.Time) (*scheduleRun, []string) {
+	run, err := newScheduleRun(schedule)
+	c.Assert(err, IsNil)
+	proc := &requestProcessor{schedules: []*scheduleRun{run}}
+	proc.startSchedules(start)
+	sched := newScheduler(nil)
+	for _, now := range times {
+		proc.runSchedules(now, sched)
+	}
+	var published []string
+	for r, ok := sched.Next(); ok; r, ok = sched.Next() {
+		published = append(published, r.Frame.Header.Get(ScheduledTimeHeader))
+	}
+	return proc.schedules[0], published
+}
+
+func (s *ScheduleSuite) TestMissedRuns(c *C) {
+	start := time.Date(2024, 1, 31, 10, 0, 0, 0, time.UTC)
+	newSchedule := func(policy MissedRunPolicy) *Schedule {
+		return &Schedule{
+			Name:        "tick",
+			Interval:    time.Minute,
+			Destination: "/topic/tick",
+			MissedRuns:  policy,
+		}
+	}
+	onTime := start.Add(time.Minute)
+	late := start.Add(3*time.Minute + 30*time.Second)
+
+	run, published := runSchedule(c, newSchedule(MissedRunFireOnce), start, onTime, late)
+	c.Check(published, DeepEquals, []string{"2024-01-31T10:01:00Z", "2024-01-31T10:03:00Z"})
+	c.Check(run.missed, Equals, uint64(1))
+	c.Check(run.next, Equals, start.Add(4*time.Minute))
+
+	run, published = runSchedule(c, newSchedule(MissedRunFireAll), start, onTime, late)
+	c.Check(published, DeepEquals, []string{
+		"2024-01-31T10:01:00Z", "2024-01-31T10:02:00Z", "2024-01-31T10:03:00Z"})
+	c.Check(run.missed, Equals, uint64(0))
+
+	run, published = runSchedule(c, newSchedule(MissedRunSkip), start, onTime, late)
+	c.Check(published, DeepEquals, []string{"2024-01-31T10:01:00Z"})
+	c.Check(run.missed, Equals, uint64(2))
+	c.Check(run.published, Equals, uint64(1))
+}
+
+func (s *ScheduleSuite) TestJitter(c *C) {
+	start := time.Date(2024, 1, 31, 10, 0, 0, 0, time.UTC)
+	schedule := &Schedule{
+		Name:        "tick",
+		Interval:    time.Minute,
+		Destination: "/topic/tick",
+		Jitter:      10 * time.Second,
+	}
+	run, published := runSchedule(c, schedule, start, start.Add(time.Minute).Add(-time.Second))
+	c.Check(published, HasLen, 0)
+	c.Check(run.fireAt.Before(start.Add(time.Minute)), Equals, false)
+	c.Check(run.fireAt.Before(start.Add(time.Minute+10*time.Second)), Equals, true)
+
+	_, published = runSchedule(c, schedule, start, start.Add(time.Minute+10*time.Second))
+	c.Check(published, DeepEquals, []string{"2024-01-31T10:01:00Z"})
+}
+
+func (s *ScheduleSuite) TestPublish(c *C) {
+	server := &Server{Schedules: []*Schedule{{
+		Name:        "tick",
+		Interval:    50 * time.Millisecond,
+		Destination: "/queue/tick",
+		Header:      map[string]string{"kind": "tick"},
+		Body:        []byte("tick"),
+	}}}
+	conn := startServer(c, server)
+	defer conn.Disconnect()
+
+	sub, err := conn.Subscribe("/queue/tick", stomp.AckAuto)
+	c.Assert(err, IsNil)
+	var msg *stomp.Message
+	select {
+	case msg = <-sub.C:
+		c.Assert(msg.Err, IsNil)
+	case <-time.After(5 * time.Second):
+		c.Fatal("timed out waiting for message")
+	}
+	c.Check(string(msg.Body), Equals, "tick")
+	c.Check(msg.Header.Get("kind"), Equals, "tick")
+	c.Check(msg.Header.Get(ScheduleHeader), Equals, "tick")
+	_, err = time.Parse(time.RFC3339, msg.Header.Get(ScheduledTimeHeader))
+	c.Check(err, IsNil)
+
+	stats, err := server.Stats()
+	c.Assert(err, IsNil)
+	c.Assert(stats.Schedules, HasLen, 1)
+	c.Check(stats.Schedules[0].Name, Equals, "tick")
+	c.Check(stats.Schedules[0].Published > 0, Equals, true)
+	c.Check(stats.Schedules[0].Next.After(stats.Schedules[0].Last), Equals, true)
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"container/list"
+
+	"github.com/go-stomp/stomp/v3/server/client"
+)
+
+// The scheduler decides the order in which the request processor
+// handles client requests. Requests are separated into two lanes:
+//
+// The control lane contains subscribe, unsubscribe, requeue, connect
+// and disconnect requests, which are handled in the order received and
+// before any enqueue requests. These requests are cheap to handle, and
+// delaying them delays message delivery to every client.
+//
+// The send lane contains enqueue requests, which are handled in weighted
+// round-robin order across connections, so that a client sending a flood
+// of messages does not delay the messages sent by other clients.
+//
+// The requests from the same connection are always handled in the order
+// received: a control request from a connection with pending enqueue
+// requests is added to the send lane after them.
+type scheduler struct {
+	control *list.List                  // control requests
+	lanes   map[*client.Conn]*sendLane  // connections with pending enqueue requests
+	ring    *list.List                  // sendLanes in round-robin order
+	weight  func(conn *client.Conn) int // weight of a connection, may be nil
+}
+
+// sendLane contains the pending enqueue requests for a connection,
+// and the control requests received after them.
+type sendLane struct {
+	conn     *client.Conn
+	requests *list.List // pending requests
+	weight   int        // number of requests handled in turn
+	served   int        // number of requests handled this turn
+}
+
+func newScheduler(weight func(conn *client.Conn) int) *scheduler {
+	return &scheduler{
+		control: list.New(),
+		lanes:   make(map[*client.Conn]*sendLane),
+		ring:    list.New(),
+		weight:  weight,
+	}
+}
+
+// Empty returns true if there are no pending requests.
+func (s *scheduler) Empty() bool {
+	return s.control.Len() == 0 && s.ring.Len() == 0
+}
+
+// Add a request to the appropriate lane.
+func (s *scheduler) Add(r client.Request) {
+	if r.Op != client.EnqueueOp {
+		if lane, ok := s.lanes[connOf(r)]; ok && connOf(r) != nil {
+			lane.requests.PushBack(r)
+		} else {
+			s.control.PushBack(r)
+		}
+		return
+	}
+
+	lane, ok := s.lanes[r.Conn]
+	if !ok {
+		lane = &sendLane{
+			conn:     r.Conn,
+			requests: list.New(),
+			weight:   1,
+		}
+		if s.weight != nil && r.Conn != nil {
+			if w := s.weight(r.Conn); w > 1 {
+				lane.weight = w
+			}
+		}
+		s.lanes[r.Conn] = lane
+		s.ring.PushBack(lane)
+	}
+	lane.requests.PushBack(r)
+}
+
+// Next removes the next request to handle. Returns false
+// if there are no pending requests.
+func (s *scheduler) Next() (client.Request, bool) {
+	if e := s.control.Front(); e != nil {
+		return s.control.Remove(e).(client.Request), true
+	}
+
+	e := s.ring.Front()
+	if e == nil {
+		return client.Request{}, false
+	}
+	lane := e.Value.(*sendLane)
+	r := lane.requests.Remove(lane.requests.Front()).(client.Request)
+	lane.served++
+
+	if lane.requests.Len() == 0 {
+		// the connection has no more pending requests
+		s.ring.Remove(e)
+		delete(s.lanes, lane.conn)
+	} else if lane.served >= lane.weight {
+		// the connection has had its turn
+		lane.served = 0
+		s.ring.MoveToBack(e)
+	}
+	return r, true
+}
+
+// Returns the connection that made the request, or nil
+// if the request was not made by a client connection.
+func connOf(r client.Request) *client.Conn {
+	if r.Conn == nil && r.Sub != nil {
+		return r.Sub.Conn()
+	}
+	return r.Conn
+}
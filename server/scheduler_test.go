@@ -0,0 +1,179 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/go-stomp/stomp/v3/server/client"
+	"github.com/go-stomp/stomp/v3/server/queue"
+	. "gopkg.in/check.v1"
+)
+
+type SchedulerSuite struct{}
+
+var _ = Suite(&SchedulerSuite{})
+
+func enqueueRequest(conn *client.Conn, body string) client.Request {
+	f := frame.New(frame.MESSAGE, frame.Destination, "/queue/test")
+	f.Body = []byte(body)
+	return client.Request{Op: client.EnqueueOp, Frame: f, Conn: conn}
+}
+
+// schedule returns the bodies of the enqueue requests, and the
+// opcodes of the other requests, in the order they are scheduled.
+func schedule(s *scheduler) []string {
+	var order []string
+	for r, ok := s.Next(); ok; r, ok = s.Next() {
+		if r.Op == client.EnqueueOp {
+			order = append(order, string(r.Frame.Body))
+		} else {
+			order = append(order, "op"+r.Op.String())
+		}
+	}
+	return order
+}
+
+func (s *SchedulerSuite) TestRoundRobin(c *C) {
+	busy, quiet := &client.Conn{}, &client.Conn{}
+	sched := newScheduler(nil)
+
+	for i := 1; i <= 4; i++ {
+		sched.Add(enqueueRequest(busy, fmt.Sprintf("busy%d", i)))
+	}
+	sched.Add(enqueueRequest(quiet, "quiet1"))
+	sched.Add(client.Request{Op: client.SubscribeOp})
+	sched.Add(enqueueRequest(nil, "federated1"))
+	sched.Add(client.Request{Op: client.RequeueOp})
+
+	c.Check(schedule(sched), DeepEquals, []string{
+		"op0", "op3", // control requests first, in order
+		"busy1", "quiet1", "federated1", "busy2", "busy3", "busy4",
+	})
+	c.Check(sched.Empty(), Equals, true)
+	c.Check(sched.lanes, HasLen, 0)
+}
+
+func (s *SchedulerSuite) TestConnectionOrder(c *C) {
+	busy, quiet := &client.Conn{}, &client.Conn{}
+	sched := newScheduler(nil)
+
+	// the requests of a connection are not reordered, but the control
+	// requests of other connections are still handled first
+	sched.Add(enqueueRequest(busy, "busy1"))
+	sched.Add(enqueueRequest(busy, "busy2"))
+	sched.Add(client.Request{Op: client.RequeueOp, Conn: busy})
+	sched.Add(enqueueRequest(busy, "busy3"))
+	sched.Add(client.Request{Op: client.DisconnectedOp, Conn: busy})
+	sched.Add(client.Request{Op: client.SubscribeOp, Conn: quiet})
+
+	c.Check(schedule(sched), DeepEquals, []string{
+		"op" + client.SubscribeOp.String(),
+		"busy1", "busy2", "op" + client.RequeueOp.String(),
+		"busy3", "op" + client.DisconnectedOp.String(),
+	})
+	c.Check(sched.Empty(), Equals, true)
+	c.Check(sched.lanes, HasLen, 0)
+}
+
+func (s *SchedulerSuite) TestWeighted(c *C) {
+	heavy, light := &client.Conn{}, &client.Conn{}
+	sched := newScheduler(func(conn *client.Conn) int {
+		if conn == heavy {
+			return 3
+		}
+		return 0
+	})
+
+	for i := 1; i <= 4; i++ {
+		sched.Add(enqueueRequest(heavy, fmt.Sprintf("h%d", i)))
+		sched.Add(enqueueRequest(light, fmt.Sprintf("l%d", i)))
+	}
+
+	c.Check(schedule(sched), DeepEquals, []string{
+		"h1", "h2", "h3", "l1", "h4", "l2", "l3", "l4",
+	})
+}
+
+// recordingStorage is an in-memory queue storage that records
+// the bodies of the frames in the order they are enqueued.
+type recordingStorage struct {
+	queue.Storage
+	mutex  sync.Mutex
+	bodies []string
+}
+
+func (rs *recordingStorage) Enqueue(queue string, f *frame.Frame) error {
+	rs.mutex.Lock()
+	rs.bodies = append(rs.bodies, string(f.Body))
+	rs.mutex.Unlock()
+	return rs.Storage.Enqueue(queue, f)
+}
+
+func (rs *recordingStorage) Bodies() []string {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	return append([]string(nil), rs.bodies...)
+}
+
+// waitRequests waits until the request processor has n pending requests.
+func waitRequests(c *C, proc *requestProcessor, n int) {
+	for i := 0; i < 500; i++ {
+		if len(proc.ch) == n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Fatalf("timed out waiting for %d requests, have %d", n, len(proc.ch))
+}
+
+// TestLightConnectionUnderLoad checks that a message sent by a light
+// connection is not handled after the backlog of a flooding connection,
+// as it would be if requests were handled in the order received.
+func (s *SchedulerSuite) TestLightConnectionUnderLoad(c *C) {
+	l := listenLocal(c)
+	defer l.Close()
+	storage := &recordingStorage{Storage: queue.NewMemoryQueueStorage()}
+	server := &Server{QueueStorage: storage}
+	go server.Serve(l)
+
+	flood := dialBroker(c, l)
+	defer flood.Disconnect()
+	light := dialBroker(c, l)
+	defer light.Disconnect()
+
+	proc, err := server.processor()
+	c.Assert(err, IsNil)
+
+	// hold the request processor, so that the requests
+	// of both connections wait to be scheduled together
+	held, release := make(chan struct{}), make(chan struct{})
+	go proc.control(func() error {
+		close(held)
+		<-release
+		return nil
+	})
+	<-held
+
+	const backlog = 20
+	for i := 0; i < backlog; i++ {
+		err = flood.Send("/queue/flood", "text/plain", []byte(fmt.Sprintf("flood%d", i)))
+		c.Assert(err, IsNil)
+	}
+	waitRequests(c, proc, backlog)
+	c.Assert(light.Send("/queue/light", "text/plain", []byte("light")), IsNil)
+	waitRequests(c, proc, backlog+1)
+	close(release)
+
+	var bodies []string
+	for i := 0; i < 500 && len(bodies) < backlog+1; i++ {
+		time.Sleep(10 * time.Millisecond)
+		bodies = storage.Bodies()
+	}
+	c.Assert(bodies, HasLen, backlog+1)
+
+	// the light connection takes its turn after the first message
+	// of the flooding connection, not after the whole backlog
+	c.Check(bodies[:2], DeepEquals, []string{"flood0", "light"})
+}
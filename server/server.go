@@ -4,18 +4,24 @@ Package server contains a simple STOMP server implementation.
 package server
 
 import (
+	"crypto/tls"
+	"errors"
 	"net"
+	"sync"
 	"time"
 
 	"github.com/go-stomp/stomp/v3"
 	"github.com/go-stomp/stomp/v3/internal/log"
+	"github.com/go-stomp/stomp/v3/server/client"
+	"github.com/go-stomp/stomp/v3/server/queue"
 )
 
 // The STOMP server has the concept of queues and topics. A message
 // sent to a queue destination will be transmitted to the next available
 // client that has subscribed. A message sent to a topic will be
 // transmitted to all subscribers that are currently subscribed to the
-// topic.
+// topic, or to a wildcard destination that matches it, such as
+// "/topic/prices.*".
 //
 // Destinations that start with this prefix are considered to be queues.
 // Destinations that do not start with this prefix are considered to be topics.
@@ -44,9 +50,73 @@ type Server struct {
 	Authenticator Authenticator // Authenticates login/passcodes. If nil no authentication is performed
 	QueueStorage  QueueStorage  // Implementation of queue storage. If nil, in-memory queues are used.
 	HeartBeat     time.Duration // Preferred value for heart-beat read/write timeout, if zero, then DefaultHeartBeat.
-	Log           stomp.Logger
+	Federation    *Federation   // Links to peer brokers. If nil the server is not federated.
+	Compression   bool          // Accept offers from clients to compress connections.
+
+	// Maximum number of messages buffered by each paused topic. Messages
+	// sent to a paused topic beyond this number are dropped. If zero,
+	// all messages sent to a paused topic are dropped.
+	PausedTopicBuffer int
+
+	// Weight of the messages sent by a client connection when the server
+	// schedules the handling of messages from different connections. A
+	// connection with weight n has up to n messages handled in turn.
+	// If nil, or the weight is less than one, the weight is one.
+	ProducerWeight func(conn *client.Conn) int
+
+	// Returns the policy that chooses which subscription to a queue is
+	// sent each message. Called once for each queue, when it is created.
+	// If nil, or it returns nil, each message is sent to the subscription
+	// that has been ready for the longest time.
+	DispatchPolicy func(destination string) queue.DispatchPolicy
+
+	// Limits on the transactions of each client connection.
+	// The zero value means no limits.
+	TransactionLimits client.TransactionLimits
+
+	// Called when the server deletes a queue because of its attributes,
+	// which are set by DeclareQueue or the AutoDeleteHeader and ExpiresHeader
+	// entries of a SUBSCRIBE frame. Called on the go-routine that processes
+	// requests, so it must not block or call the methods of the server.
+	OnQueueDeleted func(event QueueDeletedEvent)
+
+	// Exports the spans recorded for messages sent with a sampled trace
+	// context in the client.TraceparentHeader entry. If nil, spans are
+	// not recorded and the trace context is forwarded unchanged.
+	SpanExporter client.SpanExporter
+
+	// Recurring publications, which start when the server starts serving.
+	Schedules []*Schedule
+
+	// Number of times a message can be NACKed before it is discarded
+	// instead of requeued. If zero, NACKed messages are always requeued.
+	NackLimit int
+
+	// How long the server keeps the subscriptions and unacknowledged
+	// messages of a client that asked for a resumable session after its
+	// connection is lost, for the client to connect again and resume the
+	// session. If zero, sessions cannot be resumed.
+	SessionGracePeriod time.Duration
+
+	// Base TLS configuration of ServeTLS and ListenAndServeTLS. Its
+	// certificates are replaced by those loaded from the files.
+	TLSConfig *tls.Config
+
+	// Interval between checks of the certificate files of ServeTLS for
+	// changes. If zero, DefaultCertificateCheckInterval. If negative, the
+	// files are only loaded again when ReloadCertificate is called.
+	CertificateCheckInterval time.Duration
+
+	Log stomp.Logger
+
+	mutex  sync.Mutex
+	proc   *requestProcessor  // nil until Serve is called
+	tracer tracer             // traces started by StartTrace
+	certs  *CertificateSource // nil unless ServeTLS is called
 }
 
+var errNotServing = errors.New("server is not serving")
+
 // ListenAndServe listens on the TCP network address addr and then calls Serve.
 func ListenAndServe(addr string) error {
 	s := &Server{Addr: addr}
@@ -84,6 +154,69 @@ func (s *Server) Serve(l net.Listener) error {
 		s.Log = log.StdLogger{}
 	}
 
+	if s.Federation != nil {
+		if s.Federation.BrokerId == "" {
+			return errMissingBrokerId
+		}
+		if s.Federation.Login == "" || s.Authenticator == nil {
+			return errMissingFederationAuth
+		}
+	}
+	// the schedules are validated, and their cron expressions
+	// parsed, once here
+	schedules := make([]*scheduleRun, 0, len(s.Schedules))
+	for _, schedule := range s.Schedules {
+		run, err := newScheduleRun(schedule)
+		if err != nil {
+			return err
+		}
+		schedules = append(schedules, run)
+	}
+
 	proc := newRequestProcessor(s)
+	proc.schedules = schedules
+	s.mutex.Lock()
+	s.proc = proc
+	s.mutex.Unlock()
 	return proc.Serve(l)
 }
+
+// PauseDestination stops the delivery of messages from the queue or topic
+// with the given name, without affecting its subscriptions. Messages sent
+// to a paused queue are stored until the queue is resumed. Messages sent
+// to a paused topic are buffered or dropped, depending on PausedTopicBuffer.
+//
+// A destination remains paused after the server restarts only if the
+// QueueStorage implements PauseStorage. The queue storages provided by
+// this package do not, because they do not keep messages across restarts
+// either, so with them all destinations are resumed when the server starts.
+func (s *Server) PauseDestination(name string) error {
+	proc, err := s.processor()
+	if err != nil {
+		return err
+	}
+	return proc.control(func() error {
+		return proc.pause(name)
+	})
+}
+
+// ResumeDestination resumes the delivery of messages from a queue
+// or topic paused by PauseDestination.
+func (s *Server) ResumeDestination(name string) error {
+	proc, err := s.processor()
+	if err != nil {
+		return err
+	}
+	return proc.control(func() error {
+		return proc.resume(name)
+	})
+}
+
+func (s *Server) processor() (*requestProcessor, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.proc == nil {
+		return nil, errNotServing
+	}
+	return s.proc, nil
+}
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/frame"
 	. "gopkg.in/check.v1"
 )
 
@@ -78,6 +79,23 @@ func (s *ServerSuite) TestHeartBeatingTolerance(c *C) {
 	c.Assert(err, IsNil)
 }
 
+func (s *ServerSuite) TestUnsubscribeReceipt(c *C) {
+	l := listenLocal(c)
+	defer l.Close()
+	go (&Server{}).Serve(l)
+
+	nc, reader, writer := rawConnect(c, l)
+	defer nc.Close()
+	c.Assert(writer.Write(frame.New(frame.SUBSCRIBE, frame.Destination, "/queue/unsub", frame.Id, "1")), IsNil)
+	c.Assert(writer.Write(frame.New(frame.UNSUBSCRIBE, frame.Id, "1", frame.Receipt, "r1")), IsNil)
+
+	nc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	f, err := reader.Read()
+	c.Assert(err, IsNil)
+	c.Check(f.Command, Equals, frame.RECEIPT)
+	c.Check(f.Header.Get(frame.ReceiptId), Equals, "r1")
+}
+
 func (s *ServerSuite) TestSendToQueuesAndTopics(c *C) {
 	ch := make(chan bool, 2)
 	println("number cpus:", runtime.NumCPU())
@@ -0,0 +1,390 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/frame"
+	. "gopkg.in/check.v1"
+)
+
+type SessionSuite struct{}
+
+var _ = Suite(&SessionSuite{})
+
+// A dialer for a resumable client, which keeps the
+// current connection so that it can be broken.
+type breakableDialer struct {
+	addr    string
+	mutex   sync.Mutex
+	conn    net.Conn
+	refused bool // if true, connections are refused
+}
+
+func (d *breakableDialer) dial() (io.ReadWriteCloser, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.refused {
+		return nil, errors.New("connection refused")
+	}
+	conn, err := net.Dial("tcp", d.addr)
+	d.conn = conn
+	return conn, err
+}
+
+// Breaks the current connection, and refuses new connections if refuse is true.
+func (d *breakableDialer) breakConn(refuse bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.refused = refuse
+	d.conn.Close()
+}
+
+func (d *breakableDialer) refuse(refused bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.refused = refused
+}
+
+// Connects a resumable client to the server listening on l.
+func dialResumable(c *C, l net.Listener, opts ...func(*stomp.Conn) error) (*stomp.Conn, *breakableDialer) {
+	d := &breakableDialer{addr: l.Addr().String()}
+	conn, err := d.dial()
+	c.Assert(err, IsNil)
+	opts = append([]func(*stomp.Conn) error{stomp.ConnOpt.Resumable(d.dial)}, opts...)
+	client, err := stomp.Connect(conn, opts...)
+	c.Assert(err, IsNil)
+	return client, d
+}
+
+// Starts the server, and returns its listener and a client connection.
+func startSessionServer(c *C, s *Server) (*stomp.Conn, net.Listener) {
+	l := listenLocal(c)
+	go s.Serve(l)
+	conn := dialBroker(c, l)
+	return conn, l
+}
+
+// Waits until the server has n detached sessions.
+func waitDetached(c *C, s *Server, n int) {
+	proc, err := s.processor()
+	c.Assert(err, IsNil)
+	for i := 0; i < 500; i++ {
+		if proc.sessions.Detached() == n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Fatalf("timed out waiting for %d detached sessions", n)
+}
+
+func (s *SessionSuite) TestResume(c *C) {
+	server := &Server{SessionGracePeriod: 5 * time.Second}
+	sender, l := startSessionServer(c, server)
+	defer l.Close()
+	defer sender.Disconnect()
+
+	var headers *frame.Header
+	client, d := dialResumable(c, l, stomp.ConnOpt.ResponseHeaders(func(h *frame.Header) {
+		headers = h
+	}))
+	defer client.Disconnect()
+	c.Check(headers.Get(frame.ResumeToken), Not(Equals), "")
+	c.Check(headers.Get(frame.Resumed), Equals, "")
+
+	queueSub, err := client.Subscribe("/queue/resume", stomp.AckClientIndividual)
+	c.Assert(err, IsNil)
+	topicSub, err := client.Subscribe("/topic/resume", stomp.AckAuto)
+	c.Assert(err, IsNil)
+	err = sender.Send("/queue/resume", "text/plain", []byte("first"))
+	c.Assert(err, IsNil)
+	first := receive(c, queueSub)
+	c.Check(string(first.Body), Equals, "first")
+
+	// the unacknowledged message is kept for the session, rather than
+	// being requeued to another consumer
+	d.breakConn(false)
+	other, err := sender.Subscribe("/queue/resume", stomp.AckAuto)
+	c.Assert(err, IsNil)
+	expectNone(c, other)
+	c.Assert(other.Unsubscribe(), IsNil)
+
+	// and is delivered again on the resumed session, where
+	// the first delivery can still be acknowledged
+	again := receive(c, queueSub)
+	c.Check(string(again.Body), Equals, "first")
+	c.Check(again.Header.Get(frame.Ack), Equals, first.Header.Get(frame.Ack))
+	c.Assert(client.Ack(first), IsNil)
+	err = sender.Send("/queue/resume", "text/plain", []byte("second"))
+	c.Assert(err, IsNil)
+	second := receive(c, queueSub)
+	c.Check(string(second.Body), Equals, "second")
+	c.Assert(client.Ack(second), IsNil)
+	expectNone(c, queueSub)
+
+	// the topic subscription has been kept as well
+	err = sender.Send("/topic/resume", "text/plain", []byte("topic"))
+	c.Assert(err, IsNil)
+	expectOnce(c, topicSub, "topic")
+	c.Check(client.Session(), Equals, headers.Get(frame.Session))
+
+	// and unsubscribing works with the resumed session
+	c.Assert(topicSub.Unsubscribe(), IsNil)
+	waitDetached(c, server, 0)
+}
+
+func (s *SessionSuite) TestExpired(c *C) {
+	server := &Server{SessionGracePeriod: 50 * time.Millisecond}
+	sender, l := startSessionServer(c, server)
+	defer l.Close()
+	defer sender.Disconnect()
+
+	client, d := dialResumable(c, l)
+	defer client.Disconnect()
+	sub, err := client.Subscribe("/queue/expired", stomp.AckClientIndividual)
+	c.Assert(err, IsNil)
+	err = sender.Send("/queue/expired", "text/plain", []byte("first"))
+	c.Assert(err, IsNil)
+	receive(c, sub)
+
+	// after the grace period, the message is requeued
+	d.breakConn(true)
+	waitDetached(c, server, 1)
+	other, err := sender.Subscribe("/queue/expired", stomp.AckAuto)
+	c.Assert(err, IsNil)
+	expectOnce(c, other, "first")
+	waitDetached(c, server, 0)
+	c.Assert(other.Unsubscribe(), IsNil)
+
+	// and the client subscribes again in a new session
+	d.refuse(false)
+	err = sender.Send("/queue/expired", "text/plain", []byte("second"))
+	c.Assert(err, IsNil)
+	msg := receive(c, sub)
+	c.Check(string(msg.Body), Equals, "second")
+	c.Assert(client.Ack(msg), IsNil)
+}
+
+func (s *SessionSuite) TestAutoDeleteQueue(c *C) {
+	events := make(chan QueueDeletedEvent, 1)
+	server := &Server{
+		SessionGracePeriod: 500 * time.Millisecond,
+		OnQueueDeleted: func(event QueueDeletedEvent) {
+			events <- event
+		},
+	}
+	sender, l := startSessionServer(c, server)
+	defer l.Close()
+	defer sender.Disconnect()
+
+	client, d := dialResumable(c, l)
+	defer client.Disconnect()
+	sub, err := client.Subscribe("/queue/auto", stomp.AckClientIndividual,
+		stomp.SubscribeOpt.Header(AutoDeleteHeader, "true"))
+	c.Assert(err, IsNil)
+	for _, body := range []string{"1", "2"} {
+		err = sender.Send("/queue/auto", "text/plain", []byte(body),
+			stomp.SendOpt.Receipt)
+		c.Assert(err, IsNil)
+	}
+	first := receive(c, sub)
+
+	// the queue is not deleted while the session is detached
+	d.breakConn(true)
+	waitDetached(c, server, 1)
+	select {
+	case event := <-events:
+		c.Fatalf("queue deleted while detached: %+v", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// so neither message is lost when the session is resumed
+	d.refuse(false)
+	again := receive(c, sub)
+	c.Check(string(again.Body), Equals, "1")
+	c.Assert(client.Ack(first), IsNil)
+	second := receive(c, sub)
+	c.Check(string(second.Body), Equals, "2")
+
+	// the queue is deleted when a detached session ends, discarding
+	// the unacknowledged message, as when the consumer unsubscribes
+	d.breakConn(true)
+	c.Check(expectDeleted(c, events), Equals, QueueDeletedEvent{
+		Name:      "/queue/auto",
+		Reason:    QueueAutoDeleted,
+		Discarded: 1,
+	})
+	waitDetached(c, server, 0)
+}
+
+// Connects to the server listening on l with a resumable session, which
+// resumes the session with the token if it is not empty. Returns the
+// CONNECTED frame as well as the connection and its reader and writer.
+func rawConnectResumable(c *C, l net.Listener, token string) (net.Conn, *frame.Reader, *frame.Writer, *frame.Frame) {
+	nc, err := net.Dial("tcp", l.Addr().String())
+	c.Assert(err, IsNil)
+	reader, writer := frame.NewReader(nc), frame.NewWriter(nc)
+	f := frame.New(frame.CONNECT, frame.AcceptVersion, "1.2", frame.Resumable, "true")
+	if token != "" {
+		f.Header.Add(frame.ResumeToken, token)
+	}
+	c.Assert(writer.Write(f), IsNil)
+	connected, err := reader.Read()
+	c.Assert(err, IsNil)
+	c.Assert(connected.Command, Equals, frame.CONNECTED)
+	return nc, reader, writer, connected
+}
+
+func (s *SessionSuite) TestResumeAttached(c *C) {
+	server := &Server{SessionGracePeriod: 5 * time.Second}
+	sender, l := startSessionServer(c, server)
+	defer l.Close()
+	defer sender.Disconnect()
+
+	// the client resumes the session from two connections at once,
+	// before the server has noticed that the first connection is lost
+	nc, reader, _, connected := rawConnectResumable(c, l, "")
+	token := connected.Header.Get(frame.ResumeToken)
+	var wg sync.WaitGroup
+	resumed := make(chan net.Conn, 2)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			nc, _, _, connected := rawConnectResumable(c, l, token)
+			if connected.Header.Get(frame.Resumed) == "true" {
+				resumed <- nc
+			} else {
+				nc.Close()
+			}
+		}()
+	}
+	wg.Wait()
+	close(resumed)
+
+	// the previous connection is closed by the server
+	nc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err := reader.Read()
+	c.Check(err, NotNil)
+	nc.Close()
+
+	n := 0
+	for nc := range resumed {
+		n++
+		nc.Close()
+	}
+	c.Check(n > 0, Equals, true)
+
+	// a connection that could not resume the session has a new one
+	waitDetached(c, server, 1+2-n)
+}
+
+func (s *SessionSuite) TestRepeatedSubscribe(c *C) {
+	server := &Server{SessionGracePeriod: 5 * time.Second}
+	sender, l := startSessionServer(c, server)
+	defer l.Close()
+	defer sender.Disconnect()
+
+	subscribe := func(w *frame.Writer, ack string) {
+		f := frame.New(frame.SUBSCRIBE,
+			frame.Id, "1",
+			frame.Destination, "/queue/repeat",
+			frame.Ack, ack)
+		c.Assert(w.Write(f), IsNil)
+	}
+	expectReceipt := func(nc net.Conn, r *frame.Reader, w *frame.Writer) {
+		f := frame.New(frame.SEND, frame.Destination, "/queue/other", frame.Receipt, "r1")
+		c.Assert(w.Write(f), IsNil)
+		nc.SetReadDeadline(time.Now().Add(5 * time.Second))
+		f, err := r.Read()
+		c.Assert(err, IsNil)
+		c.Assert(f.Command, Equals, frame.RECEIPT, Commentf("%s", f.Header.Get(frame.Message)))
+	}
+
+	// a subscription cannot be repeated without resuming the session
+	nc, reader, writer, _ := rawConnectResumable(c, l, "")
+	subscribe(writer, frame.AckClient)
+	subscribe(writer, frame.AckClient)
+	c.Check(expectError(c, nc, reader), Equals, "subscription already exists")
+	nc.Close()
+
+	// after resuming, the same subscription can be repeated once,
+	// until the client sends another frame
+	nc, reader, writer, connected := rawConnectResumable(c, l, "")
+	token := connected.Header.Get(frame.ResumeToken)
+	subscribe(writer, frame.AckClient)
+	expectReceipt(nc, reader, writer)
+	nc.Close()
+	waitDetached(c, server, 1)
+	nc, reader, writer, connected = rawConnectResumable(c, l, token)
+	c.Check(connected.Header.Get(frame.Resumed), Equals, "true")
+	subscribe(writer, frame.AckClient)
+	expectReceipt(nc, reader, writer)
+	subscribe(writer, frame.AckClient)
+	c.Check(expectError(c, nc, reader), Equals, "subscription already exists")
+	nc.Close()
+
+	// and only if the header entries are the same
+	nc, reader, writer, connected = rawConnectResumable(c, l, "")
+	token = connected.Header.Get(frame.ResumeToken)
+	subscribe(writer, frame.AckClient)
+	expectReceipt(nc, reader, writer)
+	nc.Close()
+	waitDetached(c, server, 1)
+	nc, reader, writer, _ = rawConnectResumable(c, l, token)
+	subscribe(writer, frame.AckAuto)
+	c.Check(expectError(c, nc, reader), Equals, "subscription already exists")
+	nc.Close()
+}
+
+func (s *SessionSuite) TestDisconnect(c *C) {
+	server := &Server{SessionGracePeriod: 5 * time.Second}
+	sender, l := startSessionServer(c, server)
+	defer l.Close()
+	defer sender.Disconnect()
+
+	client, _ := dialResumable(c, l)
+	sub, err := client.Subscribe("/queue/disconnect", stomp.AckClientIndividual)
+	c.Assert(err, IsNil)
+	err = sender.Send("/queue/disconnect", "text/plain", []byte("first"))
+	c.Assert(err, IsNil)
+	receive(c, sub)
+
+	// a session that ends with DISCONNECT cannot be resumed
+	c.Assert(client.Disconnect(), IsNil)
+	other, err := sender.Subscribe("/queue/disconnect", stomp.AckAuto)
+	c.Assert(err, IsNil)
+	expectOnce(c, other, "first")
+	waitDetached(c, server, 0)
+}
+
+func (s *SessionSuite) TestNotResumable(c *C) {
+	server := &Server{}
+	sender, l := startSessionServer(c, server)
+	defer l.Close()
+	defer sender.Disconnect()
+
+	var headers *frame.Header
+	client, d := dialResumable(c, l, stomp.ConnOpt.ResponseHeaders(func(h *frame.Header) {
+		headers = h
+	}))
+	c.Check(headers.Get(frame.ResumeToken), Equals, "")
+	sub, err := client.Subscribe("/queue/none", stomp.AckAuto)
+	c.Assert(err, IsNil)
+	err = sender.Send("/queue/none", "text/plain", []byte("first"))
+	c.Assert(err, IsNil)
+	receive(c, sub)
+
+	// without a resume token, the connection is not resumed
+	d.breakConn(false)
+	select {
+	case msg := <-sub.C:
+		c.Check(msg.Err, NotNil)
+	case <-time.After(5 * time.Second):
+		c.Fatal("timed out waiting for subscription to fail")
+	}
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"time"
+
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/server/client"
+	. "gopkg.in/check.v1"
+)
+
+type SpanSuite struct{}
+
+var _ = Suite(&SpanSuite{})
+
+type spanChannel chan client.Span
+
+func (ch spanChannel) ExportSpan(span client.Span) {
+	ch <- span
+}
+
+func expectSpan(c *C, spans spanChannel, name string) client.Span {
+	select {
+	case span := <-spans:
+		c.Assert(span.Name, Equals, name)
+		c.Check(span.End.Before(span.Start), Equals, false)
+		return span
+	case <-time.After(5 * time.Second):
+		c.Fatalf("timed out waiting for %s span", name)
+	}
+	return client.Span{}
+}
+
+func (s *SpanSuite) TestQueueSpans(c *C) {
+	spans := make(spanChannel, 10)
+	server := &Server{SpanExporter: spans}
+	conn := startServer(c, server)
+	defer conn.Disconnect()
+
+	sub, err := conn.Subscribe("/queue/spans", stomp.AckAuto)
+	c.Assert(err, IsNil)
+	err = conn.Send("/queue/spans", "text/plain", []byte("1"),
+		stomp.SendOpt.Header(client.TraceparentHeader,
+			"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"))
+	c.Assert(err, IsNil)
+
+	var msg *stomp.Message
+	select {
+	case msg = <-sub.C:
+		c.Assert(msg.Err, IsNil)
+	case <-time.After(5 * time.Second):
+		c.Fatal("timed out waiting for message")
+	}
+
+	enqueue := expectSpan(c, spans, client.SpanEnqueue)
+	c.Check(enqueue.TraceId, Equals, "4bf92f3577b34da6a3ce929d0e0e4736")
+	c.Check(enqueue.ParentId, Equals, "00f067aa0ba902b7")
+	c.Check(enqueue.Destination, Equals, "/queue/spans")
+
+	queue := expectSpan(c, spans, client.SpanQueue)
+	c.Check(queue.TraceId, Equals, enqueue.TraceId)
+	c.Check(queue.ParentId, Equals, enqueue.SpanId)
+
+	deliver := expectSpan(c, spans, client.SpanDeliver)
+	c.Check(deliver.TraceId, Equals, enqueue.TraceId)
+	c.Check(deliver.ParentId, Equals, queue.SpanId)
+	c.Check(deliver.Subscription, Equals, queue.Subscription)
+
+	// the consumer receives the trace context of the delivery span
+	c.Check(msg.Header.Get(client.TraceparentHeader), Equals,
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-"+deliver.SpanId+"-01")
+	_, ok := msg.Header.Contains("x-broker-span")
+	c.Check(ok, Equals, false)
+}
+
+func (s *SpanSuite) TestTopicSpans(c *C) {
+	spans := make(spanChannel, 10)
+	server := &Server{SpanExporter: spans}
+	conn := startServer(c, server)
+	defer conn.Disconnect()
+
+	sub1, err := conn.Subscribe("/topic/spans", stomp.AckAuto)
+	c.Assert(err, IsNil)
+	sub2, err := conn.Subscribe("/topic/spans", stomp.AckAuto)
+	c.Assert(err, IsNil)
+	err = conn.Send("/topic/spans", "text/plain", []byte("1"),
+		stomp.SendOpt.Header(client.TraceparentHeader,
+			"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"))
+	c.Assert(err, IsNil)
+	expectOnce(c, sub1, "1")
+	expectOnce(c, sub2, "1")
+
+	expectSpan(c, spans, client.SpanEnqueue)
+	ids := make(map[string]bool)
+	for i := 0; i < 4; i++ {
+		span := <-spans
+		c.Check(ids[span.SpanId], Equals, false)
+		ids[span.SpanId] = true
+	}
+}
+
+func (s *SpanSuite) TestNotSampled(c *C) {
+	spans := make(spanChannel, 10)
+	server := &Server{SpanExporter: spans}
+	conn := startServer(c, server)
+	defer conn.Disconnect()
+
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00"
+	sub, err := conn.Subscribe("/queue/unsampled", stomp.AckAuto)
+	c.Assert(err, IsNil)
+	err = conn.Send("/queue/unsampled", "text/plain", []byte("1"),
+		stomp.SendOpt.Header(client.TraceparentHeader, traceparent))
+	c.Assert(err, IsNil)
+
+	select {
+	case msg := <-sub.C:
+		c.Assert(msg.Err, IsNil)
+		c.Check(msg.Header.Get(client.TraceparentHeader), Equals, traceparent)
+	case <-time.After(5 * time.Second):
+		c.Fatal("timed out waiting for message")
+	}
+	c.Check(spans, HasLen, 0)
+}
+
+func (s *SpanSuite) TestForgedBrokerSpan(c *C) {
+	spans := make(spanChannel, 10)
+	server := &Server{SpanExporter: spans}
+	conn := startServer(c, server)
+	defer conn.Disconnect()
+
+	sub, err := conn.Subscribe("/queue/forged", stomp.AckAuto)
+	c.Assert(err, IsNil)
+	err = conn.Send("/queue/forged", "text/plain", []byte("1"),
+		stomp.SendOpt.Header("x-broker-span", "00f067aa0ba902b7;1700000000000000000"))
+	c.Assert(err, IsNil)
+
+	select {
+	case msg := <-sub.C:
+		c.Assert(msg.Err, IsNil)
+		_, ok := msg.Header.Contains("x-broker-span")
+		c.Check(ok, Equals, false)
+	case <-time.After(5 * time.Second):
+		c.Fatal("timed out waiting for message")
+	}
+	c.Check(spans, HasLen, 0)
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"sort"
+)
+
+// DestinationStats contains statistics for a queue or topic.
+type DestinationStats struct {
+	Name          string // Destination name
+	Paused        bool   // Delivery has been paused by PauseDestination
+	Subscriptions int    // Subscriptions ready to receive a message
+	Pending       int    // Messages queued, or buffered by a paused topic
+	Dropped       uint64 // Messages dropped by a paused topic
+}
+
+// Stats contains statistics for a server.
+type Stats struct {
+	Queues           []DestinationStats // Sorted by name
+	Topics           []DestinationStats // Sorted by name
+	Connections      int                // Connected clients
+	Transactions     int                // Transactions in progress
+	TransactionBytes int64              // Approximate size of the frames in transactions in progress
+	AutoDeleted      int                // Queues deleted when their last subscription was unsubscribed
+	Expired          int                // Queues deleted when they expired
+	Schedules        []ScheduleStats    // In the order of Server.Schedules
+}
+
+// Stats returns statistics for the queues and topics of the server.
+func (s *Server) Stats() (Stats, error) {
+	proc, err := s.processor()
+	if err != nil {
+		return Stats{}, err
+	}
+	var stats Stats
+	err = proc.control(func() error {
+		stats = proc.stats()
+		return nil
+	})
+	return stats, err
+}
+
+func (proc *requestProcessor) stats() Stats {
+	var stats Stats
+	for _, q := range proc.qm.Queues() {
+		stats.Queues = append(stats.Queues, DestinationStats{
+			Name:          q.Destination(),
+			Paused:        q.Paused(),
+			Subscriptions: q.Subscriptions(),
+			Pending:       q.Pending(),
+		})
+	}
+	for _, t := range proc.tm.Topics() {
+		stats.Topics = append(stats.Topics, DestinationStats{
+			Name:          t.Destination(),
+			Paused:        t.Paused(),
+			Subscriptions: t.Subscriptions(),
+			Pending:       t.Pending(),
+			Dropped:       t.Dropped(),
+		})
+	}
+	stats.Schedules = proc.scheduleStats()
+	stats.AutoDeleted = proc.autoDeleted
+	stats.Expired = proc.expired
+	for conn := range proc.conns {
+		stats.Connections++
+		stats.Transactions += conn.Transactions()
+		stats.TransactionBytes += conn.TransactionBytes()
+	}
+	sort.Slice(stats.Queues, func(i, j int) bool {
+		return stats.Queues[i].Name < stats.Queues[j].Name
+	})
+	sort.Slice(stats.Topics, func(i, j int) bool {
+		return stats.Topics[i].Name < stats.Topics[j].Name
+	})
+	return stats
+}
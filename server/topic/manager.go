@@ -4,12 +4,16 @@ package topic
 // not created by the package user, rather they are created on demand
 // by the topic manager.
 type Manager struct {
-	topics map[string]*Topic
+	topics    map[string]*Topic
+	wildcards map[string]*Topic // topics with wildcard destinations
 }
 
 // NewManager creates a new topic manager.
 func NewManager() *Manager {
-	tm := &Manager{topics: make(map[string]*Topic)}
+	tm := &Manager{
+		topics:    make(map[string]*Topic),
+		wildcards: make(map[string]*Topic),
+	}
 	return tm
 }
 
@@ -19,6 +23,31 @@ func (tm *Manager) Find(destination string) *Topic {
 	if !ok {
 		t = newTopic(destination)
 		tm.topics[destination] = t
+		if IsWildcard(destination) {
+			tm.wildcards[destination] = t
+		}
 	}
 	return t
 }
+
+// Matching returns the topic for the given destination, creating it if
+// necessary, followed by every topic with a wildcard destination that
+// matches it. A message sent to the destination is sent to each of them.
+func (tm *Manager) Matching(destination string) []*Topic {
+	topics := []*Topic{tm.Find(destination)}
+	for pattern, t := range tm.wildcards {
+		if pattern != destination && Match(pattern, destination) {
+			topics = append(topics, t)
+		}
+	}
+	return topics
+}
+
+// Topics returns all of the topics that have been created.
+func (tm *Manager) Topics() []*Topic {
+	topics := make([]*Topic, 0, len(tm.topics))
+	for _, t := range tm.topics {
+		topics = append(topics, t)
+	}
+	return topics
+}
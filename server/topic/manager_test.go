@@ -19,3 +19,18 @@ func (s *ManagerSuite) TestManager(c *C) {
 
 	c.Assert(mgr.Find("topic1"), Equals, t1)
 }
+
+func (s *ManagerSuite) TestMatching(c *C) {
+	mgr := NewManager()
+	gold := mgr.Find("/topic/prices.gold")
+	prices := mgr.Find("/topic/prices.*")
+	mgr.Find("/topic/news.*")
+
+	c.Check(mgr.Matching("/topic/prices.gold"), DeepEquals, []*Topic{gold, prices})
+	c.Check(mgr.Matching("/topic/prices.*"), DeepEquals, []*Topic{prices})
+
+	silver := mgr.Matching("/topic/prices.silver")
+	c.Assert(silver, HasLen, 2)
+	c.Check(silver[0].Destination(), Equals, "/topic/prices.silver")
+	c.Check(silver[1], Equals, prices)
+}
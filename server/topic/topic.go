@@ -15,6 +15,10 @@ import (
 type Topic struct {
 	destination string
 	subs        *list.List
+	paused      bool       // messages are not sent while paused
+	buffer      *list.List // messages sent while paused
+	bufferSize  int        // maximum number of messages buffered while paused
+	dropped     uint64     // number of messages dropped while paused
 }
 
 // Create a new topic -- called from the topic manager only.
@@ -25,6 +29,11 @@ func newTopic(destination string) *Topic {
 	}
 }
 
+// Destination returns the destination of the topic.
+func (t *Topic) Destination() string {
+	return t.destination
+}
+
 // Subscribe adds a subscription to a topic. Any message sent to the
 // topic will be transmitted to the subscription's client until
 // unsubscription occurs.
@@ -45,6 +54,15 @@ func (t *Topic) Unsubscribe(sub Subscription) {
 // Enqueue send a message to the topic. All subscriptions receive a copy
 // of the message.
 func (t *Topic) Enqueue(f *frame.Frame) {
+	if t.paused {
+		if t.buffer.Len() < t.bufferSize {
+			t.buffer.PushBack(f)
+		} else {
+			t.dropped++
+		}
+		return
+	}
+
 	switch t.subs.Len() {
 	case 0:
 	// no subscription, so do nothing
@@ -71,3 +89,51 @@ func (t *Topic) Enqueue(f *frame.Frame) {
 		}
 	}
 }
+
+// Pause stops the topic from sending messages to its subscriptions.
+// Up to bufferSize messages sent to the topic while it is paused are
+// buffered until the topic is resumed, and the remainder are dropped.
+func (t *Topic) Pause(bufferSize int) {
+	if !t.paused {
+		t.paused = true
+		t.buffer = list.New()
+	}
+	t.bufferSize = bufferSize
+}
+
+// Resume sends the messages buffered while the topic was paused
+// to the current subscriptions.
+func (t *Topic) Resume() {
+	if !t.paused {
+		return
+	}
+	t.paused = false
+	buffer := t.buffer
+	t.buffer = nil
+	for e := buffer.Front(); e != nil; e = e.Next() {
+		t.Enqueue(e.Value.(*frame.Frame))
+	}
+}
+
+// Paused returns true if the topic is paused.
+func (t *Topic) Paused() bool {
+	return t.paused
+}
+
+// Subscriptions returns the number of subscriptions to the topic.
+func (t *Topic) Subscriptions() int {
+	return t.subs.Len()
+}
+
+// Pending returns the number of messages buffered while paused.
+func (t *Topic) Pending() int {
+	if t.buffer == nil {
+		return 0
+	}
+	return t.buffer.Len()
+}
+
+// Dropped returns the number of messages dropped while paused.
+func (t *Topic) Dropped() uint64 {
+	return t.dropped
+}
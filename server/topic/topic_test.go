@@ -61,3 +61,31 @@ type fakeSubscription struct {
 func (s *fakeSubscription) SendTopicFrame(f *frame.Frame) {
 	s.Frames = append(s.Frames, f)
 }
+
+func (s *TopicSuite) TestPause(c *C) {
+	sub := &fakeSubscription{}
+
+	topic := newTopic("destination")
+	topic.Subscribe(sub)
+	topic.Pause(2)
+
+	for i := 0; i < 3; i++ {
+		topic.Enqueue(frame.New(frame.MESSAGE, frame.Destination, "destination"))
+	}
+	c.Check(sub.Frames, HasLen, 0)
+	c.Check(topic.Paused(), Equals, true)
+	c.Check(topic.Pending(), Equals, 2)
+	c.Check(topic.Dropped(), Equals, uint64(1))
+
+	topic.Resume()
+	c.Check(sub.Frames, HasLen, 2)
+	c.Check(topic.Paused(), Equals, false)
+	c.Check(topic.Pending(), Equals, 0)
+
+	// without a buffer, all messages are dropped
+	topic.Pause(0)
+	topic.Enqueue(frame.New(frame.MESSAGE, frame.Destination, "destination"))
+	topic.Resume()
+	c.Check(sub.Frames, HasLen, 2)
+	c.Check(topic.Dropped(), Equals, uint64(2))
+}
@@ -0,0 +1,51 @@
+package topic
+
+import (
+	"strings"
+)
+
+// Wildcard characters in topic destinations. A destination is made of
+// segments separated by '/' or '.'. A segment that is "*" matches any
+// one segment, and a last segment that is ">" matches one or more
+// segments, so "/topic/prices.*" matches "/topic/prices.gold", and
+// "/topic/>" matches every destination below "/topic/".
+const (
+	anySegment  = "*"
+	anySegments = ">"
+)
+
+// IsWildcard returns true if the destination contains a wildcard segment.
+func IsWildcard(destination string) bool {
+	for _, s := range segments(destination) {
+		if s == anySegment || s == anySegments {
+			return true
+		}
+	}
+	return false
+}
+
+// Match returns true if the wildcard destination pattern matches the
+// destination. The destination can contain wildcards itself, in which
+// case Match returns true if pattern matches every destination that
+// the destination matches.
+func Match(pattern, destination string) bool {
+	p, d := segments(pattern), segments(destination)
+	for i, s := range p {
+		if s == anySegments && i == len(p)-1 {
+			return len(d) > i
+		}
+		if i == len(d) {
+			return false
+		}
+		if s != d[i] && (s != anySegment || d[i] == anySegments) {
+			return false
+		}
+	}
+	return len(p) == len(d)
+}
+
+func segments(destination string) []string {
+	return strings.FieldsFunc(destination, func(r rune) bool {
+		return r == '/' || r == '.'
+	})
+}
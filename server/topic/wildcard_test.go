@@ -0,0 +1,44 @@
+package topic
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+type WildcardSuite struct{}
+
+var _ = Suite(&WildcardSuite{})
+
+func (s *WildcardSuite) TestIsWildcard(c *C) {
+	c.Check(IsWildcard("/topic/prices.*"), Equals, true)
+	c.Check(IsWildcard("/topic/>"), Equals, true)
+	c.Check(IsWildcard("/topic/prices"), Equals, false)
+	c.Check(IsWildcard("/topic/prices*"), Equals, false)
+}
+
+func (s *WildcardSuite) TestMatches(c *C) {
+	tests := []struct {
+		pattern, destination string
+		matches              bool
+	}{
+		{"/topic/prices.*", "/topic/prices.gold", true},
+		{"/topic/prices.*", "/topic/prices", false},
+		{"/topic/prices.*", "/topic/prices.gold.bid", false},
+		{"/topic/*.gold", "/topic/prices.gold", true},
+		{"/topic/*.gold", "/topic/prices.silver", false},
+		{"/topic/>", "/topic/prices", true},
+		{"/topic/>", "/topic/prices.gold.bid", true},
+		{"/topic/>", "/topic", false},
+		{"/topic/prices.>", "/topic/news.gold", false},
+		{"/topic/prices", "/topic/prices", true},
+
+		// wildcard destinations
+		{"/topic/>", "/topic/prices.*", true},
+		{"/topic/prices.*", "/topic/*.*", false},
+		{"/topic/prices.*", "/topic/prices.>", false},
+		{"/topic/*.*", "/topic/prices.*", true},
+	}
+	for _, t := range tests {
+		c.Check(Match(t.pattern, t.destination), Equals, t.matches,
+			Commentf("%s %s", t.pattern, t.destination))
+	}
+}
@@ -0,0 +1,236 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/go-stomp/stomp/v3/internal/log"
+	"github.com/go-stomp/stomp/v3/server/client"
+)
+
+// Default trace parameters.
+const (
+	// Default duration of a trace that has no frame limit.
+	DefaultTraceDuration = 5 * time.Minute
+
+	// Default maximum number of body bytes logged for each frame.
+	DefaultTraceMaxBody = 256
+)
+
+var errEmptyTraceFilter = errors.New("trace must specify a session, login or remote address")
+
+// A Trace logs the frames read from and written to the client
+// connections that match its session, login and remote address.
+// Empty fields match any connection, but at least one must be
+// specified. A trace is started by Server.StartTrace, and stops
+// after its duration or its maximum number of frames.
+type Trace struct {
+	Session    string        // Session identifier, as sent in the CONNECTED frame
+	Login      string        // Login presented by the client
+	RemoteAddr string        // Remote address of the client, or its host only
+	Duration   time.Duration // Trace stops after this duration; if both limits are zero, DefaultTraceDuration
+	MaxFrames  int           // Trace stops after this number of frames; no limit if zero
+	MaxBody    int           // Maximum number of body bytes logged; DefaultTraceMaxBody if zero
+	Redact     []string      // Header entries whose values are not logged, in addition to passcode
+	Log        stomp.Logger  // Logger for traced frames; the server's logger if nil
+
+	tracer *tracer
+	timer  *time.Timer
+	frames int  // number of frames traced, protected by the tracer's mutex
+	done   bool // has the trace stopped, protected by the tracer's mutex
+}
+
+// StartTrace starts logging the frames of the client connections that
+// match the trace. Connections that match the trace after it has started,
+// including new connections, are traced.
+func (s *Server) StartTrace(t *Trace) error {
+	if t.Session == "" && t.Login == "" && t.RemoteAddr == "" {
+		return errEmptyTraceFilter
+	}
+	if t.Log == nil {
+		t.Log = s.Log
+	}
+	if t.Log == nil {
+		// not serving yet
+		t.Log = log.StdLogger{}
+	}
+	s.tracer.start(t)
+	return nil
+}
+
+// Stop stops the trace if it has not already stopped.
+func (t *Trace) Stop() {
+	if t.tracer != nil {
+		t.tracer.stop(t)
+	}
+}
+
+// Frames returns the number of frames traced.
+func (t *Trace) Frames() int {
+	if t.tracer == nil {
+		return 0
+	}
+	t.tracer.mutex.Lock()
+	defer t.tracer.mutex.Unlock()
+	return t.frames
+}
+
+// Stopped returns true if the trace has stopped.
+func (t *Trace) Stopped() bool {
+	if t.tracer == nil {
+		return false
+	}
+	t.tracer.mutex.Lock()
+	defer t.tracer.mutex.Unlock()
+	return t.done
+}
+
+func (t *Trace) matches(c *client.Conn) bool {
+	if t.Session != "" && t.Session != c.Session() {
+		return false
+	}
+	if t.Login != "" && t.Login != c.Login() {
+		return false
+	}
+	if t.RemoteAddr != "" {
+		addr := c.RemoteAddr().String()
+		if t.RemoteAddr != addr {
+			if host, _, err := net.SplitHostPort(addr); err != nil || host != t.RemoteAddr {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// tracer implements client.Tracer for the traces of a server.
+type tracer struct {
+	active int32 // number of active traces, for a quick check
+	mutex  sync.Mutex
+	traces []*Trace
+}
+
+func (tr *tracer) start(t *Trace) {
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+	t.tracer = tr
+	t.frames = 0
+	t.done = false
+	duration := t.Duration
+	if duration == 0 && t.MaxFrames == 0 {
+		duration = DefaultTraceDuration
+	}
+	if duration > 0 {
+		t.timer = time.AfterFunc(duration, t.Stop)
+	}
+	tr.traces = append(tr.traces, t)
+	atomic.StoreInt32(&tr.active, int32(len(tr.traces)))
+}
+
+func (tr *tracer) stop(t *Trace) {
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+	tr.remove(t)
+}
+
+// remove a trace, the mutex must be held
+func (tr *tracer) remove(t *Trace) {
+	if t.done {
+		return
+	}
+	t.done = true
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+	for i, trace := range tr.traces {
+		if trace == t {
+			tr.traces = append(tr.traces[:i], tr.traces[i+1:]...)
+			break
+		}
+	}
+	atomic.StoreInt32(&tr.active, int32(len(tr.traces)))
+}
+
+func (tr *tracer) TraceFrame(c *client.Conn, f *frame.Frame, outgoing bool) {
+	if atomic.LoadInt32(&tr.active) == 0 {
+		return
+	}
+
+	tr.mutex.Lock()
+	var matched []*Trace
+	for _, t := range tr.traces {
+		if t.matches(c) {
+			matched = append(matched, t)
+		}
+	}
+	for _, t := range matched {
+		t.frames++
+		if t.MaxFrames > 0 && t.frames >= t.MaxFrames {
+			tr.remove(t)
+		}
+	}
+	tr.mutex.Unlock()
+
+	for _, t := range matched {
+		direction := "<<"
+		if outgoing {
+			direction = ">>"
+		}
+		t.Log.Infof("trace %s %s %s %s", c.Session(), c.RemoteAddr(), direction, t.format(f))
+	}
+}
+
+// format a frame for logging, redacting header values
+// and truncating the body
+func (t *Trace) format(f *frame.Frame) string {
+	var buf bytes.Buffer
+	buf.WriteString(f.Command)
+	for i := 0; i < f.Header.Len(); i++ {
+		key, value := f.Header.GetAt(i)
+		if t.redacted(key) {
+			value = "***"
+		}
+		buf.WriteString(" ")
+		buf.WriteString(key)
+		buf.WriteString(":")
+		buf.WriteString(strconv.Quote(value))
+	}
+
+	maxBody := t.MaxBody
+	if maxBody <= 0 {
+		maxBody = DefaultTraceMaxBody
+	}
+	if len(f.Body) > 0 {
+		body := f.Body
+		if len(body) > maxBody {
+			body = body[:maxBody]
+		}
+		buf.WriteString(" body:")
+		buf.WriteString(strconv.Quote(string(body)))
+		if len(body) < len(f.Body) {
+			buf.WriteString("...(")
+			buf.WriteString(strconv.Itoa(len(f.Body)))
+			buf.WriteString(" bytes)")
+		}
+	}
+	return buf.String()
+}
+
+func (t *Trace) redacted(key string) bool {
+	if key == frame.Passcode {
+		return true
+	}
+	for _, r := range t.Redact {
+		if r == key {
+			return true
+		}
+	}
+	return false
+}
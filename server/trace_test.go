@@ -0,0 +1,116 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-stomp/stomp/v3"
+	. "gopkg.in/check.v1"
+)
+
+type TraceSuite struct{}
+
+var _ = Suite(&TraceSuite{})
+
+// traceLogger records the messages logged at info level.
+type traceLogger struct {
+	mutex sync.Mutex
+	lines []string
+}
+
+func (tl *traceLogger) Infof(format string, value ...interface{}) {
+	tl.mutex.Lock()
+	defer tl.mutex.Unlock()
+	tl.lines = append(tl.lines, fmt.Sprintf(format, value...))
+}
+
+func (tl *traceLogger) Lines() []string {
+	tl.mutex.Lock()
+	defer tl.mutex.Unlock()
+	return append([]string(nil), tl.lines...)
+}
+
+func (tl *traceLogger) Debugf(format string, value ...interface{})   {}
+func (tl *traceLogger) Warningf(format string, value ...interface{}) {}
+func (tl *traceLogger) Errorf(format string, value ...interface{})   {}
+func (tl *traceLogger) Debug(message string)                         {}
+func (tl *traceLogger) Info(message string)                          {}
+func (tl *traceLogger) Warning(message string)                       {}
+func (tl *traceLogger) Error(message string)                         {}
+
+func (s *TraceSuite) TestTraceLogin(c *C) {
+	l := listenLocal(c)
+	defer l.Close()
+	server := &Server{}
+	go server.Serve(l)
+
+	tl := &traceLogger{}
+	trace := &Trace{Login: "alice", MaxBody: 5, Redact: []string{"token"}, Log: tl}
+	c.Assert(server.StartTrace(trace), IsNil)
+	defer trace.Stop()
+
+	// connections with other logins are not traced
+	bob, err := stomp.Dial("tcp", l.Addr().String(), stomp.ConnOpt.Login("bob", "secret"))
+	c.Assert(err, IsNil)
+	err = bob.Send("/queue/trace", "text/plain", []byte("from bob"), stomp.SendOpt.Receipt)
+	c.Assert(err, IsNil)
+	bob.Disconnect()
+
+	alice, err := stomp.Dial("tcp", l.Addr().String(), stomp.ConnOpt.Login("alice", "secret"))
+	c.Assert(err, IsNil)
+	err = alice.Send("/queue/trace", "text/plain", []byte("hello world"),
+		stomp.SendOpt.Header("token", "abc123"), stomp.SendOpt.Receipt)
+	c.Assert(err, IsNil)
+	alice.Disconnect()
+
+	lines := tl.Lines()
+	c.Assert(len(lines) >= 4, Equals, true, Commentf("%q", lines))
+	c.Check(strings.Contains(lines[0], "<< CONNECT"), Equals, true)
+	c.Check(strings.Contains(lines[0], `passcode:"***"`), Equals, true)
+	c.Check(strings.Contains(lines[1], ">> CONNECTED"), Equals, true)
+	c.Check(strings.Contains(lines[1], alice.Session()), Equals, true)
+	c.Check(strings.Contains(lines[2], "<< SEND"), Equals, true)
+	c.Check(strings.Contains(lines[2], `token:"***"`), Equals, true)
+	c.Check(strings.Contains(lines[2], `body:"hello"...(11 bytes)`), Equals, true)
+	c.Check(strings.Contains(lines[3], ">> RECEIPT"), Equals, true)
+	for _, line := range lines {
+		c.Check(strings.Contains(line, "secret"), Equals, false)
+		c.Check(strings.Contains(line, "bob"), Equals, false)
+	}
+	c.Check(trace.Frames(), Equals, len(lines))
+}
+
+func (s *TraceSuite) TestTraceLimits(c *C) {
+	l := listenLocal(c)
+	defer l.Close()
+	server := &Server{}
+	go server.Serve(l)
+
+	conn := dialBroker(c, l)
+	defer conn.Disconnect()
+
+	tl := &traceLogger{}
+	trace := &Trace{Session: conn.Session(), MaxFrames: 2, Log: tl}
+	c.Assert(server.StartTrace(trace), IsNil)
+
+	for i := 0; i < 3; i++ {
+		err := conn.Send("/queue/trace", "text/plain", []byte("x"), stomp.SendOpt.Receipt)
+		c.Assert(err, IsNil)
+	}
+	c.Check(tl.Lines(), HasLen, 2)
+	c.Check(trace.Stopped(), Equals, true)
+
+	// the trace stops after its duration
+	trace = &Trace{RemoteAddr: "127.0.0.1", Duration: 50 * time.Millisecond, Log: tl}
+	c.Assert(server.StartTrace(trace), IsNil)
+	c.Assert(conn.Send("/queue/trace", "text/plain", []byte("x"), stomp.SendOpt.Receipt), IsNil)
+	c.Check(trace.Frames(), Equals, 2)
+	time.Sleep(100 * time.Millisecond)
+	c.Check(trace.Stopped(), Equals, true)
+	c.Assert(conn.Send("/queue/trace", "text/plain", []byte("x"), stomp.SendOpt.Receipt), IsNil)
+	c.Check(trace.Frames(), Equals, 2)
+
+	c.Check(server.StartTrace(&Trace{}), Equals, errEmptyTraceFilter)
+}
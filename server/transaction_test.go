@@ -0,0 +1,115 @@
+package server
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/go-stomp/stomp/v3/server/client"
+	. "gopkg.in/check.v1"
+)
+
+type TransactionSuite struct{}
+
+var _ = Suite(&TransactionSuite{})
+
+// rawConnect connects to the server listening on l and returns a frame
+// reader and writer for the connection.
+func rawConnect(c *C, l net.Listener) (net.Conn, *frame.Reader, *frame.Writer) {
+	nc, err := net.Dial("tcp", l.Addr().String())
+	c.Assert(err, IsNil)
+	reader, writer := frame.NewReader(nc), frame.NewWriter(nc)
+	c.Assert(writer.Write(frame.New(frame.CONNECT, frame.AcceptVersion, "1.2")), IsNil)
+	f, err := reader.Read()
+	c.Assert(err, IsNil)
+	c.Assert(f.Command, Equals, frame.CONNECTED)
+	return nc, reader, writer
+}
+
+// expectError reads frames until an ERROR frame, and returns its message.
+func expectError(c *C, nc net.Conn, reader *frame.Reader) string {
+	nc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		f, err := reader.Read()
+		c.Assert(err, IsNil)
+		if f != nil && f.Command == frame.ERROR {
+			return f.Header.Get(frame.Message)
+		}
+	}
+}
+
+func (s *TransactionSuite) TestLimits(c *C) {
+	l := listenLocal(c)
+	defer l.Close()
+	server := &Server{TransactionLimits: client.TransactionLimits{
+		MaxTransactions: 1,
+		MaxFrames:       2,
+		MaxLifetime:     100 * time.Millisecond,
+	}}
+	go server.Serve(l)
+
+	send := func(w *frame.Writer, tx string) {
+		f := frame.New(frame.SEND, frame.Destination, "/queue/tx", frame.Transaction, tx)
+		f.Body = []byte("hello")
+		c.Assert(w.Write(f), IsNil)
+	}
+
+	nc, reader, writer := rawConnect(c, l)
+	c.Assert(writer.Write(frame.New(frame.BEGIN, frame.Transaction, "tx1")), IsNil)
+	c.Assert(writer.Write(frame.New(frame.BEGIN, frame.Transaction, "tx2")), IsNil)
+	c.Check(expectError(c, nc, reader), Equals, "transaction limit exceeded: max-transactions")
+	nc.Close()
+
+	nc, reader, writer = rawConnect(c, l)
+	c.Assert(writer.Write(frame.New(frame.BEGIN, frame.Transaction, "tx1")), IsNil)
+	for i := 0; i < 3; i++ {
+		send(writer, "tx1")
+	}
+	c.Check(expectError(c, nc, reader), Equals, "transaction limit exceeded: max-frames")
+	nc.Close()
+
+	nc, reader, writer = rawConnect(c, l)
+	c.Assert(writer.Write(frame.New(frame.BEGIN, frame.Transaction, "tx1")), IsNil)
+	send(writer, "tx1")
+	c.Check(expectError(c, nc, reader), Equals, "transaction limit exceeded: max-lifetime")
+	nc.Close()
+}
+
+func (s *TransactionSuite) TestStats(c *C) {
+	l := listenLocal(c)
+	defer l.Close()
+	server := &Server{}
+	go server.Serve(l)
+
+	nc, _, writer := rawConnect(c, l)
+	defer nc.Close()
+	c.Assert(writer.Write(frame.New(frame.BEGIN, frame.Transaction, "tx1")), IsNil)
+	f := frame.New(frame.SEND, frame.Destination, "/queue/tx", frame.Transaction, "tx1")
+	f.Body = []byte(strings.Repeat("x", 1000))
+	c.Assert(writer.Write(f), IsNil)
+
+	var stats Stats
+	for i := 0; i < 100; i++ {
+		var err error
+		stats, err = server.Stats()
+		if err == nil && stats.TransactionBytes > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Check(stats.Connections, Equals, 1)
+	c.Check(stats.Transactions, Equals, 1)
+	c.Check(stats.TransactionBytes > 1000, Equals, true)
+
+	c.Assert(writer.Write(frame.New(frame.COMMIT, frame.Transaction, "tx1", frame.Receipt, "1")), IsNil)
+	for i := 0; i < 100; i++ {
+		stats, _ = server.Stats()
+		if stats.TransactionBytes == 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Check(stats.Transactions, Equals, 0)
+	c.Check(stats.TransactionBytes, Equals, int64(0))
+}
@@ -17,8 +17,10 @@ import (
 	"log"
 	"net"
 	"os"
+	"strings"
 
 	"github.com/go-stomp/stomp/v3/server"
+	"github.com/go-stomp/stomp/v3/server/queue"
 )
 
 // TODO: experimenting with ways to gracefully shutdown the server,
@@ -43,6 +45,23 @@ func main() {
 
 var listenAddr = flag.String("addr", ":61613", "Listen address")
 var helpFlag = flag.Bool("help", false, "Show this help text")
+var brokerId = flag.String("broker-id", "", "Unique broker id, required for federation")
+var peers = flag.String("peers", "", "Comma-separated addresses of federated peer brokers")
+var maxHops = flag.Int("max-hops", 1, "Maximum number of federation links a message can travel")
+var federationLogin = flag.String("federation-login", "", "Login of the federated peer brokers, required for federation")
+var federationPasscode = flag.String("federation-passcode", "", "Passcode of the federated peer brokers")
+var federateQueues = flag.Bool("federate-queues", false, "Pull queued messages from peer brokers when local consumers are ready")
+var compression = flag.Bool("compression", false, "Accept offers from clients to compress connections")
+var sessionGrace = flag.Duration("session-grace", 0, "How long to keep the sessions of disconnected resumable clients, zero to disable")
+var paging = flag.Bool("paging", false, "Page queued messages to disk beyond the memory watermarks")
+var pagingDir = flag.String("paging-dir", "", "Directory for paged messages, default is the temporary directory")
+var queueMemory = flag.Int("queue-memory", 0, "Maximum bytes of queued messages in memory per queue, zero for no limit")
+var totalMemory = flag.Int("total-memory", 0, "Maximum bytes of queued messages in memory for all queues, zero for no limit")
+var schedules = flag.String("schedules", "", "JSON file of recurring publications")
+var tlsCert = flag.String("tls-cert", "", "PEM certificate file, enables TLS")
+var tlsKey = flag.String("tls-key", "", "PEM key file of the TLS certificate")
+var tlsCheck = flag.Duration("tls-check-interval", server.DefaultCertificateCheckInterval,
+	"Interval between checks of the TLS certificate files for changes, zero to reload on SIGHUP only")
 
 func main() {
 	flag.Parse()
@@ -52,12 +71,58 @@ func main() {
 		os.Exit(1)
 	}
 
+	s := &server.Server{Compression: *compression, SessionGracePeriod: *sessionGrace}
+	if *schedules != "" {
+		var err error
+		if s.Schedules, err = readSchedules(*schedules); err != nil {
+			log.Fatalf("failed to read schedules: %s", err.Error())
+		}
+	}
+
 	l, err := net.Listen("tcp", *listenAddr)
 	if err != nil {
 		log.Fatalf("failed to listen: %s", err.Error())
 	}
 	defer func() { l.Close() }()
 
+	if *paging {
+		s.QueueStorage = queue.NewPagingQueueStorage(*pagingDir, *queueMemory, *totalMemory)
+	}
+	if *peers != "" {
+		s.Federation = &server.Federation{
+			BrokerId: *brokerId,
+			Peers:    strings.Split(*peers, ","),
+			MaxHops:  *maxHops,
+			Queues:   *federateQueues,
+			Login:    *federationLogin,
+			Passcode: *federationPasscode,
+		}
+		s.Authenticator = peerAuthenticator{*federationLogin, *federationPasscode}
+	}
+
 	log.Println("listening on", l.Addr().Network(), l.Addr().String())
-	server.Serve(l)
+	if *tlsCert != "" {
+		s.CertificateCheckInterval = *tlsCheck
+		if s.CertificateCheckInterval == 0 {
+			s.CertificateCheckInterval = -1
+		}
+		go reloadCertificateOnSignal(s)
+		err = s.ServeTLS(l, *tlsCert, *tlsKey)
+	} else {
+		err = s.Serve(l)
+	}
+	if err != nil {
+		log.Fatalf("failed to serve: %s", err.Error())
+	}
+}
+
+// peerAuthenticator only accepts the federation login with its passcode,
+// so that other clients cannot pass as peer brokers. Other logins are
+// accepted without authentication, as they are without federation.
+type peerAuthenticator struct {
+	login, passcode string
+}
+
+func (a peerAuthenticator) Authenticate(login, passcode string) bool {
+	return login != a.login || passcode == a.passcode
 }
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"log"
+
+	"github.com/go-stomp/stomp/v3/server"
+)
+
+// reloadCertificateOnSignal reloads the TLS certificate of the
+// server each time a reload signal is received. If the certificate
+// cannot be loaded, the server logs the error and keeps the
+// previous certificate.
+func reloadCertificateOnSignal(s *server.Server) {
+	for sig := range newReloadChannel() {
+		log.Println("received signal:", sig)
+		if err := s.ReloadCertificate(); err == nil {
+			log.Println("reloaded TLS certificate")
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/server"
+)
+
+// scheduleConfig is the JSON representation of a recurring publication
+// in the file given by the -schedules flag, which contains an array of
+// them. For example:
+//
+//	[{"name": "tick", "interval": "1m", "destination": "/topic/tick"},
+//	 {"name": "nightly", "cron": "0 2 * * *", "location": "Europe/London",
+//	  "destination": "/queue/nightly", "headers": {"kind": "nightly"},
+//	  "body": "run", "jitter": "30s", "missed-runs": "skip"}]
+type scheduleConfig struct {
+	Name        string            `json:"name"`
+	Cron        string            `json:"cron"`
+	Interval    string            `json:"interval"`
+	Location    string            `json:"location"`
+	Destination string            `json:"destination"`
+	Headers     map[string]string `json:"headers"`
+	Body        string            `json:"body"`
+	Jitter      string            `json:"jitter"`
+	MissedRuns  string            `json:"missed-runs"` // "fire-once" (default), "fire-all" or "skip"
+}
+
+var missedRunPolicies = map[string]server.MissedRunPolicy{
+	"":          server.MissedRunFireOnce,
+	"fire-once": server.MissedRunFireOnce,
+	"fire-all":  server.MissedRunFireAll,
+	"skip":      server.MissedRunSkip,
+}
+
+// Reads the recurring publications from a JSON file.
+func readSchedules(filename string) ([]*server.Schedule, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var configs []scheduleConfig
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+
+	schedules := make([]*server.Schedule, 0, len(configs))
+	for _, config := range configs {
+		schedule, err := config.schedule()
+		if err != nil {
+			return nil, fmt.Errorf("%s: schedule %s: %v", filename, config.Name, err)
+		}
+		schedules = append(schedules, schedule)
+	}
+	return schedules, nil
+}
+
+func (config scheduleConfig) schedule() (*server.Schedule, error) {
+	schedule := &server.Schedule{
+		Name:        config.Name,
+		Cron:        config.Cron,
+		Destination: config.Destination,
+		Header:      config.Headers,
+		Body:        []byte(config.Body),
+	}
+
+	var err error
+	if config.Interval != "" {
+		if schedule.Interval, err = time.ParseDuration(config.Interval); err != nil {
+			return nil, err
+		}
+	}
+	if config.Jitter != "" {
+		if schedule.Jitter, err = time.ParseDuration(config.Jitter); err != nil {
+			return nil, err
+		}
+	}
+	if config.Location != "" {
+		if schedule.Location, err = time.LoadLocation(config.Location); err != nil {
+			return nil, err
+		}
+	}
+	policy, ok := missedRunPolicies[config.MissedRuns]
+	if !ok {
+		return nil, fmt.Errorf("invalid missed-runs: %q", config.MissedRuns)
+	}
+	schedule.MissedRuns = policy
+
+	if err := schedule.Validate(); err != nil {
+		return nil, err
+	}
+	return schedule, nil
+}
@@ -17,3 +17,12 @@ func newStopChannel() chan os.Signal {
 
 	return c
 }
+
+// newReloadChannel creates a channel for receiving signals
+// for reloading the TLS certificate. Calls an os-dependent
+// setupReloadSignals function.
+func newReloadChannel() chan os.Signal {
+	c := make(chan os.Signal, 1)
+	setupReloadSignals(c)
+	return c
+}
@@ -16,8 +16,39 @@ var SubscribeOpt struct {
 	// Header provides the opportunity to include custom header entries
 	// in the SUBSCRIBE frame that the client sends to the server.
 	Header func(key, value string) func(*frame.Frame) error
+
+	// LocalSelector filters the messages of the subscription in the client,
+	// for STOMP servers that do not support the "selector" header entry.
+	// The expression is a SQL-92 style condition over the header entries
+	// of a message, as described in package selector. Messages for which
+	// the expression is not true are not sent to the Subscription.C channel,
+	// and are acknowledged, so that they do not block a subscription with
+	// AckClientIndividual. The acknowledgements are sent in the order the
+	// messages are received. Conn.Subscribe returns an error if the expression
+	// is not valid.
+	//
+	// Conn.Subscribe returns ErrCumulativeAck if the subscription uses
+	// AckClient, as acknowledging a filtered message would also acknowledge
+	// the messages delivered before it that the client program has not
+	// acknowledged yet. Deferring the acknowledgement until those messages
+	// have been acknowledged does not help either, as the client program can
+	// acknowledge a later message in the meantime, after which the deferred
+	// acknowledgement refers to a message that has already been acknowledged.
+	LocalSelector func(expr string) func(*frame.Frame) error
+
+	// NackFiltered causes the messages filtered by LocalSelector to be
+	// negatively acknowledged, so that the STOMP server can deliver them
+	// to other subscriptions, rather than acknowledged.
+	NackFiltered func(*frame.Frame) error
 }
 
+// Header entries of a SUBSCRIBE frame that hold the local selector options.
+// They are removed by Conn.Subscribe before the frame is sent.
+const (
+	localSelectorHeader = "local-selector"
+	nackFilteredHeader  = "local-selector-nack"
+)
+
 func init() {
 	SubscribeOpt.Id = func(id string) func(*frame.Frame) error {
 		return func(f *frame.Frame) error {
@@ -39,4 +70,22 @@ func init() {
 			return nil
 		}
 	}
+
+	SubscribeOpt.LocalSelector = func(expr string) func(*frame.Frame) error {
+		return func(f *frame.Frame) error {
+			if f.Command != frame.SUBSCRIBE {
+				return ErrInvalidCommand
+			}
+			f.Header.Set(localSelectorHeader, expr)
+			return nil
+		}
+	}
+
+	SubscribeOpt.NackFiltered = func(f *frame.Frame) error {
+		if f.Command != frame.SUBSCRIBE {
+			return ErrInvalidCommand
+		}
+		f.Header.Set(nackFilteredHeader, "true")
+		return nil
+	}
 }
@@ -6,6 +6,7 @@ import (
 	"sync/atomic"
 
 	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/go-stomp/stomp/v3/selector"
 )
 
 const (
@@ -27,6 +28,22 @@ type Subscription struct {
 	state       int32
 	closeMutex  *sync.Mutex
 	closeCond   *sync.Cond
+
+	selector      *selector.Selector // from SubscribeOpt.LocalSelector, or nil
+	nackFiltered  bool
+	selectorStats *selectorStats
+	acks          chan *Message // filtered messages to acknowledge, or nil
+}
+
+// SelectorStats contains counters for the messages filtered
+// by a subscription with SubscribeOpt.LocalSelector.
+type SelectorStats struct {
+	Matched  uint64 // Number of messages sent to the C channel
+	Filtered uint64 // Number of messages that did not match the selector
+}
+
+type selectorStats struct {
+	matched, filtered uint64
 }
 
 // BUG(jpj): If the client does not read messages from the Subscription.C
@@ -56,6 +73,24 @@ func (s *Subscription) Active() bool {
 	return atomic.LoadInt32(&s.state) == subStateActive
 }
 
+// Selector returns the expression of the local selector specified when
+// the subscription was created, or an empty string if there is none.
+func (s *Subscription) Selector() string {
+	if s.selector == nil {
+		return ""
+	}
+	return s.selector.String()
+}
+
+// SelectorStats returns the counters for the messages filtered
+// by the local selector of the subscription.
+func (s *Subscription) SelectorStats() SelectorStats {
+	return SelectorStats{
+		Matched:  atomic.LoadUint64(&s.selectorStats.matched),
+		Filtered: atomic.LoadUint64(&s.selectorStats.filtered),
+	}
+}
+
 // Unsubscribes and closes the channel C.
 func (s *Subscription) Unsubscribe(opts ...func(*frame.Frame) error) error {
 	// transition to the "closing" state
@@ -119,6 +154,9 @@ func (s *Subscription) closeChannel(msg *Message) {
 }
 
 func (s *Subscription) readLoop(ch chan *frame.Frame) {
+	if s.acks != nil {
+		defer close(s.acks)
+	}
 	for {
 		f, ok := <-ch
 		if !ok {
@@ -145,6 +183,14 @@ func (s *Subscription) readLoop(ch chan *frame.Frame) {
 				Header:       f.Header,
 				Body:         f.Body,
 			}
+			if s.selector != nil {
+				if !s.selector.Matches(f.Header.Contains) {
+					atomic.AddUint64(&s.selectorStats.filtered, 1)
+					s.filter(msg)
+					continue
+				}
+				atomic.AddUint64(&s.selectorStats.matched, 1)
+			}
 			s.C <- msg
 		} else if f.Command == frame.ERROR {
 			state := atomic.LoadInt32(&s.state)
@@ -181,3 +227,30 @@ func (s *Subscription) readLoop(ch chan *frame.Frame) {
 		}
 	}
 }
+
+// Queues a message that did not match the local selector, so that
+// ackLoop acknowledges it. The read loop waits while the queue is full.
+func (s *Subscription) filter(msg *Message) {
+	if s.acks != nil {
+		s.acks <- msg
+	}
+}
+
+// Acknowledges, or negatively acknowledges, the messages that did not
+// match the local selector, in the order they were received. The frames
+// are sent from this goroutine, as the read loop must not block the
+// goroutine that sends it MESSAGE frames.
+func (s *Subscription) ackLoop() {
+	for msg := range s.acks {
+		var err error
+		if s.nackFiltered {
+			err = s.conn.Nack(msg)
+		} else {
+			err = s.conn.Ack(msg)
+		}
+		if err != nil {
+			s.conn.log.Warningf("Subscription %s: %s: cannot acknowledge filtered message: %s",
+				s.id, s.destination, err.Error())
+		}
+	}
+}